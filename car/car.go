@@ -0,0 +1,227 @@
+// Package car implements reading and writing of CARv1 (Content Addressable
+// aRchive) files, which bundle the blocks of one or more DAGs together with
+// their roots so they can be moved between nodes without altering any CIDs.
+package car
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// Version is the version of the CAR format written by this package.
+const Version = 1
+
+// maxSectionSize bounds the size of a single header or block section so that
+// a corrupted length prefix can't make us allocate arbitrary amounts of
+// memory.
+const maxSectionSize = 32 << 20
+
+// loadBatchSize is the number of blocks buffered before they are written to
+// the blockstore by LoadCar.
+const loadBatchSize = 1000
+
+func init() {
+	cbor.RegisterCborType(Header{})
+}
+
+// Header is the dag-cbor encoded header at the start of every CAR file.
+type Header struct {
+	Roots   []cid.Cid `refmt:"roots"`
+	Version uint64    `refmt:"version"`
+}
+
+// WriteCar writes a CARv1 archive containing every block reachable from the
+// given roots to w. Blocks are fetched from ng and each block is written at
+// most once.
+func WriteCar(ctx context.Context, ng ipld.NodeGetter, roots []cid.Cid, w io.Writer) error {
+	h := &Header{
+		Roots:   roots,
+		Version: Version,
+	}
+
+	hb, err := cbor.DumpObject(h)
+	if err != nil {
+		return err
+	}
+
+	if err := writeSection(w, hb); err != nil {
+		return err
+	}
+
+	seen := cid.NewSet()
+	for _, r := range roots {
+		if err := writeDag(ctx, ng, r, seen, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeDag(ctx context.Context, ng ipld.NodeGetter, c cid.Cid, seen *cid.Set, w io.Writer) error {
+	if !seen.Visit(c) {
+		return nil
+	}
+
+	nd, err := ng.Get(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	if err := writeSection(w, c.Bytes(), nd.RawData()); err != nil {
+		return err
+	}
+
+	for _, l := range nd.Links() {
+		if err := writeDag(ctx, ng, l.Cid, seen, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSection writes the concatenation of parts prefixed with its length as
+// an unsigned varint.
+func writeSection(w io.Writer, parts ...[]byte) error {
+	var size uint64
+	for _, p := range parts {
+		size += uint64(len(p))
+	}
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, size)
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+
+	for _, p := range parts {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reader reads the blocks of a CARv1 archive one at a time.
+type Reader struct {
+	br     *bufio.Reader
+	Header *Header
+}
+
+// NewReader reads and validates the header of the CAR archive in r.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+
+	hb, err := readSection(br)
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("car: missing header")
+		}
+		return nil, err
+	}
+
+	h := new(Header)
+	if err := cbor.DecodeInto(hb, h); err != nil {
+		return nil, fmt.Errorf("car: invalid header: %s", err)
+	}
+
+	if h.Version != Version {
+		return nil, fmt.Errorf("car: unsupported version %d", h.Version)
+	}
+
+	return &Reader{br: br, Header: h}, nil
+}
+
+// Next returns the next block in the archive, verifying that its data
+// matches its CID. It returns io.EOF once all blocks have been read.
+func (cr *Reader) Next() (blocks.Block, error) {
+	data, err := readSection(cr.br)
+	if err != nil {
+		return nil, err
+	}
+
+	n, c, err := cid.CidFromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("car: invalid block cid: %s", err)
+	}
+	data = data[n:]
+
+	chk, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if !chk.Equals(c) {
+		return nil, fmt.Errorf("car: block data does not match cid %s", c)
+	}
+
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// readSection reads a single varint length-prefixed section. It returns
+// io.EOF only if the reader is exhausted before the section starts.
+func readSection(br *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("car: invalid section length: %s", err)
+	}
+
+	if size == 0 || size > maxSectionSize {
+		return nil, fmt.Errorf("car: invalid section length %d", size)
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// LoadCar reads a CARv1 archive from r and stores all of its blocks in bs,
+// returning the archive header.
+func LoadCar(bs bstore.Blockstore, r io.Reader) (*Header, error) {
+	cr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := make([]blocks.Block, 0, loadBatchSize)
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		batch = append(batch, blk)
+		if len(batch) == loadBatchSize {
+			if err := bs.PutMany(batch); err != nil {
+				return nil, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := bs.PutMany(batch); err != nil {
+			return nil, err
+		}
+	}
+
+	return cr.Header, nil
+}
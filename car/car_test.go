@@ -0,0 +1,90 @@
+package car
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	dag "github.com/ipfs/go-merkledag"
+	mdtest "github.com/ipfs/go-merkledag/test"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+func TestRoundtrip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dserv := mdtest.Mock()
+	a := dag.NodeWithData([]byte("aaaa"))
+	b := dag.NodeWithData([]byte("bbbb"))
+	c := dag.NodeWithData([]byte("cccc"))
+
+	if err := b.AddNodeLink("c", c); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddNodeLink("b", b); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddNodeLink("c", c); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, nd := range []*dag.ProtoNode{a, b, c} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := WriteCar(ctx, dserv, []cid.Cid{a.Cid()}, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	bs := bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	h, err := LoadCar(bs, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(h.Roots) != 1 || !h.Roots[0].Equals(a.Cid()) {
+		t.Fatalf("unexpected roots: %v", h.Roots)
+	}
+
+	for _, nd := range []*dag.ProtoNode{a, b, c} {
+		blk, err := bs.Get(nd.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(blk.RawData(), nd.RawData()) {
+			t.Fatalf("block %s changed during roundtrip", nd.Cid())
+		}
+	}
+}
+
+func TestCorruptBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dserv := mdtest.Mock()
+	a := dag.NodeWithData([]byte("aaaa"))
+	if err := dserv.Add(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := WriteCar(ctx, dserv, []cid.Cid{a.Cid()}, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	data[len(data)-1] ^= 0xff
+
+	bs := bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	if _, err := LoadCar(bs, bytes.NewReader(data)); err == nil {
+		t.Fatal("expected corrupted block to be rejected")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	_ "expvar"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"time"
 
 	version "github.com/ipfs/go-ipfs"
 	utilmain "github.com/ipfs/go-ipfs/cmd/ipfs/util"
@@ -22,12 +24,16 @@ import (
 	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
 	libp2p "github.com/ipfs/go-ipfs/core/node/libp2p"
 	nodeMount "github.com/ipfs/go-ipfs/fuse/node"
+	pinqueue "github.com/ipfs/go-ipfs/pin/queue"
+	repo "github.com/ipfs/go-ipfs/repo"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
 	migrate "github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/ipfs/go-ipfs-cmds"
 	mprome "github.com/ipfs/go-metrics-prometheus"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
+	path "github.com/ipfs/interface-go-ipfs-core/path"
 	goprocess "github.com/jbenet/goprocess"
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/multiformats/go-multiaddr-net"
@@ -397,6 +403,18 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 		return err
 	}
 
+	// remove expired pins
+	pinErrc := make(chan error)
+	go func() {
+		pinErrc <- corerepo.PeriodicPinExpiry(req.Context, node)
+		close(pinErrc)
+	}()
+
+	// pin the requests of 'ipfs pin add --background'
+	if err := startPinQueue(req.Context, node); err != nil {
+		return err
+	}
+
 	// construct http gateway - if it is set in the config
 	var gwErrc <-chan error
 	if len(cfg.Addresses.Gateway) > 0 {
@@ -423,7 +441,7 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 	// collect long-running errors and block for shutdown
 	// TODO(cryptix): our fuse currently doesnt follow this pattern for graceful shutdown
 	var errs error
-	for err := range merge(apiErrc, gwErrc, gcErrc) {
+	for err := range merge(apiErrc, gwErrc, gcErrc, pinErrc) {
 		if err != nil {
 			errs = multierror.Append(errs, err)
 		}
@@ -454,15 +472,17 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 			return nil, fmt.Errorf("serveHTTPApi: invalid API address: %q (err: %s)", apiAddr, err)
 		}
 
-		apiLis, err := manet.Listen(apiMaddr)
+		apiLis, err := corehttp.Listen(apiMaddr, corehttp.APISocketMode)
 		if err != nil {
-			return nil, fmt.Errorf("serveHTTPApi: manet.Listen(%s) failed: %s", apiMaddr, err)
+			return nil, fmt.Errorf("serveHTTPApi: corehttp.Listen(%s) failed: %s", apiMaddr, err)
 		}
 
 		// we might have listened to /tcp/0 - lets see what we are listing on
 		apiMaddr = apiLis.Multiaddr()
 		fmt.Printf("API server listening on %s\n", apiMaddr)
-		fmt.Printf("WebUI: http://%s/webui\n", apiLis.Addr())
+		if !corehttp.IsUnixAddr(apiMaddr) {
+			fmt.Printf("WebUI: http://%s/webui\n", apiLis.Addr())
+		}
 		listeners = append(listeners, apiLis)
 	}
 
@@ -572,9 +592,9 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 			return nil, fmt.Errorf("serveHTTPGateway: invalid gateway address: %q (err: %s)", addr, err)
 		}
 
-		gwLis, err := manet.Listen(gatewayMaddr)
+		gwLis, err := corehttp.Listen(gatewayMaddr, corehttp.GatewaySocketMode)
 		if err != nil {
-			return nil, fmt.Errorf("serveHTTPGateway: manet.Listen(%s) failed: %s", gatewayMaddr, err)
+			return nil, fmt.Errorf("serveHTTPGateway: corehttp.Listen(%s) failed: %s", gatewayMaddr, err)
 		}
 		// we might have listened to /tcp/0 - lets see what we are listing on
 		gatewayMaddr = gwLis.Multiaddr()
@@ -591,9 +611,20 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 	cmdctx := *cctx
 	cmdctx.Gateway = true
 
+	node, err := cctx.ConstructNode()
+	if err != nil {
+		return nil, fmt.Errorf("serveHTTPGateway: ConstructNode() failed: %s", err)
+	}
+
+	var timeouts corehttp.GatewayTimeouts
+	if _, err := repo.ReadExtendedConfig(node.Repo, corehttp.GatewayTimeoutsConfigKey, &timeouts); err != nil {
+		return nil, fmt.Errorf("serveHTTPGateway: invalid %s config: %s", corehttp.GatewayTimeoutsConfigKey, err)
+	}
+
 	var opts = []corehttp.ServeOption{
 		corehttp.MetricsCollectionOption("gateway"),
 		corehttp.IPNSHostnameOption(),
+		corehttp.GatewayTimeoutsOption(timeouts),
 		corehttp.GatewayOption(writable, "/ipfs", "/ipns"),
 		corehttp.VersionOption(),
 		corehttp.CheckVersionOption(),
@@ -604,13 +635,26 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 		opts = append(opts, corehttp.ProxyOption())
 	}
 
+	var pinningService corehttp.PinningServiceConfig
+	if _, err := repo.ReadExtendedConfig(node.Repo, corehttp.PinningServiceConfigKey, &pinningService); err != nil {
+		return nil, fmt.Errorf("serveHTTPGateway: invalid %s config: %s", corehttp.PinningServiceConfigKey, err)
+	}
+	if pinningService.Enabled {
+		opts = append(opts, corehttp.PinningServiceOption())
+	}
+
 	if len(cfg.Gateway.RootRedirect) > 0 {
 		opts = append(opts, corehttp.RedirectOption("", cfg.Gateway.RootRedirect))
 	}
 
-	node, err := cctx.ConstructNode()
-	if err != nil {
-		return nil, fmt.Errorf("serveHTTPGateway: ConstructNode() failed: %s", err)
+	var publicGateways map[string]*corehttp.GatewaySpec
+	if _, err := repo.ReadExtendedConfig(node.Repo, corehttp.PublicGatewaysConfigKey, &publicGateways); err != nil {
+		return nil, fmt.Errorf("serveHTTPGateway: invalid %s config: %s", corehttp.PublicGatewaysConfigKey, err)
+	}
+	if len(publicGateways) > 0 {
+		// must run before IPNSHostnameOption so subdomains aren't looked up
+		// as DNSLink names
+		opts = append([]corehttp.ServeOption{corehttp.SubdomainGatewayOption(publicGateways)}, opts...)
 	}
 
 	errc := make(chan error)
@@ -676,6 +720,24 @@ func maybeRunGC(req *cmds.Request, node *core.IpfsNode) (<-chan error, error) {
 	return errc, nil
 }
 
+// startPinQueue pins the queued background pins until ctx is done
+func startPinQueue(ctx context.Context, node *core.IpfsNode) error {
+	api, err := coreapi.NewCoreAPI(node)
+	if err != nil {
+		return err
+	}
+	napi := api.Pin().(coreapi.NamedPinAPI)
+
+	node.PinQueue.Start(ctx, pinqueue.DefaultWorkers, func(ctx context.Context, r *pinqueue.Request, progress func(uint64)) error {
+		var expires time.Time
+		if r.Expires != nil {
+			expires = *r.Expires
+		}
+		return napi.AddWithProgress(ctx, path.New(r.Path), r.Name, r.Meta, expires, progress, options.Pin.Recursive(r.Recursive))
+	})
+	return nil
+}
+
 // merge does fan-in of multiple read-only error channels
 // taken from http://blog.golang.org/pipelines
 func merge(cs ...<-chan error) <-chan error {
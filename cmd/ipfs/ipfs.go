@@ -92,5 +92,7 @@ var cmdDetailsMap = map[string]cmdDetails{
 	"diag/cmds":   {cannotRunOnClient: true},
 	"repo/fsck":   {cannotRunOnDaemon: true},
 	"config/edit": {cannotRunOnDaemon: true, doesNotUseRepo: true},
+	"key/encrypt": {cannotRunOnDaemon: true, doesNotUseRepo: true},
+	"key/export":  {cannotRunOnDaemon: true},
 	"cid":         {doesNotUseRepo: true},
 }
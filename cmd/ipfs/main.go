@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"net"
+	nethttp "net/http"
 	"os"
 	"path/filepath"
 	"runtime/pprof"
@@ -15,6 +17,7 @@ import (
 	util "github.com/ipfs/go-ipfs/cmd/ipfs/util"
 	oldcmds "github.com/ipfs/go-ipfs/commands"
 	core "github.com/ipfs/go-ipfs/core"
+	apitoken "github.com/ipfs/go-ipfs/core/apitoken"
 	corecmds "github.com/ipfs/go-ipfs/core/commands"
 	corehttp "github.com/ipfs/go-ipfs/core/corehttp"
 	loader "github.com/ipfs/go-ipfs/plugin/loader"
@@ -263,7 +266,7 @@ func makeExecutor(req *cmds.Request, env interface{}) (cmds.Executor, error) {
 	if err != nil {
 		return nil, err
 	}
-	_, host, err := manet.DialArgs(apiAddr)
+	network, host, err := manet.DialArgs(apiAddr)
 	if err != nil {
 		return nil, err
 	}
@@ -273,6 +276,32 @@ func makeExecutor(req *cmds.Request, env interface{}) (cmds.Executor, error) {
 		http.ClientWithAPIPrefix(corehttp.APIPath),
 	}
 
+	var transport nethttp.RoundTripper
+	if network == "unix" {
+		// Talk HTTP over the unix domain socket, the host is only used in
+		// the request URLs.
+		sockPath := host
+		transport = &nethttp.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sockPath)
+			},
+		}
+		host = "unix"
+	}
+
+	// Authenticate with the API token from the environment, if any.
+	if token := os.Getenv(apitoken.EnvToken); token != "" {
+		if transport == nil {
+			transport = nethttp.DefaultTransport
+		}
+		transport = &bearerTransport{token: token, next: transport}
+	}
+
+	if transport != nil {
+		opts = append(opts, http.ClientWithHTTPClient(&nethttp.Client{Transport: transport}))
+	}
+
 	// Fallback on a local executor if we (a) have a repo and (b) aren't
 	// forcing a daemon.
 	if !daemonRequested && fsrepo.IsInitialized(cctx.ConfigRoot) {
@@ -282,6 +311,24 @@ func makeExecutor(req *cmds.Request, env interface{}) (cmds.Executor, error) {
 	return http.NewClient(host, opts...), nil
 }
 
+// bearerTransport adds an API token to the requests made to the daemon.
+type bearerTransport struct {
+	token string
+	next  nethttp.RoundTripper
+}
+
+func (t *bearerTransport) RoundTrip(req *nethttp.Request) (*nethttp.Response, error) {
+	// RoundTrippers must not modify the request
+	r := new(nethttp.Request)
+	*r = *req
+	r.Header = make(nethttp.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "Bearer "+t.token)
+	return t.next.RoundTrip(r)
+}
+
 func checkPermissions(path string) (bool, error) {
 	_, err := os.Open(path)
 	if os.IsNotExist(err) {
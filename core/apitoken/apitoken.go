@@ -0,0 +1,245 @@
+// Package apitoken implements the bearer tokens used to authenticate requests
+// to the HTTP API.
+//
+// Tokens are stored in the config under ConfigKey, by name. Only the SHA-256
+// hash of a token is stored. Each token is scoped to a list of command paths,
+// like "cat" or "pin/add", which also allow the subcommands of the command.
+// While no token is configured, the API doesn't require authentication.
+package apitoken
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	repo "github.com/ipfs/go-ipfs/repo"
+
+	mbase "github.com/multiformats/go-multibase"
+)
+
+// ConfigKey is the config key holding the API tokens.
+const ConfigKey = "API.Tokens"
+
+// EnvToken is the environment variable holding the token sent by the ipfs
+// command to the API.
+const EnvToken = "IPFS_API_TOKEN"
+
+// AllowAll is the command path allowing every command.
+const AllowAll = "*"
+
+// tokenSize is the number of random bytes of generated tokens.
+const tokenSize = 32
+
+// Token is an API token as stored in the config.
+type Token struct {
+	// Hash is the hex encoded SHA-256 hash of the token.
+	Hash string
+
+	// Allow lists the command paths the token may run.
+	Allow []string
+
+	// Name is the name of the token in the config, filled when tokens are
+	// read.
+	Name string `json:"-"`
+}
+
+// Allows returns whether the token may run the command at cmdPath, for
+// example "pin/add".
+func (t *Token) Allows(cmdPath string) bool {
+	cmdPath = strings.Trim(cmdPath, "/")
+	for _, a := range t.Allow {
+		a = strings.Trim(a, "/")
+		if a == AllowAll || a == cmdPath || strings.HasPrefix(cmdPath, a+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers returns whether the token allows every command path in allow, that
+// is whether a token restricted to allow would not grant more than t.
+func (t *Token) Covers(allow []string) bool {
+	for _, a := range allow {
+		if !t.Allows(a) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseAllow splits a comma-separated list of command paths.
+func ParseAllow(s string) []string {
+	var allow []string
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.Trim(strings.TrimSpace(a), "/"); a != "" {
+			allow = append(allow, a)
+		}
+	}
+	return allow
+}
+
+// Hash returns the hash of token, as stored in the config.
+func Hash(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// Generate returns a new random token.
+func Generate() (string, error) {
+	b := make([]byte, tokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return mbase.Encode(mbase.Base32, b)
+}
+
+// Load reads the tokens configured in r, by name.
+func Load(r repo.Repo) (map[string]*Token, error) {
+	raw, err := readConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	return decode(raw)
+}
+
+// readConfig returns the JSON encoded value of ConfigKey.
+func readConfig(r repo.Repo) ([]byte, error) {
+	var v interface{}
+	if _, err := repo.ReadExtendedConfig(r, ConfigKey, &v); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", ConfigKey, err)
+	}
+	return json.Marshal(v)
+}
+
+func decode(raw []byte) (map[string]*Token, error) {
+	tokens := make(map[string]*Token)
+	if err := json.Unmarshal(raw, &tokens); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", ConfigKey, err)
+	}
+	for name, t := range tokens {
+		if t == nil || t.Hash == "" {
+			return nil, fmt.Errorf("invalid %s: token %s has no hash", ConfigKey, name)
+		}
+		t.Name = name
+	}
+	return tokens, nil
+}
+
+// Save replaces the tokens configured in r.
+func Save(r repo.Repo, tokens map[string]*Token) error {
+	return r.SetConfigKey(ConfigKey, tokens)
+}
+
+// Names returns the names of tokens, sorted.
+func Names(tokens map[string]*Token) []string {
+	names := make([]string, 0, len(tokens))
+	for name := range tokens {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Authorizer checks the tokens of API requests against the tokens configured
+// in a repo. The tokens are reloaded whenever the config changes, however it
+// is changed.
+type Authorizer struct {
+	repo repo.Repo
+
+	lk     sync.RWMutex
+	stamp  repo.ConfigStamp
+	raw    []byte
+	byHash map[string]*Token
+}
+
+// NewAuthorizer returns an Authorizer for the tokens configured in r.
+func NewAuthorizer(r repo.Repo) (*Authorizer, error) {
+	a := &Authorizer{repo: r}
+	if err := a.refresh(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Enabled returns whether requests must be authenticated, that is whether
+// some token is configured.
+func (a *Authorizer) Enabled() (bool, error) {
+	if err := a.refresh(); err != nil {
+		return false, err
+	}
+
+	a.lk.RLock()
+	defer a.lk.RUnlock()
+	return len(a.byHash) > 0, nil
+}
+
+// Authorize returns the token matching the bearer token, or nil if it doesn't
+// match any token.
+func (a *Authorizer) Authorize(bearer string) (*Token, error) {
+	if err := a.refresh(); err != nil {
+		return nil, err
+	}
+
+	a.lk.RLock()
+	defer a.lk.RUnlock()
+	return a.byHash[Hash(bearer)], nil
+}
+
+// refresh reloads the tokens if the config changed since they were loaded.
+// The config is only read again once its stamp changes, if the repo has
+// stamps.
+func (a *Authorizer) refresh() error {
+	var stamp repo.ConfigStamp
+	stamper, stamped := a.repo.(repo.ConfigStamper)
+	if stamped {
+		var err error
+		if stamp, err = stamper.ConfigStamp(); err != nil {
+			return err
+		}
+		a.lk.RLock()
+		fresh := a.byHash != nil && stamp.Equal(a.stamp)
+		a.lk.RUnlock()
+		if fresh {
+			return nil
+		}
+	}
+
+	raw, err := readConfig(a.repo)
+	if err != nil {
+		return err
+	}
+
+	a.lk.RLock()
+	fresh := a.byHash != nil && bytes.Equal(raw, a.raw)
+	a.lk.RUnlock()
+	if fresh {
+		if stamped {
+			a.lk.Lock()
+			a.stamp = stamp
+			a.lk.Unlock()
+		}
+		return nil
+	}
+
+	tokens, err := decode(raw)
+	if err != nil {
+		return err
+	}
+	byHash := make(map[string]*Token, len(tokens))
+	for _, t := range tokens {
+		byHash[strings.ToLower(t.Hash)] = t
+	}
+
+	a.lk.Lock()
+	a.byHash = byHash
+	a.raw = raw
+	a.stamp = stamp
+	a.lk.Unlock()
+	return nil
+}
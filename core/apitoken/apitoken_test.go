@@ -0,0 +1,60 @@
+package apitoken
+
+import "testing"
+
+func TestTokenAllows(t *testing.T) {
+	tok := &Token{Allow: []string{"cat", "/pin/add/", "name"}}
+	for cmdPath, allowed := range map[string]bool{
+		"cat":          true,
+		"/cat/":        true,
+		"catalog":      false,
+		"pin/add":      true,
+		"pin/rm":       false,
+		"pin":          false,
+		"name/publish": true,
+		"key/rm":       false,
+		"":             false,
+	} {
+		if tok.Allows(cmdPath) != allowed {
+			t.Errorf("expected Allows(%q) to be %v", cmdPath, allowed)
+		}
+	}
+
+	if !(&Token{Allow: []string{AllowAll}}).Allows("shutdown") {
+		t.Error("expected * to allow every command")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	a, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Fatal("expected different tokens")
+	}
+	if Hash(a) == Hash(b) || Hash(a) != Hash(a) {
+		t.Fatal("unexpected token hashes")
+	}
+}
+
+func TestTokenCovers(t *testing.T) {
+	tok := &Token{Allow: []string{"cat", "pin"}}
+	for _, test := range []struct {
+		allow  []string
+		covers bool
+	}{
+		{[]string{"cat"}, true},
+		{[]string{"pin/add", "cat"}, true},
+		{[]string{"pin", "name"}, false},
+		{[]string{AllowAll}, false},
+	} {
+		if tok.Covers(test.allow) != test.covers {
+			t.Errorf("expected Covers(%q) to be %v", test.allow, test.covers)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	apitoken "github.com/ipfs/go-ipfs/core/apitoken"
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+)
+
+var APICmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage access to the HTTP API.",
+		ShortDescription: `
+Once an API token is defined, every request to the HTTP API must carry a
+token in an 'Authorization: Bearer <token>' header. Each token may only run
+the commands it allows, other commands are rejected with 403 Forbidden.
+
+The ipfs command sends the token set in the IPFS_API_TOKEN environment
+variable.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"token": apiTokenCmd,
+	},
+}
+
+var apiTokenCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the access tokens of the HTTP API.",
+		ShortDescription: `
+API tokens are stored in the API.Tokens config section, which holds the
+SHA-256 hash of each token and the command paths it allows, by name:
+
+  "API": {
+    "Tokens": {
+      "ci": {
+        "Hash": "<hex encoded SHA-256 hash of the token>",
+        "Allow": ["cat", "pin/add"]
+      }
+    }
+  }
+
+A command path also allows the subcommands of the command, and '*' allows
+every command.
+
+Tokens also authorize writes to a writable gateway: 'gateway/ipfs' allows
+writing to /ipfs/ paths and 'gateway/ipns/<key>' allows updating the name of
+the given key. 'pinning-service' allows the use of the pinning service served
+by the gateway when Pinning.Service.Enabled is set.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"create": apiTokenCreateCmd,
+		"ls":     apiTokenLsCmd,
+		"rm":     apiTokenRmCmd,
+	},
+}
+
+// APITokenOutput is the output type of the api token commands. Token is only
+// set when a token is created.
+type APITokenOutput struct {
+	Name  string
+	Allow []string
+	Token string `json:",omitempty"`
+}
+
+// APITokenList is the output type of 'ipfs api token ls'.
+type APITokenList struct {
+	Tokens []APITokenOutput
+}
+
+const apiTokenAllowOptionName = "allow"
+
+var apiTokenCreateCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Create an API token and print it.",
+		ShortDescription: `
+Creates a random API token allowed to run the given commands. The token is
+only printed once, the config only keeps its hash. Through an authenticated
+API, a token may only create tokens allowing commands it is allowed itself.
+
+  > ipfs api token create --allow=cat,pin/add ci
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("name", true, false, "Name of the token."),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(apiTokenAllowOptionName, "a", "Comma-separated command paths the token may run, '*' for all."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		allowStr, _ := req.Options[apiTokenAllowOptionName].(string)
+		allow := apitoken.ParseAllow(allowStr)
+		if len(allow) == 0 {
+			return fmt.Errorf("specify the commands the token may run with --%s", apiTokenAllowOptionName)
+		}
+
+		name := req.Arguments[0]
+		tokens, err := apitoken.Load(n.Repo)
+		if err != nil {
+			return err
+		}
+		if _, ok := tokens[name]; ok {
+			return fmt.Errorf("API token %s already exists", name)
+		}
+
+		token, err := apitoken.Generate()
+		if err != nil {
+			return err
+		}
+		tokens[name] = &apitoken.Token{Hash: apitoken.Hash(token), Allow: allow}
+		if err := apitoken.Save(n.Repo, tokens); err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &APITokenOutput{
+			Name:  name,
+			Allow: allow,
+			Token: token,
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *APITokenOutput) error {
+			_, err := fmt.Fprintln(w, out.Token)
+			return err
+		}),
+	},
+	Type: APITokenOutput{},
+}
+
+var apiTokenLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List API tokens and the commands they allow.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		tokens, err := apitoken.Load(n.Repo)
+		if err != nil {
+			return err
+		}
+
+		list := make([]APITokenOutput, 0, len(tokens))
+		for _, name := range apitoken.Names(tokens) {
+			list = append(list, APITokenOutput{Name: name, Allow: tokens[name].Allow})
+		}
+		return cmds.EmitOnce(res, &APITokenList{list})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, list *APITokenList) error {
+			tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			for _, t := range list.Tokens {
+				fmt.Fprintf(tw, "%s\t%s\n", t.Name, strings.Join(t.Allow, ","))
+			}
+			return tw.Flush()
+		}),
+	},
+	Type: APITokenList{},
+}
+
+var apiTokenRmCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove API tokens.",
+		ShortDescription: `
+Removes the given API tokens. Once the last token is removed, the API no
+longer requires authentication, so the last token can't be removed through
+a running daemon: remove it with the daemon stopped.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("name", true, true, "Names of the tokens to remove."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		tokens, err := apitoken.Load(n.Repo)
+		if err != nil {
+			return err
+		}
+
+		list := make([]APITokenOutput, 0, len(req.Arguments))
+		for _, name := range req.Arguments {
+			t, ok := tokens[name]
+			if !ok {
+				return fmt.Errorf("no API token named %s", name)
+			}
+			delete(tokens, name)
+			list = append(list, APITokenOutput{Name: name, Allow: t.Allow})
+		}
+		// through the API, removing every token would open it to anyone
+		if len(tokens) == 0 && n.IsDaemon {
+			return fmt.Errorf("refusing to remove the last API token through the daemon, which would disable authentication")
+		}
+		if err := apitoken.Save(n.Repo, tokens); err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &APITokenList{list})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, list *APITokenList) error {
+			for _, t := range list.Tokens {
+				fmt.Fprintf(w, "removed %s\n", t.Name)
+			}
+			return nil
+		}),
+	},
+	Type: APITokenList{},
+}
@@ -69,6 +69,11 @@ func TestROCommands(t *testing.T) {
 func TestCommands(t *testing.T) {
 	list := []string{
 		"/add",
+		"/api",
+		"/api/token",
+		"/api/token/create",
+		"/api/token/ls",
+		"/api/token/rm",
 		"/bitswap",
 		"/bitswap/ledger",
 		"/bitswap/reprovide",
@@ -94,9 +99,16 @@ func TestCommands(t *testing.T) {
 		"/config/profile",
 		"/config/profile/apply",
 		"/dag",
+		"/dag/export",
 		"/dag/get",
+		"/dag/import",
 		"/dag/put",
 		"/dag/resolve",
+		"/denylist",
+		"/denylist/add",
+		"/denylist/ls",
+		"/denylist/reload",
+		"/denylist/test",
 		"/dht",
 		"/dht/findpeer",
 		"/dht/findprovs",
@@ -134,6 +146,10 @@ func TestCommands(t *testing.T) {
 		"/key/list",
 		"/key/rename",
 		"/key/rm",
+		"/key/encrypt",
+		"/key/export",
+		"/key/import",
+		"/key/rotate",
 		"/log",
 		"/log/level",
 		"/log/ls",
@@ -172,7 +188,19 @@ func TestCommands(t *testing.T) {
 		"/pin/add",
 		"/ping",
 		"/pin/ls",
+		"/pin/queue",
+		"/pin/queue/ls",
+		"/pin/queue/rm",
+		"/pin/remote",
+		"/pin/remote/add",
+		"/pin/remote/ls",
+		"/pin/remote/rm",
+		"/pin/remote/service",
+		"/pin/remote/service/add",
+		"/pin/remote/service/ls",
+		"/pin/remote/service/rm",
 		"/pin/rm",
+		"/pin/status",
 		"/pin/update",
 		"/pin/verify",
 		"/pubsub",
@@ -185,6 +213,8 @@ func TestCommands(t *testing.T) {
 		"/repo",
 		"/repo/fsck",
 		"/repo/gc",
+		"/repo/gc/pause",
+		"/repo/gc/resume",
 		"/repo/stat",
 		"/repo/verify",
 		"/repo/version",
@@ -12,6 +12,7 @@ import (
 
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	"github.com/ipfs/go-ipfs/repo"
+	"github.com/ipfs/go-ipfs/repo/common"
 	"github.com/ipfs/go-ipfs/repo/fsrepo"
 
 	"github.com/elgris/jsondiff"
@@ -461,8 +462,12 @@ func editConfig(filename string) error {
 }
 
 func replaceConfig(r repo.Repo, file io.Reader) error {
-	var cfg config.Config
-	if err := json.NewDecoder(file).Decode(&cfg); err != nil {
+	var doc map[string]interface{}
+	if err := json.NewDecoder(file).Decode(&doc); err != nil {
+		return errors.New("failed to decode file as config")
+	}
+	cfg, err := config.FromMap(doc)
+	if err != nil {
 		return errors.New("failed to decode file as config")
 	}
 	if len(cfg.Identity.PrivKey) != 0 {
@@ -479,7 +484,16 @@ func replaceConfig(r repo.Repo, file io.Reader) error {
 		return errors.New("private key in config was not a string")
 	}
 
+	// the extended keys are only part of the document, so it is written
+	// as a whole when the repo supports it.
+	if cr, ok := r.(repo.ConfigReplacer); ok {
+		if err := common.MapSetKV(doc, config.PrivKeySelector, pkstr); err != nil {
+			return err
+		}
+		return cr.ReplaceConfig(doc)
+	}
+
 	cfg.Identity.PrivKey = pkstr
 
-	return r.SetConfig(&cfg)
+	return r.SetConfig(cfg)
 }
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/coreapi"
 	"github.com/ipfs/go-ipfs/core/coredag"
 
 	cid "github.com/ipfs/go-cid"
@@ -33,6 +34,8 @@ to deprecate and replace the existing 'ipfs object' command moving forward.
 		"put":     DagPutCmd,
 		"get":     DagGetCmd,
 		"resolve": DagResolveCmd,
+		"export":  DagExportCmd,
+		"import":  DagImportCmd,
 	},
 }
 
@@ -241,3 +244,107 @@ var DagResolveCmd = &cmds.Command{
 	},
 	Type: ResolveOutput{},
 }
+
+// DagExportCmd writes the DAG below a path to a CAR archive
+var DagExportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Streams the selected DAG as a .car stream on stdout.",
+		ShortDescription: `
+'ipfs dag export' fetches a dag rooted at the given path and writes it out
+as a CARv1 archive. The blocks are written exactly as stored, so importing
+the archive on another node with 'ipfs dag import' recreates the same CIDs.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("root", true, false, "The path of the root of the DAG to export.").EnableStdin(),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		archiver, ok := api.Dag().(coreapi.DagArchiveAPI)
+		if !ok {
+			return fmt.Errorf("dag export is not supported by this node")
+		}
+
+		rp, err := api.ResolvePath(req.Context, path.New(req.Arguments[0]))
+		if err != nil {
+			return err
+		}
+
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(archiver.Export(req.Context, rp.Cid(), pw))
+		}()
+
+		// As with 'ipfs cat', errors while fetching blocks are returned from
+		// the copy inside Emit and need to reach the client.
+		return res.Emit(pr)
+	},
+}
+
+// DagImportCmd loads the blocks of one or more CAR archives into the
+// blockstore
+var DagImportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Import the contents of .car files",
+		ShortDescription: `
+'ipfs dag import' imports all blocks present in the supplied CARv1 archives
+and, unless --pin-roots=false is given, recursively pins the roots listed in
+each archive header. The CID of every root is printed.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.FileArg("path", true, true, "The path of a .car file.").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption("pin-roots", "Pin the roots listed in the .car headers after importing.").WithDefault(true),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		archiver, ok := api.Dag().(coreapi.DagArchiveAPI)
+		if !ok {
+			return fmt.Errorf("dag import is not supported by this node")
+		}
+
+		dopin, _ := req.Options["pin-roots"].(bool)
+
+		it := req.Files.Entries()
+		for it.Next() {
+			file := files.FileFromEntry(it)
+			if file == nil {
+				return fmt.Errorf("expected a regular file")
+			}
+
+			roots, err := archiver.Import(req.Context, file, dopin)
+			file.Close()
+			if err != nil {
+				return fmt.Errorf("importing %s: %s", it.Name(), err)
+			}
+
+			for _, c := range roots {
+				if err := res.Emit(&OutputObject{Cid: c}); err != nil {
+					return err
+				}
+			}
+		}
+		return it.Err()
+	},
+	Type: OutputObject{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *OutputObject) error {
+			enc, err := cmdenv.GetLowLevelCidEncoder(req)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w, enc.Encode(out.Cid))
+			return nil
+		}),
+	},
+}
@@ -0,0 +1,227 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	denylist "github.com/ipfs/go-ipfs/denylist"
+	"github.com/ipfs/go-ipfs/namesys/resolve"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	path "github.com/ipfs/go-path"
+)
+
+var DenylistCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the content this node refuses to serve.",
+		ShortDescription: `
+Denied content isn't served by the gateway, which answers with 410 Gone, nor
+sent to other peers over bitswap, and it can't be pinned.
+
+Denylists are the files ending in '.deny' in the 'denylists' directory of the
+repo. Each line holds one entry:
+
+  /ipfs/<cid>         denies the CID and every path under it
+  /ipfs/<cid>/<path>  denies the path under the CID and every path below it
+  //<sha256>          a hashed entry, the hex encoded SHA-256 hash of the
+                      base32 CIDv1 of the CID, a slash and the path
+
+Empty lines and lines starting with '#' are ignored. A running daemon reads
+the files again on 'ipfs denylist reload'.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"ls":     denylistLsCmd,
+		"add":    denylistAddCmd,
+		"test":   denylistTestCmd,
+		"reload": denylistReloadCmd,
+	},
+}
+
+// DenylistEntry is an entry of a denylist.
+type DenylistEntry struct {
+	Entry  string
+	Source string `json:",omitempty"`
+}
+
+// DenylistEntries is the output type of the denylist commands listing entries.
+type DenylistEntries struct {
+	Entries []DenylistEntry
+}
+
+var denylistEntriesEncoder = cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *DenylistEntries) error {
+	for _, e := range out.Entries {
+		if _, err := fmt.Fprintln(w, e.Entry); err != nil {
+			return err
+		}
+	}
+	return nil
+})
+
+var denylistLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the entries of the denylists.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		entries := n.Denylist.Entries()
+		out := make([]DenylistEntry, 0, len(entries))
+		for _, e := range entries {
+			out = append(out, DenylistEntry{Entry: e.String(), Source: e.Source})
+		}
+		return cmds.EmitOnce(res, &DenylistEntries{out})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: denylistEntriesEncoder,
+	},
+	Type: DenylistEntries{},
+}
+
+const denylistHashOptionName = "hash"
+
+var denylistAddCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Deny content.",
+		ShortDescription: `
+Appends entries to the 'local.deny' denylist of the repo. Entries are written
+as given, or as hashed entries with --hash.
+
+  > ipfs denylist add /ipfs/QmSomeCid/some/path
+  > ipfs denylist add --hash /ipfs/QmOtherCid
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("entry", true, true, "Denylist entries to add."),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(denylistHashOptionName, "Add hashed entries instead of the paths."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		hash, _ := req.Options[denylistHashOptionName].(bool)
+
+		entries := make([]denylist.Entry, 0, len(req.Arguments))
+		for _, arg := range req.Arguments {
+			e, err := denylist.ParseEntry(arg)
+			if err != nil {
+				return err
+			}
+			if hash && e.Hash == "" {
+				e = denylist.HashEntry(e.Cid, e.Path)
+			}
+			entries = append(entries, e)
+		}
+
+		if err := n.Denylist.Add(entries...); err != nil {
+			return err
+		}
+
+		out := make([]DenylistEntry, 0, len(entries))
+		for _, e := range entries {
+			out = append(out, DenylistEntry{Entry: e.String(), Source: denylist.LocalFile})
+		}
+		return cmds.EmitOnce(res, &DenylistEntries{out})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: denylistEntriesEncoder,
+	},
+	Type: DenylistEntries{},
+}
+
+// DenylistTestOutput is the output type of 'ipfs denylist test'.
+type DenylistTestOutput struct {
+	Path   string
+	Denied bool
+	Entry  *DenylistEntry `json:",omitempty"`
+}
+
+var denylistTestCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Test whether paths are denied.",
+		ShortDescription: `
+Prints whether each path is denied, and the entry denying it. IPNS names are
+resolved, but the paths aren't, so that testing them doesn't fetch content.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("ipfs-path", true, true, "Paths to test."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		for _, arg := range req.Arguments {
+			p, err := path.ParsePath(arg)
+			if err != nil {
+				return err
+			}
+			rp, err := resolve.ResolveIPNS(req.Context, n.Namesys, p)
+			if err != nil {
+				return err
+			}
+			e, denied, err := n.Denylist.MatchPath(rp)
+			if err != nil {
+				return err
+			}
+
+			out := &DenylistTestOutput{Path: arg, Denied: denied}
+			if denied {
+				out.Entry = &DenylistEntry{Entry: e.String(), Source: e.Source}
+			}
+			if err := res.Emit(out); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *DenylistTestOutput) error {
+			if !out.Denied {
+				_, err := fmt.Fprintf(w, "%s allowed\n", out.Path)
+				return err
+			}
+			entry := out.Entry.Entry
+			if out.Entry.Source != "" {
+				entry += " (" + out.Entry.Source + ")"
+			}
+			_, err := fmt.Fprintf(w, "%s denied by %s\n", out.Path, entry)
+			return err
+		}),
+	},
+	Type: DenylistTestOutput{},
+}
+
+var denylistReloadCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Read the denylists of the repo again.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if err := n.Denylist.Reload(); err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &MessageOutput{fmt.Sprintf("loaded %d denylist entries\n", len(n.Denylist.Entries()))})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *MessageOutput) error {
+			_, err := io.WriteString(w, out.Message)
+			return err
+		}),
+	},
+	Type: MessageOutput{},
+}
@@ -1,11 +1,19 @@
 package commands
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"text/tabwriter"
 
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+	keystore "github.com/ipfs/go-ipfs/keystore"
+	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	options "github.com/ipfs/interface-go-ipfs-core/options"
@@ -29,10 +37,14 @@ publish'.
 		`,
 	},
 	Subcommands: map[string]*cmds.Command{
-		"gen":    keyGenCmd,
-		"list":   keyListCmd,
-		"rename": keyRenameCmd,
-		"rm":     keyRmCmd,
+		"gen":     keyGenCmd,
+		"list":    keyListCmd,
+		"rename":  keyRenameCmd,
+		"rm":      keyRmCmd,
+		"encrypt": keyEncryptCmd,
+		"export":  keyExportCmd,
+		"import":  keyImportCmd,
+		"rotate":  keyRotateCmd,
 	},
 }
 
@@ -230,6 +242,315 @@ var keyRmCmd = &cmds.Command{
 	Type: KeyOutputList{},
 }
 
+const (
+	keyFormatOptionName = "format"
+	keyOutputOptionName = "output"
+	keyForceOptionName  = "force"
+)
+
+var keyExportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Export a keypair",
+		ShortDescription: `
+Exports a named libp2p key to disk.
+
+By default, the key is written to '<name>.key' in the libp2p protobuf
+format. Use --format=pem-pkcs8-cleartext to write an unencrypted PKCS#8
+PEM file instead, and --output to choose the file, or '-' for stdout.
+
+The exported key is not encrypted. Keep it safe.
+
+To export the key of the node identity, use 'self' as name. The daemon must
+not be running.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("name", true, false, "name of key to export"),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(keyOutputOptionName, "o", "The path where the output should be stored."),
+		cmds.StringOption(keyFormatOptionName, "f", "The format of the exported key, libp2p-protobuf-cleartext or pem-pkcs8-cleartext.").WithDefault(keystore.FormatProtobuf),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		// never hand out private keys to API clients
+		if n.IsDaemon {
+			return errors.New("keys can not be exported from a running daemon, stop it and run 'ipfs key export' locally")
+		}
+
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		kapi, ok := api.Key().(coreapi.KeyTransferAPI)
+		if !ok {
+			return errors.New("key export is not supported by this node")
+		}
+
+		format, _ := req.Options[keyFormatOptionName].(string)
+
+		sk, err := kapi.Export(req.Context, req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		b, err := keystore.MarshalPrivateKey(sk, format)
+		if err != nil {
+			return err
+		}
+
+		return res.Emit(bytes.NewReader(b))
+	},
+	PostRun: cmds.PostRunMap{
+		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
+			req := res.Request()
+
+			v, err := res.Next()
+			if err != nil {
+				return err
+			}
+
+			outReader, ok := v.(io.Reader)
+			if !ok {
+				return e.New(e.TypeErr(outReader, v))
+			}
+
+			outPath, _ := req.Options[keyOutputOptionName].(string)
+			if outPath == "" {
+				ext := ".key"
+				if format, _ := req.Options[keyFormatOptionName].(string); format == keystore.FormatPEM {
+					ext = ".pem"
+				}
+				outPath = req.Arguments[0] + ext
+			}
+
+			if outPath == "-" {
+				_, err = io.Copy(os.Stdout, outReader)
+				return err
+			}
+
+			// never overwrite an existing file with a private key
+			f, err := os.OpenFile(outPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			_, err = io.Copy(f, outReader)
+			return err
+		},
+	},
+}
+
+var keyImportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Import a key and print its ID",
+		ShortDescription: `
+Imports a key and stores it under the given name in the keystore.
+
+The key may be in the libp2p protobuf format or an unencrypted PKCS#8 PEM
+file, as written by 'ipfs key export'. An existing key with the same name is
+only replaced with --force.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("name", true, false, "name to associate with key in keychain"),
+		cmds.FileArg("key", true, false, "key provided by generate or export"),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(keyForceOptionName, "f", "Allow to overwrite an existing key."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		kapi, ok := api.Key().(coreapi.KeyTransferAPI)
+		if !ok {
+			return errors.New("key import is not supported by this node")
+		}
+
+		name := req.Arguments[0]
+		if name == "self" {
+			return fmt.Errorf("cannot import key with name 'self'")
+		}
+		if err := keystore.ValidateName(name); err != nil {
+			return err
+		}
+
+		force, _ := req.Options[keyForceOptionName].(bool)
+
+		file, err := cmdenv.GetFileArg(req.Files.Entries())
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			return err
+		}
+
+		sk, err := keystore.UnmarshalPrivateKey(data)
+		if err != nil {
+			return fmt.Errorf("cannot decode key: %s", err)
+		}
+
+		key, err := kapi.Import(req.Context, name, sk, force)
+		if err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &KeyOutput{
+			Name: name,
+			Id:   key.ID().Pretty(),
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, ko *KeyOutput) error {
+			_, err := w.Write([]byte(ko.Id + "\n"))
+			return err
+		}),
+	},
+	Type: KeyOutput{},
+}
+
+// KeyRotateOutput define the output type of keyRotateCmd
+type KeyRotateOutput struct {
+	Name string
+	Id   string
+	Old  KeyOutput
+}
+
+const keyAllowOfflineOptionName = "allow-offline"
+
+var keyRotateCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Replace a keypair, redirecting its IPNS name to the new one",
+		ShortDescription: `
+'ipfs key rotate' generates a new key and stores it under the name of an
+existing one. The new key publishes the value last published by the old key,
+and the old key publishes a final IPNS record, signed by both keys, pointing
+to the name of the new key. Resolving the old name then resolves the new one.
+
+The old key is kept in the keystore under '<name>.rotated-<id>' so that its
+final record keeps being republished. The final record stays valid for a year
+after the rotation, whether or not it is republished. Once the old key has
+published its final record, it can't publish anything else.
+
+  > ipfs key rotate --type=ed25519 mykey
+  > ipfs name publish --key=mykey QmSomeHash
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("name", true, false, "name of the key to rotate"),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(keyStoreTypeOptionName, "t", "type of the key to create [rsa, ed25519]").WithDefault("rsa"),
+		cmds.IntOption(keyStoreSizeOptionName, "s", "size of the key to generate"),
+		cmds.BoolOption(keyAllowOfflineOptionName, "When offline, save the IPNS records to the local datastore without broadcasting them to the network instead of simply failing."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		kapi, ok := api.Key().(coreapi.KeyRotationAPI)
+		if !ok {
+			return errors.New("key rotation is not supported by this node")
+		}
+
+		name := req.Arguments[0]
+		if name == "self" {
+			return fmt.Errorf("cannot rotate the key of the node identity")
+		}
+
+		typ, _ := req.Options[keyStoreTypeOptionName].(string)
+		opts := []options.KeyGenerateOption{options.Key.Type(typ)}
+
+		size, sizefound := req.Options[keyStoreSizeOptionName].(int)
+		if sizefound {
+			opts = append(opts, options.Key.Size(size))
+		}
+
+		allowOffline, _ := req.Options[keyAllowOfflineOptionName].(bool)
+
+		key, old, err := kapi.Rotate(req.Context, name, allowOffline, opts...)
+		if err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &KeyRotateOutput{
+			Name: name,
+			Id:   key.ID().Pretty(),
+			Old: KeyOutput{
+				Name: old.Name(),
+				Id:   old.ID().Pretty(),
+			},
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, kro *KeyRotateOutput) error {
+			fmt.Fprintf(w, "Key %s rotated to %s\n", kro.Name, kro.Id)
+			fmt.Fprintf(w, "Previous key %s kept as %s\n", kro.Old.Id, kro.Old.Name)
+			return nil
+		}),
+	},
+	Type: KeyRotateOutput{},
+}
+
+const keyPassphraseFileOptionName = "passphrase-file"
+
+var keyEncryptCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Encrypt the keystore with a passphrase.",
+		ShortDescription: `
+'ipfs key encrypt' encrypts every key in the keystore with a key derived
+from a passphrase. The private key of the node identity is moved from the
+config file into the encrypted keystore.
+
+The passphrase is read from the file given with --passphrase-file, or else
+from the IPFS_KEYSTORE_PASSPHRASE or IPFS_KEYSTORE_PASSPHRASE_FILE
+environment variables. Once the keystore is encrypted, one of these
+environment variables must be set for every command using the repo,
+including 'ipfs daemon'.
+
+The daemon must not be running. If encrypting is interrupted, run the
+command again with the same passphrase.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(keyPassphraseFileOptionName, "File containing the passphrase."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		cfgRoot, err := cmdenv.GetConfigRoot(env)
+		if err != nil {
+			return err
+		}
+
+		var passphrase []byte
+		if fn, ok := req.Options[keyPassphraseFileOptionName].(string); ok {
+			passphrase, err = fsrepo.ReadPassphraseFile(fn)
+		} else {
+			passphrase, err = fsrepo.KeystorePassphrase()
+		}
+		if err != nil {
+			return err
+		}
+		if passphrase == nil {
+			return errors.New("no passphrase given")
+		}
+
+		return fsrepo.EncryptKeystore(cfgRoot, passphrase)
+	},
+}
+
 func keyOutputListEncoders() cmds.EncoderFunc {
 	return cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, list *KeyOutputList) error {
 		withID, _ := req.Options["l"].(bool)
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -10,6 +11,7 @@ import (
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
+	dag "github.com/ipfs/go-merkledag"
 	unixfs "github.com/ipfs/go-unixfs"
 	unixfs_pb "github.com/ipfs/go-unixfs/pb"
 	iface "github.com/ipfs/interface-go-ipfs-core"
@@ -55,6 +57,11 @@ the following format:
   <link base58 hash> <link size in bytes> <link name>
 
 The JSON output contains type information.
+
+Sharded directories, whose entries may not fit in memory, are listed as they
+are traversed unless --stream is explicitly set to false. Their entries are
+then neither sorted nor aligned, and API clients get one output per entry as
+with --stream.
 `,
 	},
 
@@ -75,7 +82,7 @@ The JSON output contains type information.
 
 		resolveType, _ := req.Options[lsResolveTypeOptionName].(bool)
 		resolveSize, _ := req.Options[lsSizeOptionName].(bool)
-		stream, _ := req.Options[lsStreamOptionName].(bool)
+		stream, streamSet := req.Options[lsStreamOptionName].(bool)
 
 		err = req.ParseBodyArgs()
 		if err != nil {
@@ -83,6 +90,22 @@ The JSON output contains type information.
 		}
 		paths := req.Arguments
 
+		if !streamSet {
+			for _, fpath := range paths {
+				sharded, err := isShardedDir(req.Context, api, path.New(fpath))
+				if err != nil {
+					return err
+				}
+				if sharded {
+					stream = true
+					// let the encoders of this process know the output is
+					// streamed, remote clients tell from the outputs
+					req.Options[lsStreamOptionName] = true
+					break
+				}
+			}
+		}
+
 		enc, err := cmdenv.GetCidEncoder(req)
 		if err != nil {
 			return err
@@ -171,18 +194,31 @@ The JSON output contains type information.
 		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
 			req := res.Request()
 			lastObjectHash := ""
+			stream, _ := req.Options[lsStreamOptionName].(bool)
 
+			// The daemon streams sharded directories without --stream being
+			// set here, which shows as more than one output: hold each
+			// output until the next one tells how to print it.
+			var prev *LsOutput
 			for {
 				v, err := res.Next()
 				if err != nil {
 					if err == io.EOF {
-						return nil
+						break
 					}
 					return err
 				}
 				out := v.(*LsOutput)
-				lastObjectHash = tabularOutput(req, os.Stdout, out, lastObjectHash, false)
+				if prev != nil {
+					stream = true
+					lastObjectHash = tabularOutput(req, os.Stdout, prev, lastObjectHash, stream, false)
+				}
+				prev = out
+			}
+			if prev != nil {
+				tabularOutput(req, os.Stdout, prev, lastObjectHash, stream, false)
 			}
+			return nil
 		},
 	},
 	Encoders: cmds.EncoderMap{
@@ -190,17 +226,33 @@ The JSON output contains type information.
 			// when streaming over HTTP using a text encoder, we cannot render breaks
 			// between directories because we don't know the hash of the last
 			// directory encoder
-			ignoreBreaks, _ := req.Options[lsStreamOptionName].(bool)
-			tabularOutput(req, w, out, "", ignoreBreaks)
+			stream, _ := req.Options[lsStreamOptionName].(bool)
+			tabularOutput(req, w, out, "", stream, stream)
 			return nil
 		}),
 	},
 	Type: LsOutput{},
 }
 
-func tabularOutput(req *cmds.Request, w io.Writer, out *LsOutput, lastObjectHash string, ignoreBreaks bool) string {
+// isShardedDir returns whether p points to a sharded (HAMT) directory.
+func isShardedDir(ctx context.Context, api iface.CoreAPI, p path.Path) (bool, error) {
+	nd, err := api.ResolveNode(ctx, p)
+	if err != nil {
+		return false, err
+	}
+	pn, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		return false, nil
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return false, nil
+	}
+	return fsn.Type() == unixfs.THAMTShard, nil
+}
+
+func tabularOutput(req *cmds.Request, w io.Writer, out *LsOutput, lastObjectHash string, stream, ignoreBreaks bool) string {
 	headers, _ := req.Options[lsHeadersOptionNameTime].(bool)
-	stream, _ := req.Options[lsStreamOptionName].(bool)
 	size, _ := req.Options[lsSizeOptionName].(bool)
 	// in streaming mode we can't automatically align the tabs
 	// so we take a best guess
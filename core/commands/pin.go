@@ -4,19 +4,30 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
+	car "github.com/ipfs/go-ipfs/car"
 	core "github.com/ipfs/go-ipfs/core"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
+	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
 	pin "github.com/ipfs/go-ipfs/pin"
+	pinqueue "github.com/ipfs/go-ipfs/pin/queue"
 
+	blocks "github.com/ipfs/go-block-format"
 	bserv "github.com/ipfs/go-blockservice"
 	cid "github.com/ipfs/go-cid"
 	cidenc "github.com/ipfs/go-cidutil/cidenc"
+	levelds "github.com/ipfs/go-ds-leveldb"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	files "github.com/ipfs/go-ipfs-files"
 	dag "github.com/ipfs/go-merkledag"
 	verifcid "github.com/ipfs/go-verifcid"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
@@ -35,6 +46,9 @@ var PinCmd = &cmds.Command{
 		"ls":     listPinCmd,
 		"verify": verifyPinCmd,
 		"update": updatePinCmd,
+		"status": pinStatusCmd,
+		"queue":  pinQueueCmd,
+		"remote": pinRemoteCmd,
 	},
 }
 
@@ -44,18 +58,53 @@ type PinOutput struct {
 
 type AddPinOutput struct {
 	Pins     []string
-	Progress int `json:",omitempty"`
+	Progress int         `json:",omitempty"`
+	Queued   []QueuedPin `json:",omitempty"`
+}
+
+// QueuedPin is a pin added with --background
+type QueuedPin struct {
+	ID   string
+	Path string
 }
 
 const (
-	pinRecursiveOptionName = "recursive"
-	pinProgressOptionName  = "progress"
+	pinRecursiveOptionName  = "recursive"
+	pinProgressOptionName   = "progress"
+	pinNameOptionName       = "name"
+	pinMetaOptionName       = "meta"
+	pinExpireInOptionName   = "expire-in"
+	pinExpireAtOptionName   = "expire-at"
+	pinBackgroundOptionName = "background"
 )
 
 var addPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline:          "Pin objects to local storage.",
 		ShortDescription: "Stores an IPFS object(s) from a given path locally to disk.",
+		LongDescription: `
+Stores an IPFS object(s) from a given path locally to disk.
+
+Use --name to hold the pin under a name. An object pinned under several names
+stays pinned until all of them have been removed with 'ipfs pin rm --name'.
+Named pins can carry metadata given as comma-separated key=value pairs with
+--meta.
+
+Pins expire with --expire-in, given a duration like "72h", or --expire-at,
+given a time like "2019-08-01T15:04:05Z". The daemon removes expired pins
+every minute, and before its periodic garbage collections. Pinning an object
+again sets the expiry of its pin anew, making it permanent unless given.
+
+With --background, the pins are queued and the command returns at once with
+the id of each request. The daemon fetches the queued pins a few at a time,
+resuming them after a restart. Follow them with 'ipfs pin status <id>' and
+'ipfs pin queue ls'.
+
+Example:
+	$ ipfs pin add --name=backup --meta=owner=alice,app=photos <ipfs-path>
+	$ ipfs pin add --expire-in=72h <ipfs-path>
+	$ ipfs pin add --background <ipfs-path>
+`,
 	},
 
 	Arguments: []cmds.Argument{
@@ -64,6 +113,11 @@ var addPinCmd = &cmds.Command{
 	Options: []cmds.Option{
 		cmds.BoolOption(pinRecursiveOptionName, "r", "Recursively pin the object linked to by the specified object(s).").WithDefault(true),
 		cmds.BoolOption(pinProgressOptionName, "Show progress"),
+		cmds.StringOption(pinNameOptionName, "n", "Name to hold the pin under."),
+		cmds.StringOption(pinMetaOptionName, "Metadata for a named pin, as comma-separated key=value pairs."),
+		cmds.StringOption(pinExpireInOptionName, "Remove the pin after this duration, like \"72h\"."),
+		cmds.StringOption(pinExpireAtOptionName, "Remove the pin at this time, in RFC 3339 format."),
+		cmds.BoolOption(pinBackgroundOptionName, "Queue the pins and return without waiting for them."),
 	},
 	Type: AddPinOutput{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
@@ -75,18 +129,48 @@ var addPinCmd = &cmds.Command{
 		// set recursive flag
 		recursive, _ := req.Options[pinRecursiveOptionName].(bool)
 		showProgress, _ := req.Options[pinProgressOptionName].(bool)
+		name, _ := req.Options[pinNameOptionName].(string)
+		metaStr, _ := req.Options[pinMetaOptionName].(string)
+
+		meta, err := parsePinMeta(metaStr)
+		if err != nil {
+			return err
+		}
+		if meta != nil && name == "" {
+			return fmt.Errorf("--%s requires --%s", pinMetaOptionName, pinNameOptionName)
+		}
+
+		expires, err := parsePinExpiry(req)
+		if err != nil {
+			return err
+		}
 
 		if err := req.ParseBodyArgs(); err != nil {
 			return err
 		}
 
+		if background, _ := req.Options[pinBackgroundOptionName].(bool); background {
+			if showProgress {
+				return fmt.Errorf("--%s and --%s are mutually exclusive", pinBackgroundOptionName, pinProgressOptionName)
+			}
+			n, err := cmdenv.GetNode(env)
+			if err != nil {
+				return err
+			}
+			queued, err := pinQueueMany(n, req.Arguments, recursive, name, meta, expires)
+			if err != nil {
+				return err
+			}
+			return cmds.EmitOnce(res, &AddPinOutput{Queued: queued})
+		}
+
 		enc, err := cmdenv.GetCidEncoder(req)
 		if err != nil {
 			return err
 		}
 
 		if !showProgress {
-			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive, name, meta, expires)
 			if err != nil {
 				return err
 			}
@@ -104,7 +188,7 @@ var addPinCmd = &cmds.Command{
 
 		ch := make(chan pinResult, 1)
 		go func() {
-			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive, name, meta, expires)
 			ch <- pinResult{pins: added, err: err}
 		}()
 
@@ -147,6 +231,9 @@ var addPinCmd = &cmds.Command{
 			for _, k := range out.Pins {
 				fmt.Fprintf(w, "pinned %s %s\n", k, pintype)
 			}
+			for _, q := range out.Queued {
+				fmt.Fprintf(w, "queued %s as %s\n", q.Path, q.ID)
+			}
 
 			return nil
 		}),
@@ -166,7 +253,7 @@ var addPinCmd = &cmds.Command{
 				if !ok {
 					return e.TypeErr(out, v)
 				}
-				if out.Pins == nil {
+				if out.Pins == nil && out.Queued == nil {
 					// this can only happen if the progress option is set
 					fmt.Fprintf(os.Stderr, "Fetched/Processed %d nodes\r", out.Progress)
 				} else {
@@ -180,7 +267,15 @@ var addPinCmd = &cmds.Command{
 	},
 }
 
-func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool) ([]string, error) {
+func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool, name string, meta map[string]string, expires time.Time) ([]string, error) {
+	napi, ok := api.Pin().(coreapi.NamedPinAPI)
+	if !ok && name != "" {
+		return nil, fmt.Errorf("named pins are not supported by this node")
+	}
+	if !ok && !expires.IsZero() {
+		return nil, fmt.Errorf("expiring pins are not supported by this node")
+	}
+
 	added := make([]string, len(paths))
 	for i, b := range paths {
 		rp, err := api.ResolvePath(ctx, path.New(b))
@@ -188,7 +283,12 @@ func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder,
 			return nil, err
 		}
 
-		if err := api.Pin().Add(ctx, rp, options.Pin.Recursive(recursive)); err != nil {
+		if name != "" || !expires.IsZero() {
+			err = napi.AddExpiring(ctx, rp, name, meta, expires, options.Pin.Recursive(recursive))
+		} else {
+			err = api.Pin().Add(ctx, rp, options.Pin.Recursive(recursive))
+		}
+		if err != nil {
 			return nil, err
 		}
 		added[i] = enc.Encode(rp.Cid())
@@ -197,12 +297,94 @@ func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder,
 	return added, nil
 }
 
+// pinQueueMany queues the pins of paths in the pin queue of the daemon.
+func pinQueueMany(n *core.IpfsNode, paths []string, recursive bool, name string, meta map[string]string, expires time.Time) ([]QueuedPin, error) {
+	if !n.PinQueue.Running() {
+		return nil, fmt.Errorf("--%s requires a running daemon", pinBackgroundOptionName)
+	}
+
+	var exp *time.Time
+	if !expires.IsZero() {
+		exp = &expires
+	}
+
+	queued := make([]QueuedPin, len(paths))
+	for i, b := range paths {
+		p := path.New(b)
+		if err := p.IsValid(); err != nil {
+			return nil, err
+		}
+
+		r, err := n.PinQueue.Add(pinqueue.Request{
+			Path:      p.String(),
+			Recursive: recursive,
+			Name:      name,
+			Meta:      meta,
+			Expires:   exp,
+		})
+		if err != nil {
+			return nil, err
+		}
+		queued[i] = QueuedPin{ID: r.ID, Path: r.Path}
+	}
+	return queued, nil
+}
+
+// parsePinMeta parses comma-separated key=value pairs. It returns nil for an
+// empty string.
+func parsePinMeta(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	meta := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid pin metadata %q, expected key=value", kv)
+		}
+		meta[parts[0]] = parts[1]
+	}
+	return meta, nil
+}
+
+// parsePinExpiry returns when the pins added by req expire, or the zero time
+// if they don't.
+func parsePinExpiry(req *cmds.Request) (time.Time, error) {
+	expireIn, inSet := req.Options[pinExpireInOptionName].(string)
+	expireAt, atSet := req.Options[pinExpireAtOptionName].(string)
+
+	switch {
+	case inSet && atSet:
+		return time.Time{}, fmt.Errorf("--%s and --%s are mutually exclusive", pinExpireInOptionName, pinExpireAtOptionName)
+	case inSet:
+		d, err := time.ParseDuration(expireIn)
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("invalid --%s %q, expected a positive duration like \"72h\"", pinExpireInOptionName, expireIn)
+		}
+		return time.Now().Add(d), nil
+	case atSet:
+		t, err := time.Parse(time.RFC3339, expireAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid --%s %q: %s", pinExpireAtOptionName, expireAt, err)
+		}
+		if !t.After(time.Now()) {
+			return time.Time{}, fmt.Errorf("--%s %s is in the past", pinExpireAtOptionName, expireAt)
+		}
+		return t, nil
+	}
+	return time.Time{}, nil
+}
+
 var rmPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Remove pinned objects from local storage.",
 		ShortDescription: `
 Removes the pin from the given object allowing it to be garbage
 collected if needed. (By default, recursively. Use -r=false for direct pins.)
+
+Pins held under a name are removed with --name. The object stays pinned while
+it is pinned under any other name.
 `,
 	},
 
@@ -211,6 +393,7 @@ collected if needed. (By default, recursively. Use -r=false for direct pins.)
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(pinRecursiveOptionName, "r", "Recursively unpin the object linked to by the specified object(s).").WithDefault(true),
+		cmds.StringOption(pinNameOptionName, "n", "Name of the pin to remove."),
 	},
 	Type: PinOutput{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
@@ -221,6 +404,12 @@ collected if needed. (By default, recursively. Use -r=false for direct pins.)
 
 		// set recursive flag
 		recursive, _ := req.Options[pinRecursiveOptionName].(bool)
+		name, _ := req.Options[pinNameOptionName].(string)
+
+		napi, ok := api.Pin().(coreapi.NamedPinAPI)
+		if !ok && name != "" {
+			return fmt.Errorf("named pins are not supported by this node")
+		}
 
 		if err := req.ParseBodyArgs(); err != nil {
 			return err
@@ -240,7 +429,12 @@ collected if needed. (By default, recursively. Use -r=false for direct pins.)
 
 			id := enc.Encode(rp.Cid())
 			pins = append(pins, id)
-			if err := api.Pin().Rm(req.Context, rp, options.Pin.RmRecursive(recursive)); err != nil {
+			if name != "" {
+				err = napi.RmNamed(req.Context, rp, name, options.Pin.RmRecursive(recursive))
+			} else {
+				err = api.Pin().Rm(req.Context, rp, options.Pin.RmRecursive(recursive))
+			}
+			if err != nil {
 				return err
 			}
 		}
@@ -291,6 +485,12 @@ With arguments, the command fails if any of the arguments is not a pinned
 object. And if --type=<type> is additionally used, the command will also fail
 if any of the arguments is not of the specified type.
 
+Use --name=<name> to only list the direct and recursive pins held under that
+name. The names and metadata of named pins are always included in the output,
+as is the expiry of expiring pins:
+
+	QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN recursive expires 2019-08-01T15:04:05Z
+
 Example:
 	$ echo "hello" | ipfs add -q
 	QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN
@@ -315,6 +515,7 @@ Example:
 		cmds.StringOption(pinTypeOptionName, "t", "The type of pinned keys to list. Can be \"direct\", \"indirect\", \"recursive\", or \"all\".").WithDefault("all"),
 		cmds.BoolOption(pinQuietOptionName, "q", "Write just hashes of objects."),
 		cmds.BoolOption(pinStreamOptionName, "s", "Enable streaming of pins as they are discovered."),
+		cmds.StringOption(pinNameOptionName, "n", "Only list pins held under this name."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := cmdenv.GetNode(env)
@@ -329,6 +530,7 @@ Example:
 
 		typeStr, _ := req.Options[pinTypeOptionName].(string)
 		stream, _ := req.Options[pinStreamOptionName].(bool)
+		name, nameSet := req.Options[pinNameOptionName].(string)
 
 		switch typeStr {
 		case "all", "direct", "indirect", "recursive":
@@ -336,6 +538,9 @@ Example:
 			err = fmt.Errorf("invalid type '%s', must be one of {direct, indirect, recursive, all}", typeStr)
 			return err
 		}
+		if nameSet && typeStr == "indirect" {
+			return fmt.Errorf("indirect pins have no name")
+		}
 
 		// For backward compatibility, we accumulate the pins in the same output type as before.
 		emit := res.Emit
@@ -343,14 +548,17 @@ Example:
 		if !stream {
 			emit = func(v interface{}) error {
 				obj := v.(*PinLsOutputWrapper)
-				lgcList[obj.PinLsObject.Cid] = PinLsType{Type: obj.PinLsObject.Type}
+				lgcList[obj.PinLsObject.Cid] = PinLsType{Type: obj.PinLsObject.Type, Expires: obj.PinLsObject.Expires, Names: obj.PinLsObject.Names}
 				return nil
 			}
 		}
 
-		if len(req.Arguments) > 0 {
+		switch {
+		case len(req.Arguments) > 0:
 			err = pinLsKeys(req, typeStr, n, api, emit)
-		} else {
+		case nameSet:
+			err = pinLsNamed(req, typeStr, name, n, emit)
+		default:
 			err = pinLsAll(req, typeStr, n, emit)
 		}
 		if err != nil {
@@ -375,7 +583,7 @@ Example:
 				if quiet {
 					fmt.Fprintf(w, "%s\n", out.PinLsObject.Cid)
 				} else {
-					fmt.Fprintf(w, "%s %s\n", out.PinLsObject.Cid, out.PinLsObject.Type)
+					fmt.Fprintf(w, "%s %s%s%s\n", out.PinLsObject.Cid, out.PinLsObject.Type, formatPinExpiry(out.PinLsObject.Expires), formatPinNames(out.PinLsObject.Names))
 				}
 				return nil
 			}
@@ -384,7 +592,7 @@ Example:
 				if quiet {
 					fmt.Fprintf(w, "%s\n", k)
 				} else {
-					fmt.Fprintf(w, "%s %s\n", k, v.Type)
+					fmt.Fprintf(w, "%s %s%s%s\n", k, v.Type, formatPinExpiry(v.Expires), formatPinNames(v.Names))
 				}
 			}
 
@@ -406,15 +614,78 @@ type PinLsList struct {
 	Keys map[string]PinLsType `json:",omitempty"`
 }
 
-// PinLsType contains the type of a pin
+// PinLsType contains the type of a pin. Expires is the expiry of the unnamed
+// pin, in RFC 3339 format.
 type PinLsType struct {
-	Type string
+	Type    string
+	Expires string      `json:",omitempty"`
+	Names   []PinLsName `json:",omitempty"`
 }
 
 // PinLsObject contains the description of a pin
 type PinLsObject struct {
-	Cid  string `json:",omitempty"`
-	Type string `json:",omitempty"`
+	Cid     string      `json:",omitempty"`
+	Type    string      `json:",omitempty"`
+	Expires string      `json:",omitempty"`
+	Names   []PinLsName `json:",omitempty"`
+}
+
+// PinLsName contains a name a pin is held under, its metadata and expiry
+type PinLsName struct {
+	Name    string
+	Meta    map[string]string `json:",omitempty"`
+	Expires string            `json:",omitempty"`
+}
+
+func formatPinNames(names []PinLsName) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	strs := make([]string, len(names))
+	for i, n := range names {
+		strs[i] = n.Name + formatPinExpiry(n.Expires)
+	}
+	return " (" + strings.Join(strs, ", ") + ")"
+}
+
+func formatPinExpiry(expires string) string {
+	if expires == "" {
+		return ""
+	}
+	return " expires " + expires
+}
+
+// pinExpiry formats the expiry of a pin for the pin ls output.
+func pinExpiry(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// pinNamesByCid collects the names of all named pins of the given type,
+// sorted by name, and the expiry of the unnamed ones.
+func pinNamesByCid(n *core.IpfsNode, typeStr string) (map[cid.Cid][]PinLsName, map[cid.Cid]string) {
+	out := make(map[cid.Cid][]PinLsName)
+	expiries := make(map[cid.Cid]string)
+	for _, p := range n.Pinning.NamedPins() {
+		mode, _ := pin.ModeToString(p.Mode)
+		if typeStr != "all" && typeStr != mode {
+			continue
+		}
+		if p.Name == "" {
+			if !p.Expires.IsZero() {
+				expiries[p.Key] = pinExpiry(p.Expires)
+			}
+			continue
+		}
+		out[p.Key] = append(out[p.Key], PinLsName{Name: p.Name, Meta: p.Meta, Expires: pinExpiry(p.Expires)})
+	}
+	for _, names := range out {
+		sort.Slice(names, func(i, j int) bool { return names[i].Name < names[j].Name })
+	}
+	return out, expiries
 }
 
 func pinLsKeys(req *cmds.Request, typeStr string, n *core.IpfsNode, api coreiface.CoreAPI, emit func(value interface{}) error) error {
@@ -428,12 +699,19 @@ func pinLsKeys(req *cmds.Request, typeStr string, n *core.IpfsNode, api coreifac
 		return err
 	}
 
+	names, expiries := pinNamesByCid(n, "all")
+	name, nameSet := req.Options[pinNameOptionName].(string)
+
 	for _, p := range req.Arguments {
 		c, err := api.ResolvePath(req.Context, path.New(p))
 		if err != nil {
 			return err
 		}
 
+		if nameSet && !hasPinName(names[c.Cid()], name) {
+			return fmt.Errorf("path '%s' is not pinned under the name %q", p, name)
+		}
+
 		pinType, pinned, err := n.Pinning.IsPinnedWithType(c.Cid(), mode)
 		if err != nil {
 			return err
@@ -451,8 +729,10 @@ func pinLsKeys(req *cmds.Request, typeStr string, n *core.IpfsNode, api coreifac
 
 		err = emit(&PinLsOutputWrapper{
 			PinLsObject: PinLsObject{
-				Type: pinType,
-				Cid:  enc.Encode(c.Cid()),
+				Type:    pinType,
+				Cid:     enc.Encode(c.Cid()),
+				Expires: expiries[c.Cid()],
+				Names:   names[c.Cid()],
 			},
 		})
 		if err != nil {
@@ -470,14 +750,17 @@ func pinLsAll(req *cmds.Request, typeStr string, n *core.IpfsNode, emit func(val
 	}
 
 	keys := cid.NewSet()
+	names, expiries := pinNamesByCid(n, typeStr)
 
 	AddToResultKeys := func(keyList []cid.Cid, typeStr string) error {
 		for _, c := range keyList {
 			if keys.Visit(c) {
 				err := emit(&PinLsOutputWrapper{
 					PinLsObject: PinLsObject{
-						Type: typeStr,
-						Cid:  enc.Encode(c),
+						Type:    typeStr,
+						Cid:     enc.Encode(c),
+						Expires: expiries[c],
+						Names:   names[c],
 					},
 				})
 				if err != nil {
@@ -535,6 +818,45 @@ func pinLsAll(req *cmds.Request, typeStr string, n *core.IpfsNode, emit func(val
 	return nil
 }
 
+func hasPinName(names []PinLsName, name string) bool {
+	for _, n := range names {
+		if n.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// pinLsNamed lists the direct and recursive pins held under name.
+func pinLsNamed(req *cmds.Request, typeStr string, name string, n *core.IpfsNode, emit func(value interface{}) error) error {
+	enc, err := cmdenv.GetCidEncoder(req)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range n.Pinning.NamedPins() {
+		mode, _ := pin.ModeToString(p.Mode)
+		if p.Name != name || (typeStr != "all" && typeStr != mode) {
+			continue
+		}
+
+		obj := PinLsObject{
+			Type: mode,
+			Cid:  enc.Encode(p.Key),
+		}
+		if name != "" {
+			obj.Names = []PinLsName{{Name: p.Name, Meta: p.Meta, Expires: pinExpiry(p.Expires)}}
+		} else {
+			obj.Expires = pinExpiry(p.Expires)
+		}
+		if err := emit(&PinLsOutputWrapper{PinLsObject: obj}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 const (
 	pinUnpinOptionName = "unpin"
 )
@@ -550,7 +872,8 @@ This commands is useful when the new pin contains many similarities or is a
 derivate of an existing one, particuarly for large objects. This allows a more
 efficient DAG-traversal which fully skips already-pinned branches from the old
 object. As a requirement, the old object needs to be an existing recursive
-pin.
+pin. When the old pin is removed, the new object is pinned under every name
+the old one was pinned under.
 `,
 	},
 
@@ -601,16 +924,34 @@ pin.
 }
 
 const (
-	pinVerboseOptionName = "verbose"
+	pinVerboseOptionName      = "verbose"
+	pinRepairOptionName       = "repair"
+	pinFetchTimeoutOptionName = "fetch-timeout"
 )
 
 var verifyPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Verify that recursive pins are complete.",
+		ShortDescription: `
+Checks that the blocks of every recursive pin are in the blockstore.
+
+With --repair, the missing blocks of the broken pins are fetched again from
+the network, or from the given CAR files when offline, and the data of their
+blocks is checked against their CIDs, corrupted blocks being replaced. The
+pins are verified again, and a summary of the repair is written last:
+
+  > ipfs pin verify --repair --enc=json
+  > ipfs pin verify --repair backup.car
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.FileArg("car", false, true, "CAR files to take the missing blocks from with --repair."),
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(pinVerboseOptionName, "Also write the hashes of non-broken pins."),
 		cmds.BoolOption(pinQuietOptionName, "q", "Write just hashes of broken pins."),
+		cmds.BoolOption(pinRepairOptionName, "Fetch the missing and corrupted blocks of broken pins."),
+		cmds.StringOption(pinFetchTimeoutOptionName, "Give up fetching the missing blocks of a DAG level from the network after this duration.").WithDefault("1m"),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := cmdenv.GetNode(env)
@@ -620,6 +961,7 @@ var verifyPinCmd = &cmds.Command{
 
 		verbose, _ := req.Options[pinVerboseOptionName].(bool)
 		quiet, _ := req.Options[pinQuietOptionName].(bool)
+		repair, _ := req.Options[pinRepairOptionName].(bool)
 
 		if verbose && quiet {
 			return fmt.Errorf("the --verbose and --quiet options can not be used at the same time")
@@ -634,6 +976,23 @@ var verifyPinCmd = &cmds.Command{
 			explain:   !quiet,
 			includeOk: verbose,
 		}
+
+		if !repair && req.Files != nil && req.Files.Entries().Next() {
+			return fmt.Errorf("CAR files can only be given with --%s", pinRepairOptionName)
+		}
+		if repair {
+			timeoutStr, _ := req.Options[pinFetchTimeoutOptionName].(string)
+			timeout, err := time.ParseDuration(timeoutStr)
+			if err != nil {
+				return fmt.Errorf("invalid --%s: %s", pinFetchTimeoutOptionName, err)
+			}
+			var cleanup func()
+			if opts.repair, cleanup, err = pinRepairFetcher(req, n, timeout); err != nil {
+				return err
+			}
+			defer cleanup()
+		}
+
 		out := pinVerify(req.Context, n, opts, enc)
 
 		return res.Emit(out)
@@ -643,7 +1002,11 @@ var verifyPinCmd = &cmds.Command{
 		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *PinVerifyRes) error {
 			quiet, _ := req.Options[pinQuietOptionName].(bool)
 
-			if quiet && !out.Ok {
+			if out.Summary != nil {
+				if !quiet {
+					out.Summary.Format(w)
+				}
+			} else if quiet && !out.Ok {
 				fmt.Fprintf(w, "%s\n", out.Cid)
 			} else if !quiet {
 				out.Format(w)
@@ -654,15 +1017,128 @@ var verifyPinCmd = &cmds.Command{
 	},
 }
 
-// PinVerifyRes is the result returned for each pin checked in "pin verify"
+// pinRepairFetcher returns the fetcher of the blocks missing from broken
+// pins: the blocks of the CAR files of the request if any, then the network
+// if the node is online. The returned function removes the blocks read from
+// the CAR files once the repair is over.
+func pinRepairFetcher(req *cmds.Request, n *core.IpfsNode, timeout time.Duration) (corerepo.BlockFetcher, func(), error) {
+	var carBlocks blockstore.Blockstore
+	cleanup := func() {}
+	if req.Files != nil {
+		it := req.Files.Entries()
+		for it.Next() {
+			file := files.FileFromEntry(it)
+			if file == nil {
+				cleanup()
+				return nil, nil, fmt.Errorf("expected a regular file")
+			}
+			if carBlocks == nil {
+				var err error
+				if carBlocks, cleanup, err = repairCarBlockstore(n); err != nil {
+					file.Close()
+					return nil, nil, err
+				}
+			}
+			_, err := car.LoadCar(carBlocks, file)
+			file.Close()
+			if err != nil {
+				cleanup()
+				return nil, nil, fmt.Errorf("reading %s: %s", it.Name(), err)
+			}
+		}
+		if it.Err() != nil {
+			cleanup()
+			return nil, nil, it.Err()
+		}
+	}
+
+	if carBlocks == nil && !n.IsOnline {
+		return nil, nil, fmt.Errorf("--%s needs a running daemon or CAR files to take the blocks from", pinRepairOptionName)
+	}
+
+	return func(ctx context.Context, cids []cid.Cid) (<-chan blocks.Block, error) {
+		out := make(chan blocks.Block)
+		go func() {
+			defer close(out)
+			var remaining []cid.Cid
+			for _, c := range cids {
+				if carBlocks != nil {
+					if blk, err := carBlocks.Get(c); err == nil {
+						select {
+						case out <- blk:
+						case <-ctx.Done():
+							return
+						}
+						continue
+					}
+				}
+				remaining = append(remaining, c)
+			}
+			if len(remaining) == 0 || !n.IsOnline {
+				return
+			}
+
+			// the blocks missing from the archives are requested at once
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			blks, err := n.Exchange.GetBlocks(ctx, remaining)
+			if err != nil {
+				log.Warningf("could not fetch blocks: %s", err)
+				return
+			}
+			for blk := range blks {
+				select {
+				case out <- blk:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return out, nil
+	}, cleanup, nil
+}
+
+// repairCarBlockstore returns the blockstore the CAR files given to repair
+// pins are loaded into, to be searched as the DAGs are walked. As the archives
+// may not fit in memory, it is a temporary datastore on disk, in the repo
+// directory if there is one. The returned function removes it.
+func repairCarBlockstore(n *core.IpfsNode) (blockstore.Blockstore, func(), error) {
+	parent := ""
+	if r, ok := n.Repo.(interface{ Path() string }); ok {
+		parent = r.Path()
+	}
+	dir, err := ioutil.TempDir(parent, "pin-repair-")
+	if err != nil {
+		return nil, nil, err
+	}
+	dstore, err := levelds.NewDatastore(dir, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	cleanup := func() {
+		if err := dstore.Close(); err != nil {
+			log.Errorf("closing the blocks of the CAR files: %s", err)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.Errorf("removing the blocks of the CAR files: %s", err)
+		}
+	}
+	return blockstore.NewBlockstore(dstore), cleanup, nil
+}
+
+// PinVerifyRes is the result returned for each pin checked in "pin verify".
+// With --repair, a last result only holds the summary of the repair.
 type PinVerifyRes struct {
 	Cid string
 	PinStatus
+	Summary *PinRepairSummary `json:",omitempty"`
 }
 
 // PinStatus is part of PinVerifyRes, do not use directly
 type PinStatus struct {
 	Ok       bool
+	Repaired bool      `json:",omitempty"`
 	BadNodes []BadNode `json:",omitempty"`
 }
 
@@ -672,13 +1148,27 @@ type BadNode struct {
 	Err string
 }
 
+// PinRepairSummary sums up the repair of broken pins by "pin verify --repair"
+type PinRepairSummary struct {
+	Pins            int // checked
+	Broken          int
+	Healed          []string
+	Unrecoverable   []string
+	FetchedBlocks   int
+	CorruptedBlocks int
+}
+
 type pinVerifyOpts struct {
 	explain   bool
 	includeOk bool
+	repair    corerepo.BlockFetcher // nil unless broken pins are repaired
 }
 
 func pinVerify(ctx context.Context, n *core.IpfsNode, opts pinVerifyOpts, enc cidenc.Encoder) <-chan interface{} {
 	visited := make(map[cid.Cid]PinStatus)
+	// the links of the broken blocks, to invalidate their statuses once
+	// repaired
+	brokenLinks := make(map[cid.Cid][]cid.Cid)
 
 	bs := n.Blocks.Blockstore()
 	DAG := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
@@ -719,24 +1209,87 @@ func pinVerify(ctx context.Context, n *core.IpfsNode, opts pinVerifyOpts, enc ci
 				status.BadNodes = append(status.BadNodes, res.BadNodes...)
 			}
 		}
+		if !status.Ok {
+			cids := make([]cid.Cid, len(links))
+			for i, lnk := range links {
+				cids[i] = lnk.Cid
+			}
+			brokenLinks[key] = cids
+		}
 
 		visited[key] = status
 		return status
 	}
 
+	// invalidate forgets the statuses of the broken blocks of the DAG under
+	// root, the complete blocks staying complete
+	var invalidate func(root cid.Cid)
+	invalidate = func(root cid.Cid) {
+		if status, ok := visited[root]; !ok || status.Ok {
+			return
+		}
+		delete(visited, root)
+		links := brokenLinks[root]
+		delete(brokenLinks, root)
+		for _, c := range links {
+			invalidate(c)
+		}
+	}
+
+	summary := &PinRepairSummary{Healed: []string{}, Unrecoverable: []string{}}
+	repairPin := func(root cid.Cid) PinStatus {
+		summary.Broken++
+		rep, err := corerepo.RepairPin(ctx, n, root, opts.repair)
+		summary.FetchedBlocks += rep.Fetched
+		summary.CorruptedBlocks += rep.Corrupted
+
+		if rep.Corrupted > 0 {
+			// a corrupted block may have been read with the wrong links
+			visited = make(map[cid.Cid]PinStatus)
+			brokenLinks = make(map[cid.Cid][]cid.Cid)
+		} else {
+			invalidate(root)
+		}
+		status := checkPin(root)
+		if err != nil {
+			status.Ok = false
+			if opts.explain {
+				status.BadNodes = append(status.BadNodes, BadNode{Cid: enc.Encode(root), Err: err.Error()})
+			}
+		}
+		if status.Ok {
+			status.Repaired = true
+			summary.Healed = append(summary.Healed, enc.Encode(root))
+		} else {
+			summary.Unrecoverable = append(summary.Unrecoverable, enc.Encode(root))
+		}
+		return status
+	}
+
 	out := make(chan interface{})
 	go func() {
 		defer close(out)
 		for _, cid := range recPins {
 			pinStatus := checkPin(cid)
-			if !pinStatus.Ok || opts.includeOk {
+			if !pinStatus.Ok && opts.repair != nil && ctx.Err() == nil {
+				pinStatus = repairPin(cid)
+			}
+			if !pinStatus.Ok || pinStatus.Repaired || opts.includeOk {
 				select {
-				case out <- &PinVerifyRes{enc.Encode(cid), pinStatus}:
+				case out <- &PinVerifyRes{Cid: enc.Encode(cid), PinStatus: pinStatus}:
 				case <-ctx.Done():
 					return
 				}
 			}
 		}
+
+		if opts.repair != nil {
+			summary.Pins = len(recPins)
+			select {
+			case out <- &PinVerifyRes{Summary: summary}:
+			case <-ctx.Done():
+			}
+		}
 	}()
 
 	return out
@@ -745,7 +1298,11 @@ func pinVerify(ctx context.Context, n *core.IpfsNode, opts pinVerifyOpts, enc ci
 // Format formats PinVerifyRes
 func (r PinVerifyRes) Format(out io.Writer) {
 	if r.Ok {
-		fmt.Fprintf(out, "%s ok\n", r.Cid)
+		if r.Repaired {
+			fmt.Fprintf(out, "%s repaired\n", r.Cid)
+		} else {
+			fmt.Fprintf(out, "%s ok\n", r.Cid)
+		}
 	} else {
 		fmt.Fprintf(out, "%s broken\n", r.Cid)
 		for _, e := range r.BadNodes {
@@ -753,3 +1310,9 @@ func (r PinVerifyRes) Format(out io.Writer) {
 		}
 	}
 }
+
+// Format formats PinRepairSummary
+func (s PinRepairSummary) Format(out io.Writer) {
+	fmt.Fprintf(out, "%d pins, %d broken, %d repaired, %d unrecoverable (%d blocks fetched, %d corrupted)\n",
+		s.Pins, s.Broken, len(s.Healed), len(s.Unrecoverable), s.FetchedBlocks, s.CorruptedBlocks)
+}
@@ -0,0 +1,160 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	pinqueue "github.com/ipfs/go-ipfs/pin/queue"
+
+	humanize "github.com/dustin/go-humanize"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+)
+
+var pinStatusCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the status of background pins.",
+		ShortDescription: `
+Shows the status of the pins added with 'ipfs pin add --background': queued,
+pinning, pinned or failed, with the number of bytes fetched so far.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("id", true, true, "Id of the pin request."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range req.Arguments {
+			r, err := n.PinQueue.Get(id)
+			if err != nil {
+				return fmt.Errorf("%s: %s", id, err)
+			}
+			if err := res.Emit(&r); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: pinqueue.Request{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, r *pinqueue.Request) error {
+			return writePinRequest(w, r)
+		}),
+	},
+}
+
+var pinQueueCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the queue of background pins.",
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"ls": pinQueueLsCmd,
+		"rm": pinQueueRmCmd,
+	},
+}
+
+const pinQueueStatusOptionName = "status"
+
+var pinQueueLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the background pins.",
+		ShortDescription: `
+Lists the pins added with 'ipfs pin add --background', oldest first, with
+their status: queued, pinning, pinned or failed. Finished requests are kept
+for a week, or until removed with 'ipfs pin queue rm'.
+`,
+	},
+
+	Options: []cmds.Option{
+		cmds.StringOption(pinQueueStatusOptionName, "s", "Only list the requests with this status."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		status, statusSet := req.Options[pinQueueStatusOptionName].(string)
+		switch pinqueue.Status(status) {
+		case pinqueue.Queued, pinqueue.Pinning, pinqueue.Pinned, pinqueue.Failed:
+		default:
+			if statusSet {
+				return fmt.Errorf("invalid status '%s', must be one of {queued, pinning, pinned, failed}", status)
+			}
+		}
+
+		for _, r := range n.PinQueue.List() {
+			if statusSet && r.Status != pinqueue.Status(status) {
+				continue
+			}
+			r := r
+			if err := res.Emit(&r); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: pinqueue.Request{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, r *pinqueue.Request) error {
+			return writePinRequest(w, r)
+		}),
+	},
+}
+
+// PinQueueRmOutput is the output of the pin queue rm command
+type PinQueueRmOutput struct {
+	ID string
+}
+
+var pinQueueRmCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove background pin requests.",
+		ShortDescription: `
+Removes requests from the queue of background pins, cancelling them if they
+are being pinned. The content of the requests which were pinned stays pinned,
+use 'ipfs pin rm' to remove it.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("id", true, true, "Id of the pin request."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range req.Arguments {
+			if err := n.PinQueue.Remove(id); err != nil {
+				return fmt.Errorf("%s: %s", id, err)
+			}
+			if err := res.Emit(&PinQueueRmOutput{ID: id}); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: PinQueueRmOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *PinQueueRmOutput) error {
+			_, err := fmt.Fprintf(w, "removed %s\n", out.ID)
+			return err
+		}),
+	},
+}
+
+func writePinRequest(w io.Writer, r *pinqueue.Request) error {
+	_, err := fmt.Fprintf(w, "%s %s %s %s\n", r.ID, r.Status, r.Path, humanize.Bytes(r.Bytes))
+	if err == nil && r.Error != "" {
+		_, err = fmt.Fprintf(w, "  error: %s\n", r.Error)
+	}
+	return err
+}
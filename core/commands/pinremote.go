@@ -0,0 +1,469 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	core "github.com/ipfs/go-ipfs/core"
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	remote "github.com/ipfs/go-ipfs/pin/remote"
+
+	cid "github.com/ipfs/go-cid"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/interface-go-ipfs-core/path"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var pinRemoteCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Pin objects to remote pinning services.",
+		ShortDescription: `
+Asks remote pinning services implementing the Pinning Services API to pin
+objects. Services are configured with 'ipfs pin remote service add', and
+stored under Pinning.RemoteServices in the config.
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"add":     pinRemoteAddCmd,
+		"ls":      pinRemoteLsCmd,
+		"rm":      pinRemoteRmCmd,
+		"service": pinRemoteServiceCmd,
+	},
+}
+
+// RemotePinOutput is a pin request of a remote pinning service.
+type RemotePinOutput struct {
+	RequestID string
+	Status    string
+	Cid       string
+	Name      string
+	Info      map[string]string `json:",omitempty"`
+}
+
+func toRemotePinOutput(st *remote.PinStatus) *RemotePinOutput {
+	return &RemotePinOutput{
+		RequestID: st.RequestID,
+		Status:    string(st.Status),
+		Cid:       st.Pin.Cid,
+		Name:      st.Pin.Name,
+		Info:      st.Info,
+	}
+}
+
+const (
+	pinRemoteServiceOptionName    = "service"
+	pinRemoteNameOptionName       = "name"
+	pinRemoteCidOptionName        = "cid"
+	pinRemoteStatusOptionName     = "status"
+	pinRemoteBackgroundOptionName = "background"
+	pinRemoteForceOptionName      = "force"
+)
+
+// remotePinPollInterval is the time between checks of the status of a pin
+// request while waiting for it.
+const remotePinPollInterval = time.Second
+
+var pinRemoteAddCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Pin an object to a remote pinning service.",
+		ShortDescription: `
+Asks a remote pinning service to pin an object, sending it the addresses of
+this node so that it can fetch the object from it. The command waits until
+the object is pinned or the service fails to pin it, unless --background is
+given.
+
+  > ipfs pin remote add --service=mysvc --name=backup /ipfs/<cid>
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("ipfs-path", true, false, "Path to the object to be pinned."),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(pinRemoteServiceOptionName, "Name of the remote pinning service."),
+		cmds.StringOption(pinRemoteNameOptionName, "Name of the pin request."),
+		cmds.BoolOption(pinRemoteBackgroundOptionName, "Return once the request is queued by the service."),
+	},
+	Type: RemotePinOutput{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		client, err := remotePinClient(req, n)
+		if err != nil {
+			return err
+		}
+
+		rp, err := api.ResolvePath(req.Context, path.New(req.Arguments[0]))
+		if err != nil {
+			return err
+		}
+
+		p := remote.Pin{Cid: rp.Cid().String()}
+		p.Name, _ = req.Options[pinRemoteNameOptionName].(string)
+		if n.IsOnline {
+			for _, addr := range n.PeerHost.Addrs() {
+				p.Origins = append(p.Origins, addr.String()+"/ipfs/"+n.Identity.Pretty())
+			}
+		}
+
+		st, err := client.Add(req.Context, p)
+		if err != nil {
+			return err
+		}
+		if n.IsOnline {
+			connectDelegates(req.Context, n, st.Delegates)
+		}
+
+		if background, _ := req.Options[pinRemoteBackgroundOptionName].(bool); !background {
+			for st.Status != remote.Pinned {
+				if st.Status == remote.Failed {
+					return fmt.Errorf("remote pinning service failed to pin %s: %v", p.Cid, st.Info)
+				}
+				select {
+				case <-time.After(remotePinPollInterval):
+				case <-req.Context.Done():
+					return req.Context.Err()
+				}
+				if st, err = client.Get(req.Context, st.RequestID); err != nil {
+					return err
+				}
+			}
+		}
+
+		return cmds.EmitOnce(res, toRemotePinOutput(st))
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *RemotePinOutput) error {
+			return writeRemotePin(w, out)
+		}),
+	},
+}
+
+// connectDelegates connects to the nodes of a pinning service, so that they
+// find the content sooner. Failures are ignored, the service may still find
+// the content through the routing system.
+func connectDelegates(ctx context.Context, n *core.IpfsNode, delegates []string) {
+	for _, d := range delegates {
+		addr, err := ma.NewMultiaddr(d)
+		if err != nil {
+			continue
+		}
+		pi, err := peer.AddrInfoFromP2pAddr(addr)
+		if err != nil || pi.ID == n.Identity {
+			continue
+		}
+		if err := n.PeerHost.Connect(ctx, *pi); err != nil {
+			log.Debugf("could not connect to delegate %s: %s", d, err)
+		}
+	}
+}
+
+var pinRemoteLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the pin requests of a remote pinning service.",
+		ShortDescription: `
+Lists the pin requests of a remote pinning service, newest first. Only the
+pinned requests are listed unless --status is given.
+`,
+	},
+
+	Options: []cmds.Option{
+		cmds.StringOption(pinRemoteServiceOptionName, "Name of the remote pinning service."),
+		cmds.StringOption(pinRemoteNameOptionName, "Only list the requests with this name."),
+		cmds.StringOption(pinRemoteCidOptionName, "Only list the requests for these comma-separated CIDs."),
+		cmds.StringOption(pinRemoteStatusOptionName, "Only list the requests with these comma-separated statuses: queued, pinning, pinned or failed."),
+	},
+	Type: RemotePinOutput{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		client, err := remotePinClient(req, n)
+		if err != nil {
+			return err
+		}
+		opts, err := remotePinLsOptions(req)
+		if err != nil {
+			return err
+		}
+
+		pins, err := client.LsAll(req.Context, opts)
+		if err != nil {
+			return err
+		}
+		for i := range pins {
+			if err := res.Emit(toRemotePinOutput(&pins[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *RemotePinOutput) error {
+			return writeRemotePin(w, out)
+		}),
+	},
+}
+
+var pinRemoteRmCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove pin requests from a remote pinning service.",
+		ShortDescription: `
+Removes the pin requests matching the filters from a remote pinning service,
+which unpins their objects. Only the pinned requests match unless --status is
+given, and --force is required when several requests match.
+`,
+	},
+
+	Options: []cmds.Option{
+		cmds.StringOption(pinRemoteServiceOptionName, "Name of the remote pinning service."),
+		cmds.StringOption(pinRemoteNameOptionName, "Only remove the requests with this name."),
+		cmds.StringOption(pinRemoteCidOptionName, "Only remove the requests for these comma-separated CIDs."),
+		cmds.StringOption(pinRemoteStatusOptionName, "Only remove the requests with these comma-separated statuses: queued, pinning, pinned or failed."),
+		cmds.BoolOption(pinRemoteForceOptionName, "Remove all the matching requests when several match."),
+	},
+	Type: RemotePinOutput{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		client, err := remotePinClient(req, n)
+		if err != nil {
+			return err
+		}
+		opts, err := remotePinLsOptions(req)
+		if err != nil {
+			return err
+		}
+
+		pins, err := client.LsAll(req.Context, opts)
+		if err != nil {
+			return err
+		}
+		if force, _ := req.Options[pinRemoteForceOptionName].(bool); len(pins) > 1 && !force {
+			return fmt.Errorf("%d pin requests match, use --%s to remove all of them", len(pins), pinRemoteForceOptionName)
+		}
+
+		for i := range pins {
+			if err := client.Remove(req.Context, pins[i].RequestID); err != nil {
+				return err
+			}
+			if err := res.Emit(toRemotePinOutput(&pins[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *RemotePinOutput) error {
+			_, err := fmt.Fprintf(w, "removed %s %s\n", out.Cid, out.Name)
+			return err
+		}),
+	},
+}
+
+// remotePinClient returns a client of the service named by the --service
+// option.
+func remotePinClient(req *cmds.Request, n *core.IpfsNode) (*remote.Client, error) {
+	name, _ := req.Options[pinRemoteServiceOptionName].(string)
+	if name == "" {
+		return nil, fmt.Errorf("specify the remote pinning service with --%s", pinRemoteServiceOptionName)
+	}
+	services, err := remote.LoadServices(n.Repo)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := services[name]
+	if !ok {
+		return nil, fmt.Errorf("no remote pinning service %s, add it with 'ipfs pin remote service add'", name)
+	}
+	return remote.NewClient(s.API.Endpoint, s.API.Key), nil
+}
+
+// remotePinLsOptions returns the filters set by the options of the ls and rm
+// commands.
+func remotePinLsOptions(req *cmds.Request) (remote.LsOptions, error) {
+	var opts remote.LsOptions
+	opts.Name, _ = req.Options[pinRemoteNameOptionName].(string)
+
+	cids, _ := req.Options[pinRemoteCidOptionName].(string)
+	for _, s := range strings.Split(cids, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		c, err := cid.Decode(s)
+		if err != nil {
+			return opts, fmt.Errorf("invalid CID %s: %s", s, err)
+		}
+		opts.Cids = append(opts.Cids, c.String())
+	}
+
+	statuses, _ := req.Options[pinRemoteStatusOptionName].(string)
+	for _, s := range strings.Split(statuses, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		st, err := remote.ParseStatus(s)
+		if err != nil {
+			return opts, err
+		}
+		opts.Status = append(opts.Status, st)
+	}
+	return opts, nil
+}
+
+func writeRemotePin(w io.Writer, out *RemotePinOutput) error {
+	_, err := fmt.Fprintf(w, "%s\t%s\t%s\n", out.Cid, out.Status, out.Name)
+	return err
+}
+
+var pinRemoteServiceCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Configure remote pinning services.",
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"add": pinRemoteServiceAddCmd,
+		"ls":  pinRemoteServiceLsCmd,
+		"rm":  pinRemoteServiceRmCmd,
+	},
+}
+
+// RemotePinServiceOutput is a remote pinning service. Its key is never
+// returned.
+type RemotePinServiceOutput struct {
+	Service  string
+	Endpoint string
+}
+
+// RemotePinServiceList is the output type of 'ipfs pin remote service ls'.
+type RemotePinServiceList struct {
+	RemoteServices []RemotePinServiceOutput
+}
+
+var pinRemoteServiceAddCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Add a remote pinning service.",
+		ShortDescription: `
+Adds a remote pinning service to the config. The endpoint is the URL under
+which the service serves /pins, and the key is sent as bearer token.
+
+  > ipfs pin remote service add mysvc https://pinning.example.com/psa <key>
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("service", true, false, "Name of the service."),
+		cmds.StringArg("endpoint", true, false, "Endpoint of the service."),
+		cmds.StringArg("key", true, false, "Key of the service."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		name, endpoint, key := req.Arguments[0], req.Arguments[1], req.Arguments[2]
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid endpoint %q, must be an http or https URL", endpoint)
+		}
+
+		services, err := remote.LoadServices(n.Repo)
+		if err != nil {
+			return err
+		}
+		if _, ok := services[name]; ok {
+			return fmt.Errorf("remote pinning service %s already exists", name)
+		}
+		services[name] = &remote.Service{API: remote.ServiceAPI{Endpoint: endpoint, Key: key}}
+		return remote.SaveServices(n.Repo, services)
+	},
+}
+
+var pinRemoteServiceLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List remote pinning services.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		services, err := remote.LoadServices(n.Repo)
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(services))
+		for name := range services {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		list := make([]RemotePinServiceOutput, len(names))
+		for i, name := range names {
+			list[i] = RemotePinServiceOutput{Service: name, Endpoint: services[name].API.Endpoint}
+		}
+		return cmds.EmitOnce(res, &RemotePinServiceList{list})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, list *RemotePinServiceList) error {
+			tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			for _, s := range list.RemoteServices {
+				fmt.Fprintf(tw, "%s\t%s\n", s.Service, s.Endpoint)
+			}
+			return tw.Flush()
+		}),
+	},
+	Type: RemotePinServiceList{},
+}
+
+var pinRemoteServiceRmCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove remote pinning services.",
+		ShortDescription: `
+Removes remote pinning services from the config. The objects they pinned stay
+pinned, remove them first with 'ipfs pin remote rm'.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("service", true, true, "Names of the services to remove."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		services, err := remote.LoadServices(n.Repo)
+		if err != nil {
+			return err
+		}
+		for _, name := range req.Arguments {
+			if _, ok := services[name]; !ok {
+				return fmt.Errorf("no remote pinning service %s", name)
+			}
+			delete(services, name)
+		}
+		return remote.SaveServices(n.Repo, services)
+	},
+}
@@ -12,8 +12,10 @@ import (
 	"text/tabwriter"
 
 	humanize "github.com/dustin/go-humanize"
+	core "github.com/ipfs/go-ipfs/core"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+	gc "github.com/ipfs/go-ipfs/pin/gc"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
 
 	cid "github.com/ipfs/go-cid"
@@ -46,11 +48,25 @@ var RepoCmd = &cmds.Command{
 type GcResult struct {
 	Key   cid.Cid
 	Error string `json:",omitempty"`
+
+	// DryRun is only set on the last result of a dry run
+	DryRun *GcDryRunSummary `json:",omitempty"`
+}
+
+// GcDryRunSummary describes what "repo gc" would remove.
+type GcDryRunSummary struct {
+	Blocks uint64
+	Size   uint64
 }
 
 const (
 	repoStreamErrorsOptionName = "stream-errors"
 	repoQuietOptionName        = "quiet"
+	repoIncrementalOptionName  = "incremental"
+	repoBatchSizeOptionName    = "batch-size"
+	repoRateOptionName         = "rate"
+	repoDryRunOptionName       = "dry-run"
+	repoListOptionName         = "list"
 )
 
 var repoGcCmd = &cmds.Command{
@@ -61,10 +77,38 @@ var repoGcCmd = &cmds.Command{
 set of stored objects and remove ones that are not pinned in
 order to reclaim hard disk space.
 `,
+		LongDescription: `
+'ipfs repo gc' is a plumbing command that will sweep the local
+set of stored objects and remove ones that are not pinned in
+order to reclaim hard disk space.
+
+By default, adding and fetching content is blocked for the whole
+collection. With --incremental, the objects to keep are found without
+blocking other writes, and objects written in the meantime are kept. The
+others are then removed in batches of --batch-size objects, blocking
+writes for one batch at a time. --rate limits the number of objects
+removed per second.
+
+A running incremental collection can be paused and resumed with
+'ipfs repo gc pause' and 'ipfs repo gc resume'.
+
+With --dry-run, nothing is removed. Instead, the number of objects a
+collection would remove and their total size are reported. Add --list to
+also list these objects.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"pause":  repoGcPauseCmd,
+		"resume": repoGcResumeCmd,
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(repoStreamErrorsOptionName, "Stream errors."),
 		cmds.BoolOption(repoQuietOptionName, "q", "Write minimal output."),
+		cmds.BoolOption(repoIncrementalOptionName, "Don't block writes for the whole collection."),
+		cmds.IntOption(repoBatchSizeOptionName, "Number of objects removed per batch with --incremental.").WithDefault(gc.DefaultBatchSize),
+		cmds.IntOption(repoRateOptionName, "Maximum number of objects removed per second with --incremental. 0 means no limit."),
+		cmds.BoolOption(repoDryRunOptionName, "Only report what would be removed."),
+		cmds.BoolOption(repoListOptionName, "List the objects that would be removed with --dry-run."),
 	},
 	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := cmdenv.GetNode(env)
@@ -73,8 +117,35 @@ order to reclaim hard disk space.
 		}
 
 		streamErrors, _ := req.Options[repoStreamErrorsOptionName].(bool)
+		incremental, _ := req.Options[repoIncrementalOptionName].(bool)
+		dryRun, _ := req.Options[repoDryRunOptionName].(bool)
+
+		if dryRun {
+			if incremental {
+				return fmt.Errorf("--%s can't be combined with --%s", repoDryRunOptionName, repoIncrementalOptionName)
+			}
+			list, _ := req.Options[repoListOptionName].(bool)
+			return gcDryRun(req, re, n, list)
+		}
 
-		gcOutChan := corerepo.GarbageCollectAsync(n, req.Context)
+		var gcOutChan <-chan gc.Result
+		if incremental {
+			batchSize, _ := req.Options[repoBatchSizeOptionName].(int)
+			rate, _ := req.Options[repoRateOptionName].(int)
+			if batchSize <= 0 || rate < 0 {
+				return fmt.Errorf("--%s must be positive and --%s must not be negative", repoBatchSizeOptionName, repoRateOptionName)
+			}
+
+			gcOutChan, err = corerepo.GarbageCollectIncremental(n, req.Context, gc.IncrementalOptions{
+				BatchSize: batchSize,
+				Rate:      rate,
+			})
+			if err != nil {
+				return err
+			}
+		} else {
+			gcOutChan = corerepo.GarbageCollectAsync(n, req.Context)
+		}
 
 		if streamErrors {
 			errs := false
@@ -117,7 +188,18 @@ order to reclaim hard disk space.
 				return err
 			}
 
+			if gcr.DryRun != nil {
+				if quiet {
+					return nil
+				}
+				_, err := fmt.Fprintf(w, "%d unreachable objects, %s reclaimable\n", gcr.DryRun.Blocks, humanize.Bytes(gcr.DryRun.Size))
+				return err
+			}
+
 			prefix := "removed "
+			if dryRun, _ := req.Options[repoDryRunOptionName].(bool); dryRun {
+				prefix = "would remove "
+			}
 			if quiet {
 				prefix = ""
 			}
@@ -128,6 +210,66 @@ order to reclaim hard disk space.
 	},
 }
 
+func gcDryRun(req *cmds.Request, re cmds.ResponseEmitter, n *core.IpfsNode, list bool) error {
+	var summary GcDryRunSummary
+	errs := false
+	for res := range corerepo.GarbageCollectDryRun(n, req.Context) {
+		if res.Error != nil {
+			if err := re.Emit(&GcResult{Error: res.Error.Error()}); err != nil {
+				return err
+			}
+			errs = true
+			continue
+		}
+
+		summary.Blocks++
+		summary.Size += uint64(res.Size)
+		if list {
+			if err := re.Emit(&GcResult{Key: res.KeyRemoved}); err != nil {
+				return err
+			}
+		}
+	}
+	if errs {
+		return errors.New("encountered errors during gc dry run")
+	}
+
+	return re.Emit(&GcResult{DryRun: &summary})
+}
+
+var repoGcPauseCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Pause a running incremental garbage collection.",
+		ShortDescription: `
+'ipfs repo gc pause' stops a garbage collection started with
+'ipfs repo gc --incremental' after its current batch, until it is
+resumed with 'ipfs repo gc resume'.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		return corerepo.PauseGC(n)
+	},
+}
+
+var repoGcResumeCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Resume a paused incremental garbage collection.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		return corerepo.ResumeGC(n)
+	},
+}
+
 const (
 	repoSizeOnlyOptionName = "size-only"
 	repoHumanOptionName    = "human"
@@ -142,6 +284,9 @@ stored objects. It outputs:
 
 RepoSize        int Size in bytes that the repo is currently taking.
 StorageMax      string Maximum datastore size (from configuration)
+StorageMaxEnforced bool Set when writes exceeding StorageMax are refused.
+RejectedWrites  int Number of writes refused since the node started, only
+                reported when StorageMax is enforced.
 NumObjects      int Number of objects in the local repo.
 RepoPath        string The path to the repo being currently used.
 Version         string The repo version.
@@ -199,6 +344,10 @@ Version         string The repo version.
 
 			printSize("RepoSize", stat.RepoSize)
 			printSize("StorageMax", stat.StorageMax)
+			if stat.StorageMaxEnforced {
+				fmt.Fprintf(wtr, "StorageMaxEnforced:\t%t\n", stat.StorageMaxEnforced)
+				fmt.Fprintf(wtr, "RejectedWrites:\t%d\n", stat.RejectedWrites)
+			}
 
 			if !sizeOnly {
 				fmt.Fprintf(wtr, "RepoPath:\t%s\n", stat.RepoPath)
@@ -52,6 +52,7 @@ ADVANCED COMMANDS
   key           Create and list IPNS name keypairs
   dns           Resolve DNS links
   pin           Pin objects to local storage
+  denylist      Manage the content this node refuses to serve
   repo          Manipulate the IPFS repository
   stats         Various operational stats
   p2p           Libp2p stream mounting
@@ -67,6 +68,7 @@ NETWORK COMMANDS
 
 TOOL COMMANDS
   config        Manage configuration
+  api           Manage access to the HTTP API
   version       Show ipfs version information
   update        Download and apply go-ipfs updates
   commands      List all available commands
@@ -113,6 +115,7 @@ var CommandsDaemonCmd = CommandsCmd(Root)
 
 var rootSubcommands = map[string]*cmds.Command{
 	"add":       AddCmd,
+	"api":       APICmd,
 	"bitswap":   BitswapCmd,
 	"block":     BlockCmd,
 	"cat":       CatCmd,
@@ -126,6 +129,7 @@ var rootSubcommands = map[string]*cmds.Command{
 	"bootstrap": BootstrapCmd,
 	"config":    ConfigCmd,
 	"dag":       dag.DagCmd,
+	"denylist":  DenylistCmd,
 	"dht":       DhtCmd,
 	"diag":      DiagCmd,
 	"dns":       DNSCmd,
@@ -154,6 +158,10 @@ var rootSubcommands = map[string]*cmds.Command{
 // RootRO is the readonly version of Root
 var RootRO = &cmds.Command{}
 
+// RootAPI is the version of Root served by the HTTP API of the daemon. It
+// leaves out the commands exposing secrets of the node.
+var RootAPI = &cmds.Command{}
+
 var CommandsDaemonROCmd = CommandsCmd(RootRO)
 
 // RefsROCmd is `ipfs refs` command
@@ -216,6 +224,21 @@ func init() {
 
 	Root.Subcommands = rootSubcommands
 	RootRO.Subcommands = rootROSubcommands
+
+	// private keys are only exported by the CLI, never over the network
+	*RootAPI = *Root
+	RootAPI.Subcommands = make(map[string]*cmds.Command, len(rootSubcommands))
+	for name, cmd := range rootSubcommands {
+		RootAPI.Subcommands[name] = cmd
+	}
+	keyAPICmd := *KeyCmd
+	keyAPICmd.Subcommands = make(map[string]*cmds.Command, len(KeyCmd.Subcommands))
+	for name, cmd := range KeyCmd.Subcommands {
+		if name != "export" {
+			keyAPICmd.Subcommands[name] = cmd
+		}
+	}
+	RootAPI.Subcommands["key"] = &keyAPICmd
 }
 
 type MessageOutput struct {
@@ -18,11 +18,14 @@ import (
 	"github.com/ipfs/go-ipfs/core/bootstrap"
 	"github.com/ipfs/go-ipfs/core/node"
 	"github.com/ipfs/go-ipfs/core/node/libp2p"
+	"github.com/ipfs/go-ipfs/denylist"
 	"github.com/ipfs/go-ipfs/fuse/mount"
 	"github.com/ipfs/go-ipfs/namesys"
 	ipnsrp "github.com/ipfs/go-ipfs/namesys/republisher"
 	"github.com/ipfs/go-ipfs/p2p"
 	"github.com/ipfs/go-ipfs/pin"
+	pinqueue "github.com/ipfs/go-ipfs/pin/queue"
+	"github.com/ipfs/go-ipfs/quota"
 	"github.com/ipfs/go-ipfs/repo"
 
 	bserv "github.com/ipfs/go-blockservice"
@@ -67,6 +70,7 @@ type IpfsNode struct {
 
 	// Local node
 	Pinning         pin.Pinner             // the pinning manager
+	PinQueue        *pinqueue.Queue        // the pins added in the background
 	Mounts          Mounts                 `optional:"true"` // current mount state, if any.
 	PrivateKey      ic.PrivKey             `optional:"true"` // the local node's private Key
 	PNetFingerprint libp2p.PNetFingerprint `optional:"true"` // fingerprint of private network
@@ -84,6 +88,8 @@ type IpfsNode struct {
 	Discovery       discovery.Service    `optional:"true"`
 	FilesRoot       *mfs.Root
 	RecordValidator record.Validator
+	Denylist        *denylist.Denylist // content the node refuses to serve or pin
+	Quota           *quota.Quota       // enforces Datastore.StorageMax, nil if it isn't enforced
 
 	// Online
 	PeerHost     p2phost.Host        `optional:"true"` // the network host (server+client)
@@ -2,13 +2,33 @@ package coreapi
 
 import (
 	"context"
+	"io"
 
+	"github.com/ipfs/go-ipfs/car"
 	"github.com/ipfs/go-ipfs/pin"
 
 	cid "github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
 )
 
+// DagArchiveAPI is implemented by the DAG service returned from CoreAPI.Dag.
+// It allows moving whole DAGs in and out of the node as CARv1 archives
+// without changing any of their CIDs.
+type DagArchiveAPI interface {
+	coreiface.APIDagService
+
+	// Export writes the DAG rooted at root to w as a CARv1 archive
+	Export(ctx context.Context, root cid.Cid, w io.Writer) error
+
+	// Import stores all blocks of the CARv1 archive read from r and returns
+	// the roots listed in its header. If pin is set, the roots are pinned
+	// recursively.
+	Import(ctx context.Context, r io.Reader, pin bool) ([]cid.Cid, error)
+}
+
+var _ DagArchiveAPI = (*dagAPI)(nil)
+
 type dagAPI struct {
 	ipld.DAGService
 
@@ -51,3 +71,38 @@ func (adder *pinningAdder) AddMany(ctx context.Context, nds []ipld.Node) error {
 func (api *dagAPI) Pinning() ipld.NodeAdder {
 	return (*pinningAdder)(api.core)
 }
+
+func (api *dagAPI) Export(ctx context.Context, root cid.Cid, w io.Writer) error {
+	return car.WriteCar(ctx, api.DAGService, []cid.Cid{root}, w)
+}
+
+func (api *dagAPI) Import(ctx context.Context, r io.Reader, dopin bool) ([]cid.Cid, error) {
+	// take the pin lock so that the imported blocks can't be garbage
+	// collected before the roots are pinned
+	defer api.core.blockstore.PinLock().Unlock()
+
+	h, err := car.LoadCar(api.core.blockstore, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !dopin {
+		return h.Roots, nil
+	}
+
+	for _, c := range h.Roots {
+		nd, err := api.DAGService.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if err := api.core.pinning.Pin(ctx, nd, true); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := api.core.pinning.Flush(); err != nil {
+		return nil, err
+	}
+
+	return h.Roots, nil
+}
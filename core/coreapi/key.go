@@ -6,7 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"time"
 
+	keystore "github.com/ipfs/go-ipfs/keystore"
+	namesys "github.com/ipfs/go-ipfs/namesys"
+
+	proto "github.com/gogo/protobuf/proto"
+	ds "github.com/ipfs/go-datastore"
+	ipnspb "github.com/ipfs/go-ipns/pb"
 	ipfspath "github.com/ipfs/go-path"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	caopts "github.com/ipfs/interface-go-ipfs-core/options"
@@ -17,6 +24,36 @@ import (
 
 type KeyAPI CoreAPI
 
+// KeyTransferAPI is implemented by the KeyAPI returned from CoreAPI.Key. It
+// allows moving keys between nodes.
+type KeyTransferAPI interface {
+	coreiface.KeyAPI
+
+	// Export returns the private key stored under name, including the
+	// private key of the node identity for "self"
+	Export(ctx context.Context, name string) (crypto.PrivKey, error)
+
+	// Import stores sk under name. An existing key is only replaced if
+	// force is set.
+	Import(ctx context.Context, name string, sk crypto.PrivKey, force bool) (coreiface.Key, error)
+}
+
+var _ KeyTransferAPI = (*KeyAPI)(nil)
+
+// KeyRotationAPI is implemented by the KeyAPI returned from CoreAPI.Key. It
+// allows replacing a key without breaking the IPNS name it published to.
+type KeyRotationAPI interface {
+	coreiface.KeyAPI
+
+	// Rotate replaces the key stored under name with a newly generated one,
+	// which takes over the value published under the old name. The old key
+	// publishes a final record pointing to the new name and is kept in the
+	// keystore, under the returned name, so that this record is republished.
+	Rotate(ctx context.Context, name string, allowOffline bool, opts ...caopts.KeyGenerateOption) (newKey coreiface.Key, oldKey coreiface.Key, err error)
+}
+
+var _ KeyRotationAPI = (*KeyAPI)(nil)
+
 type key struct {
 	name   string
 	peerID peer.ID
@@ -54,32 +91,9 @@ func (api *KeyAPI) Generate(ctx context.Context, name string, opts ...caopts.Key
 		return nil, fmt.Errorf("key with name '%s' already exists", name)
 	}
 
-	var sk crypto.PrivKey
-	var pk crypto.PubKey
-
-	switch options.Algorithm {
-	case "rsa":
-		if options.Size == -1 {
-			options.Size = caopts.DefaultRSALen
-		}
-
-		priv, pub, err := crypto.GenerateKeyPairWithReader(crypto.RSA, options.Size, rand.Reader)
-		if err != nil {
-			return nil, err
-		}
-
-		sk = priv
-		pk = pub
-	case "ed25519":
-		priv, pub, err := crypto.GenerateEd25519Key(rand.Reader)
-		if err != nil {
-			return nil, err
-		}
-
-		sk = priv
-		pk = pub
-	default:
-		return nil, fmt.Errorf("unrecognized key type: %s", options.Algorithm)
+	sk, err := generateKey(options)
+	if err != nil {
+		return nil, err
 	}
 
 	err = api.repo.Keystore().Put(name, sk)
@@ -87,7 +101,7 @@ func (api *KeyAPI) Generate(ctx context.Context, name string, opts ...caopts.Key
 		return nil, err
 	}
 
-	pid, err := peer.IDFromPublicKey(pk)
+	pid, err := peer.IDFromPrivateKey(sk)
 	if err != nil {
 		return nil, err
 	}
@@ -95,6 +109,23 @@ func (api *KeyAPI) Generate(ctx context.Context, name string, opts ...caopts.Key
 	return &key{name, pid}, nil
 }
 
+func generateKey(options *caopts.KeyGenerateSettings) (crypto.PrivKey, error) {
+	switch options.Algorithm {
+	case "rsa":
+		if options.Size == -1 {
+			options.Size = caopts.DefaultRSALen
+		}
+
+		priv, _, err := crypto.GenerateKeyPairWithReader(crypto.RSA, options.Size, rand.Reader)
+		return priv, err
+	case "ed25519":
+		priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		return priv, err
+	default:
+		return nil, fmt.Errorf("unrecognized key type: %s", options.Algorithm)
+	}
+}
+
 // List returns a list keys stored in keystore.
 func (api *KeyAPI) List(ctx context.Context) ([]coreiface.Key, error) {
 	keys, err := api.repo.Keystore().List()
@@ -213,6 +244,225 @@ func (api *KeyAPI) Remove(ctx context.Context, name string) (coreiface.Key, erro
 	return &key{"", pid}, nil
 }
 
+// Export returns the private key stored under name.
+func (api *KeyAPI) Export(ctx context.Context, name string) (crypto.PrivKey, error) {
+	if name == "self" {
+		if api.privateKey == nil {
+			return nil, errors.New("identity not loaded")
+		}
+		return api.privateKey, nil
+	}
+
+	sk, err := api.repo.Keystore().Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("no key named %s was found", name)
+	}
+	return sk, nil
+}
+
+// Import stores sk in the keystore under name, replacing an existing key if
+// force is set.
+func (api *KeyAPI) Import(ctx context.Context, name string, sk crypto.PrivKey, force bool) (coreiface.Key, error) {
+	if name == "self" {
+		return nil, fmt.Errorf("cannot import key with name 'self'")
+	}
+
+	if err := keystore.ValidateName(name); err != nil {
+		return nil, err
+	}
+
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := api.repo.Keystore()
+
+	exists, err := ks.Has(name)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if !force {
+			return nil, fmt.Errorf("key with name '%s' already exists, use --force to overwrite it", name)
+		}
+		if err := api.replaceKey(name, sk); err != nil {
+			return nil, err
+		}
+		return &key{name, pid}, nil
+	}
+
+	if err := ks.Put(name, sk); err != nil {
+		return nil, err
+	}
+
+	return &key{name, pid}, nil
+}
+
+// replaceKey replaces the key stored under name with sk. The old key is
+// saved under another name until sk is stored, and put back if sk can't be.
+func (api *KeyAPI) replaceKey(name string, sk crypto.PrivKey) error {
+	ks := api.repo.Keystore()
+	oldSk, err := ks.Get(name)
+	if err != nil {
+		return err
+	}
+	oldID, err := peer.IDFromPrivateKey(oldSk)
+	if err != nil {
+		return err
+	}
+
+	backupName := fmt.Sprintf("%s.replaced-%s", name, oldID.Pretty())
+	if exists, err := ks.Has(backupName); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("key with name '%s' already exists", backupName)
+	}
+	if err := ks.Put(backupName, oldSk); err != nil {
+		return err
+	}
+
+	if err := ks.Delete(name); err != nil {
+		if derr := ks.Delete(backupName); derr != nil {
+			log.Errorf("could not remove key %s after a failed import: %s", backupName, derr)
+		}
+		return err
+	}
+	if err := ks.Put(name, sk); err != nil {
+		if rerr := ks.Put(name, oldSk); rerr != nil {
+			log.Errorf("could not restore key %s after a failed import, it is saved as %s: %s", name, backupName, rerr)
+			return err
+		}
+		if derr := ks.Delete(backupName); derr != nil {
+			log.Errorf("could not remove key %s after a failed import: %s", backupName, derr)
+		}
+		return err
+	}
+
+	if err := ks.Delete(backupName); err != nil {
+		log.Errorf("could not remove the replaced key %s: %s", backupName, err)
+	}
+	return nil
+}
+
+// Rotate replaces the key stored under name with a new one, and publishes a
+// succession record under the name of the old key.
+func (api *KeyAPI) Rotate(ctx context.Context, name string, allowOffline bool, opts ...caopts.KeyGenerateOption) (coreiface.Key, coreiface.Key, error) {
+	if err := api.checkPublishAllowed(); err != nil {
+		return nil, nil, err
+	}
+	if err := api.checkOnline(allowOffline); err != nil {
+		return nil, nil, err
+	}
+
+	options, err := caopts.KeyGenerateOptions(opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if name == "self" {
+		return nil, nil, fmt.Errorf("cannot rotate the key of the node identity")
+	}
+
+	ks := api.repo.Keystore()
+	oldSk, err := ks.Get(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("no key named %s was found", name)
+	}
+	oldID, err := peer.IDFromPrivateKey(oldSk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	retiredName := fmt.Sprintf("%s.rotated-%s", name, oldID.Pretty())
+	if exists, err := ks.Has(retiredName); err != nil {
+		return nil, nil, err
+	} else if exists {
+		return nil, nil, fmt.Errorf("key with name '%s' already exists", retiredName)
+	}
+
+	// the last value published by the old key, if any
+	var value ipfspath.Path
+	if rec, err := api.repo.Datastore().Get(namesys.IpnsDsKey(oldID)); err == nil {
+		entry := new(ipnspb.IpnsEntry)
+		if err := proto.Unmarshal(rec, entry); err != nil {
+			return nil, nil, err
+		}
+		if namesys.IsSuccession(entry.GetValue()) {
+			return nil, nil, namesys.ErrNameRotated
+		}
+		value = ipfspath.Path(entry.GetValue())
+	} else if err != ds.ErrNotFound {
+		return nil, nil, err
+	}
+
+	newSk, err := generateKey(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	newID, err := peer.IDFromPrivateKey(newSk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// save the new key before anything points to it, keeping the old key
+	// so that its final record keeps being republished
+	if err := ks.Put(retiredName, oldSk); err != nil {
+		return nil, nil, err
+	}
+	if err := ks.Delete(name); err != nil {
+		api.restoreRotated(name, retiredName, oldSk, false)
+		return nil, nil, err
+	}
+	if err := ks.Put(name, newSk); err != nil {
+		api.restoreRotated(name, retiredName, oldSk, false)
+		return nil, nil, err
+	}
+
+	// the succession is published last: it can't be taken back
+	if value != "" {
+		if err := api.namesys.Publish(ctx, newSk, value); err != nil {
+			api.restoreRotated(name, retiredName, oldSk, true)
+			return nil, nil, err
+		}
+	}
+
+	s, err := namesys.NewSuccession(oldSk, newSk)
+	if err == nil {
+		var sv ipfspath.Path
+		if sv, err = s.Value(); err == nil {
+			err = api.namesys.PublishWithEOL(ctx, oldSk, sv, time.Now().Add(namesys.SuccessionRecordEOL))
+		}
+	}
+	if err != nil {
+		api.restoreRotated(name, retiredName, oldSk, true)
+		return nil, nil, err
+	}
+
+	return &key{name, newID}, &key{retiredName, oldID}, nil
+}
+
+// restoreRotated puts the old key of a failed rotation back under name,
+// removing the new key if it was saved.
+func (api *KeyAPI) restoreRotated(name, retiredName string, oldSk crypto.PrivKey, newSaved bool) {
+	ks := api.repo.Keystore()
+	if newSaved {
+		if err := ks.Delete(name); err != nil {
+			log.Errorf("could not remove the new key of %s after a failed rotation: %s", name, err)
+			return
+		}
+	}
+	if has, err := ks.Has(name); err == nil && !has {
+		if err := ks.Put(name, oldSk); err != nil {
+			log.Errorf("could not restore key %s after a failed rotation, it is saved as %s: %s", name, retiredName, err)
+			return
+		}
+	}
+	if err := ks.Delete(retiredName); err != nil {
+		log.Errorf("could not remove key %s after a failed rotation: %s", retiredName, err)
+	}
+}
+
 func (api *KeyAPI) Self(ctx context.Context) (coreiface.Key, error) {
 	if api.identity == "" {
 		return nil, errors.New("identity not loaded")
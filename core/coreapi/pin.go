@@ -3,11 +3,18 @@ package coreapi
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-ipfs/denylist"
+	"github.com/ipfs/go-ipfs/pin"
 
 	bserv "github.com/ipfs/go-blockservice"
 	cid "github.com/ipfs/go-cid"
 	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
 	merkledag "github.com/ipfs/go-merkledag"
+	gopath "github.com/ipfs/go-path"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	caopts "github.com/ipfs/interface-go-ipfs-core/options"
 	path "github.com/ipfs/interface-go-ipfs-core/path"
@@ -15,12 +22,77 @@ import (
 
 type PinAPI CoreAPI
 
+// NamedPinAPI is implemented by the PinAPI returned from CoreAPI.Pin. It
+// allows pins to carry a name and metadata. Pins held under different names
+// are tracked separately, so removing one leaves the others in place.
+type NamedPinAPI interface {
+	coreiface.PinAPI
+
+	// AddNamed pins the object at the path under the given name
+	AddNamed(ctx context.Context, p path.Path, name string, meta map[string]string, opts ...caopts.PinAddOption) error
+
+	// AddExpiring pins the object at the path under the given name until
+	// expires, after which the daemon removes the pin. A zero expires makes
+	// the pin permanent.
+	AddExpiring(ctx context.Context, p path.Path, name string, meta map[string]string, expires time.Time, opts ...caopts.PinAddOption) error
+
+	// AddWithProgress pins the object at the path like AddExpiring,
+	// reporting the number of bytes fetched so far to progress.
+	AddWithProgress(ctx context.Context, p path.Path, name string, meta map[string]string, expires time.Time, progress func(bytes uint64), opts ...caopts.PinAddOption) error
+
+	// RmNamed removes the pin held on the object under the given name
+	RmNamed(ctx context.Context, p path.Path, name string, opts ...caopts.PinRmOption) error
+
+	// LsNamed returns the direct and recursive pins held under the given name
+	LsNamed(ctx context.Context, name string, opts ...caopts.PinLsOption) ([]coreiface.Pin, error)
+}
+
+// NamedPin is implemented by the pins returned from PinAPI.Ls and
+// NamedPinAPI.LsNamed
+type NamedPin interface {
+	coreiface.Pin
+
+	// Name returns the name of the pin, which is empty for unnamed and
+	// indirect pins
+	Name() string
+
+	// Meta returns the metadata recorded with the pin
+	Meta() map[string]string
+
+	// Expires returns when the pin expires, the zero time for permanent and
+	// indirect pins
+	Expires() time.Time
+}
+
+var _ NamedPinAPI = (*PinAPI)(nil)
+
 func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOption) error {
+	return api.AddNamed(ctx, p, "", nil, opts...)
+}
+
+func (api *PinAPI) AddNamed(ctx context.Context, p path.Path, name string, meta map[string]string, opts ...caopts.PinAddOption) error {
+	return api.AddExpiring(ctx, p, name, meta, time.Time{}, opts...)
+}
+
+func (api *PinAPI) AddExpiring(ctx context.Context, p path.Path, name string, meta map[string]string, expires time.Time, opts ...caopts.PinAddOption) error {
+	return api.AddWithProgress(ctx, p, name, meta, expires, nil, opts...)
+}
+
+func (api *PinAPI) AddWithProgress(ctx context.Context, p path.Path, name string, meta map[string]string, expires time.Time, progress func(bytes uint64), opts ...caopts.PinAddOption) error {
+	// check the path before fetching the content it points to
+	if err := api.denylist.CheckPath(ctx, api.namesys, api.dag, gopath.Path(p.String())); err != nil {
+		return fmt.Errorf("pin: %s", err)
+	}
+
 	dagNode, err := api.core().ResolveNode(ctx, p)
 	if err != nil {
 		return fmt.Errorf("pin: %s", err)
 	}
 
+	if err := api.denylist.Check(dagNode.Cid()); err != nil {
+		return fmt.Errorf("pin: %s", err)
+	}
+
 	settings, err := caopts.PinAddOptions(opts...)
 	if err != nil {
 		return err
@@ -28,7 +100,15 @@ func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOp
 
 	defer api.blockstore.PinLock().Unlock()
 
-	err = api.pinning.Pin(ctx, dagNode, settings.Recursive)
+	// the content is fetched under the pin lock, like the pinner does, so
+	// that the GC can't remove it before it is pinned
+	if progress != nil {
+		if err := api.fetch(ctx, dagNode.Cid(), settings.Recursive, progress); err != nil {
+			return fmt.Errorf("pin: %s", err)
+		}
+	}
+
+	err = api.pinning.PinExpiring(ctx, dagNode, settings.Recursive, name, meta, expires)
 	if err != nil {
 		return fmt.Errorf("pin: %s", err)
 	}
@@ -40,6 +120,30 @@ func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOp
 	return api.pinning.Flush()
 }
 
+// fetch fetches the node c, and all its descendants if recursive, reporting
+// the number of bytes fetched so far to progress. The descendants are fetched
+// in parallel, as FetchGraph does for foreground pins.
+func (api *PinAPI) fetch(ctx context.Context, c cid.Cid, recursive bool, progress func(bytes uint64)) error {
+	ng := &denylist.NodeGetter{NodeGetter: merkledag.NewSession(ctx, api.dag), Denylist: api.denylist}
+	var lk sync.Mutex
+	var fetched uint64
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		nd, err := ng.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		lk.Lock()
+		fetched += uint64(len(nd.RawData()))
+		progress(fetched)
+		lk.Unlock()
+		if !recursive {
+			return nil, nil
+		}
+		return nd.Links(), nil
+	}
+	return merkledag.WalkParallel(ctx, getLinks, c, cid.NewSet().Visit)
+}
+
 func (api *PinAPI) Ls(ctx context.Context, opts ...caopts.PinLsOption) ([]coreiface.Pin, error) {
 	settings, err := caopts.PinLsOptions(opts...)
 	if err != nil {
@@ -55,8 +159,37 @@ func (api *PinAPI) Ls(ctx context.Context, opts ...caopts.PinLsOption) ([]coreif
 	return api.pinLsAll(settings.Type, ctx)
 }
 
+func (api *PinAPI) LsNamed(ctx context.Context, name string, opts ...caopts.PinLsOption) ([]coreiface.Pin, error) {
+	settings, err := caopts.PinLsOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	switch settings.Type {
+	case "all", "direct", "recursive":
+	default:
+		return nil, fmt.Errorf("invalid type '%s', must be one of {direct, recursive, all}", settings.Type)
+	}
+
+	var out []coreiface.Pin
+	for _, p := range api.pinning.NamedPins() {
+		if p.Name != name {
+			continue
+		}
+		info := newPinInfo(p)
+		if settings.Type == "all" || settings.Type == info.pinType {
+			out = append(out, info)
+		}
+	}
+	return out, nil
+}
+
 // Rm pin rm api
 func (api *PinAPI) Rm(ctx context.Context, p path.Path, opts ...caopts.PinRmOption) error {
+	return api.RmNamed(ctx, p, "", opts...)
+}
+
+func (api *PinAPI) RmNamed(ctx context.Context, p path.Path, name string, opts ...caopts.PinRmOption) error {
 	rp, err := api.core().ResolvePath(ctx, p)
 	if err != nil {
 		return err
@@ -71,7 +204,7 @@ func (api *PinAPI) Rm(ctx context.Context, p path.Path, opts ...caopts.PinRmOpti
 	// to take a lock to prevent a concurrent garbage collection
 	defer api.blockstore.PinLock().Unlock()
 
-	if err = api.pinning.Unpin(ctx, rp.Cid(), settings.Recursive); err != nil {
+	if err = api.pinning.UnpinNamed(ctx, rp.Cid(), settings.Recursive, name); err != nil {
 		return err
 	}
 
@@ -180,6 +313,20 @@ func (api *PinAPI) Verify(ctx context.Context) (<-chan coreiface.PinStatus, erro
 type pinInfo struct {
 	pinType string
 	path    path.Resolved
+	name    string
+	meta    map[string]string
+	expires time.Time
+}
+
+func newPinInfo(p pin.PinInfo) *pinInfo {
+	pinType, _ := pin.ModeToString(p.Mode)
+	return &pinInfo{
+		pinType: pinType,
+		path:    path.IpldPath(p.Key),
+		name:    p.Name,
+		meta:    p.Meta,
+		expires: p.Expires,
+	}
 }
 
 func (p *pinInfo) Path() path.Resolved {
@@ -190,6 +337,18 @@ func (p *pinInfo) Type() string {
 	return p.pinType
 }
 
+func (p *pinInfo) Name() string {
+	return p.name
+}
+
+func (p *pinInfo) Meta() map[string]string {
+	return p.meta
+}
+
+func (p *pinInfo) Expires() time.Time {
+	return p.expires
+}
+
 func (api *PinAPI) pinLsAll(typeStr string, ctx context.Context) ([]coreiface.Pin, error) {
 
 	keys := make(map[cid.Cid]*pinInfo)
@@ -203,9 +362,15 @@ func (api *PinAPI) pinLsAll(typeStr string, ctx context.Context) ([]coreiface.Pi
 		}
 	}
 
-	if typeStr == "direct" || typeStr == "all" {
-		AddToResultKeys(api.pinning.DirectKeys(), "direct")
+	// a cid pinned under several names is listed once for every name
+	var named []coreiface.Pin
+	for _, p := range api.pinning.NamedPins() {
+		info := newPinInfo(p)
+		if typeStr == "all" || typeStr == info.pinType {
+			named = append(named, info)
+		}
 	}
+
 	if typeStr == "indirect" || typeStr == "all" {
 		set := cid.NewSet()
 		for _, k := range api.pinning.RecursiveKeys() {
@@ -222,11 +387,13 @@ func (api *PinAPI) pinLsAll(typeStr string, ctx context.Context) ([]coreiface.Pi
 		}
 		AddToResultKeys(set.Keys(), "indirect")
 	}
-	if typeStr == "recursive" || typeStr == "all" {
-		AddToResultKeys(api.pinning.RecursiveKeys(), "recursive")
+	// pins of another type take precedence over indirect ones
+	for _, p := range named {
+		delete(keys, p.Path().Cid())
 	}
 
-	out := make([]coreiface.Pin, 0, len(keys))
+	out := make([]coreiface.Pin, 0, len(keys)+len(named))
+	out = append(out, named...)
 	for _, v := range keys {
 		out = append(out, v)
 	}
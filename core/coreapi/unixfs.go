@@ -7,6 +7,7 @@ import (
 	"github.com/ipfs/go-ipfs/core"
 
 	"github.com/ipfs/go-ipfs/core/coreunix"
+	"github.com/ipfs/go-ipfs/quota"
 
 	blockservice "github.com/ipfs/go-blockservice"
 	cid "github.com/ipfs/go-cid"
@@ -41,14 +42,6 @@ func (api *UnixfsAPI) Add(ctx context.Context, files files.Node, opts ...options
 		return nil, err
 	}
 
-	// check if repo will exceed storage limit if added
-	// TODO: this doesn't handle the case if the hashed file is already in blocks (deduplicated)
-	// TODO: conditional GC is disabled due to it is somehow not possible to pass the size to the daemon
-	//if err := corerepo.ConditionalGC(req.Context(), n, uint64(size)); err != nil {
-	//	res.SetError(err, cmds.ErrNormal)
-	//	return
-	//}
-
 	if settings.NoCopy && !(cfg.Experimental.FilestoreEnabled || cfg.Experimental.UrlstoreEnabled) {
 		return nil, fmt.Errorf("either the filestore or the urlstore must be enabled to use nocopy, see: https://git.io/vNItf")
 	}
@@ -56,6 +49,9 @@ func (api *UnixfsAPI) Add(ctx context.Context, files files.Node, opts ...options
 	addblockstore := api.blockstore
 	if !(settings.FsCache || settings.NoCopy) {
 		addblockstore = bstore.NewGCBlockstore(api.baseBlocks, api.blockstore)
+		if api.quota != nil {
+			addblockstore = &quota.Blockstore{GCBlockstore: addblockstore, Quota: api.quota}
+		}
 	}
 	exch := api.exchange
 	pinning := api.pinning
@@ -122,6 +118,19 @@ func (api *UnixfsAPI) Add(ctx context.Context, files files.Node, opts ...options
 		fileAdder.SetMfsRoot(mr)
 	}
 
+	if api.quota != nil && !settings.OnlyHash {
+		// collecting garbage waits for the pin lock taken by the adder, make
+		// room for the files first. Their size is unknown when streamed, the
+		// repo is then only made not to be full.
+		room := uint64(1)
+		if size, err := files.Size(); err == nil {
+			room = uint64(size)
+		}
+		if err := api.quota.MakeRoom(ctx, room); err != nil {
+			return nil, err
+		}
+	}
+
 	nd, err := fileAdder.AddAllAndPin(files)
 	if err != nil {
 		return nil, err
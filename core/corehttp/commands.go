@@ -12,6 +12,7 @@ import (
 	version "github.com/ipfs/go-ipfs"
 	oldcmds "github.com/ipfs/go-ipfs/commands"
 	"github.com/ipfs/go-ipfs/core"
+	apitoken "github.com/ipfs/go-ipfs/core/apitoken"
 	corecommands "github.com/ipfs/go-ipfs/core/commands"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
@@ -117,7 +118,7 @@ func patchCORSVars(c *cmdsHttp.ServerConfig, addr net.Addr) {
 	c.SetAllowedOrigins(newOrigins...)
 }
 
-func commandsOption(cctx oldcmds.Context, command *cmds.Command) ServeOption {
+func commandsOption(cctx oldcmds.Context, command *cmds.Command, authenticate bool) ServeOption {
 	return func(n *core.IpfsNode, l net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
 
 		cfg := cmdsHttp.NewServerConfig()
@@ -133,22 +134,104 @@ func commandsOption(cctx oldcmds.Context, command *cmds.Command) ServeOption {
 		addCORSDefaults(cfg)
 		patchCORSVars(cfg, l.Addr())
 
-		cmdHandler := cmdsHttp.NewHandler(&cctx, command, cfg)
+		var cmdHandler http.Handler = cmdsHttp.NewHandler(&cctx, command, cfg)
+		if authenticate {
+			auth, err := apitoken.NewAuthorizer(n.Repo)
+			if err != nil {
+				return nil, err
+			}
+			cmdHandler = &authHandler{auth: auth, next: cmdHandler}
+		}
 		mux.Handle(APIPath+"/", cmdHandler)
 		return mux, nil
 	}
 }
 
 // CommandsOption constructs a ServerOption for hooking the commands into the
-// HTTP server.
+// HTTP server. Once API tokens are configured, requests must carry one
+// allowing the requested command.
 func CommandsOption(cctx oldcmds.Context) ServeOption {
-	return commandsOption(cctx, corecommands.Root)
+	return commandsOption(cctx, corecommands.RootAPI, true)
 }
 
 // CommandsROOption constructs a ServerOption for hooking the read-only commands
 // into the HTTP server.
 func CommandsROOption(cctx oldcmds.Context) ServeOption {
-	return commandsOption(cctx, corecommands.RootRO)
+	return commandsOption(cctx, corecommands.RootRO, false)
+}
+
+// authHandler checks the bearer token of API requests before passing them to
+// the commands handler.
+type authHandler struct {
+	auth *apitoken.Authorizer
+	next http.Handler
+}
+
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	enabled, err := h.auth.Enabled()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// CORS preflight requests don't carry credentials and don't run commands
+	if !enabled || r.Method == http.MethodOptions {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	hdr := r.Header.Get("Authorization")
+	if !strings.HasPrefix(hdr, "Bearer ") {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "missing API token", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := h.auth.Authorize(strings.TrimSpace(hdr[len("Bearer "):]))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if token == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "invalid API token", http.StatusUnauthorized)
+		return
+	}
+
+	cmdPath := strings.Trim(strings.TrimPrefix(r.URL.Path, APIPath), "/")
+	if cmdPath == "" || !token.Allows(cmdPath) {
+		http.Error(w, fmt.Sprintf("API token not allowed to run %q", cmdPath), http.StatusForbidden)
+		return
+	}
+	// a token can't create a token allowed more than itself
+	if isCommand(cmdPath, apiTokenCreatePath) && !token.Covers(requestedAllow(r)) {
+		http.Error(w, "API token not allowed to create a token allowing more commands than itself", http.StatusForbidden)
+		return
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+// apiTokenCreatePath is the path of the command creating API tokens.
+const apiTokenCreatePath = "api/token/create"
+
+// isCommand returns whether the request path cmdPath runs the command at
+// path p. Like the commands handler, the path elements following the command
+// are taken as its arguments.
+func isCommand(cmdPath, p string) bool {
+	return cmdPath == p || strings.HasPrefix(cmdPath, p+"/")
+}
+
+// requestedAllow returns the command paths a token created by the request
+// would allow, given with the --allow option or its alias.
+func requestedAllow(r *http.Request) []string {
+	q := r.URL.Query()
+	var allow []string
+	for _, name := range []string{"allow", "a"} {
+		for _, v := range q[name] {
+			allow = append(allow, apitoken.ParseAllow(v)...)
+		}
+	}
+	return allow
 }
 
 // CheckVersionOption returns a ServeOption that checks whether the client ipfs version matches. Does nothing when the user agent string does not contain `/go-ipfs/`
@@ -0,0 +1,134 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	apitoken "github.com/ipfs/go-ipfs/core/apitoken"
+	coremock "github.com/ipfs/go-ipfs/core/mock"
+	repo "github.com/ipfs/go-ipfs/repo"
+)
+
+// tokenRepo is a repo holding API tokens in its config.
+type tokenRepo struct {
+	repo.Mock
+	tokens map[string]*apitoken.Token
+}
+
+func (r *tokenRepo) GetConfigKey(key string) (interface{}, error) {
+	if key != apitoken.ConfigKey {
+		return nil, nil
+	}
+	return r.tokens, nil
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	r := &tokenRepo{}
+	auth, err := apitoken.NewAuthorizer(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &authHandler{
+		auth: auth,
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	}
+
+	do := func(cmdPath, token string) int {
+		req := httptest.NewRequest("POST", APIPath+"/"+cmdPath, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// no token configured
+	if code := do("shutdown", ""); code != http.StatusOK {
+		t.Fatalf("expected unauthenticated access without tokens, got %d", code)
+	}
+
+	token, err := apitoken.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := apitoken.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the authorizer picks up config changes
+	r.tokens = map[string]*apitoken.Token{
+		"ci":    {Hash: apitoken.Hash(token), Allow: []string{"cat", "pin/add", "api/token/create"}},
+		"admin": {Hash: apitoken.Hash(admin), Allow: []string{"*"}},
+	}
+
+	for _, test := range []struct {
+		cmdPath string
+		token   string
+		code    int
+	}{
+		{"cat", "", http.StatusUnauthorized},
+		{"cat", "wrong", http.StatusUnauthorized},
+		{"cat", token, http.StatusOK},
+		{"pin/add", token, http.StatusOK},
+		{"pin/rm", token, http.StatusForbidden},
+		{"shutdown", token, http.StatusForbidden},
+		{"", token, http.StatusForbidden},
+		{"api/token/create?arg=a&allow=cat", token, http.StatusOK},
+		{"api/token/create?arg=a&allow=pin/add,cat", token, http.StatusOK},
+		{"api/token/create?arg=a&allow=pin", token, http.StatusForbidden},
+		{"api/token/create?arg=a&allow=*", token, http.StatusForbidden},
+		{"api/token/create?arg=a&a=cat,shutdown", token, http.StatusForbidden},
+		{"api/token/create/a?allow=*", token, http.StatusForbidden},
+		{"api/token/create/a?allow=cat", token, http.StatusOK},
+		{"api/token/create?arg=a&allow=*", admin, http.StatusOK},
+	} {
+		if code := do(test.cmdPath, test.token); code != test.code {
+			t.Errorf("%s with token %q: expected %d, got %d", test.cmdPath, test.token, test.code, code)
+		}
+	}
+
+	// revoking a token takes effect immediately
+	delete(r.tokens, "ci")
+	if code := do("cat", token); code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked token to be rejected, got %d", code)
+	}
+}
+
+func TestAPIKeyExportRejected(t *testing.T) {
+	cctx, err := coremock.MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := cctx.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.IsDaemon = true
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	defer ts.Close()
+	if dh.Handler, err = makeHandler(n, ts.Listener, CommandsOption(cctx)); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(cmdPath string) int {
+		res, err := http.Post(ts.URL+APIPath+"/"+cmdPath, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if code := do("key/list"); code != http.StatusOK {
+		t.Fatalf("expected key/list to be served, got %d", code)
+	}
+	for _, p := range []string{"key/export?arg=self", "key/export?arg=self&format=pem-pkcs8-cleartext"} {
+		if code := do(p); code == http.StatusOK {
+			t.Fatalf("expected %s to be rejected", p)
+		}
+	}
+}
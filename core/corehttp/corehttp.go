@@ -47,7 +47,8 @@ func makeHandler(n *core.IpfsNode, l net.Listener, options ...ServeOption) (http
 }
 
 // ListenAndServe runs an HTTP server listening at |listeningMultiAddr| with
-// the given serve options. The address must be provided in multiaddr format.
+// the given serve options. The address must be provided in multiaddr format,
+// and may be a unix domain socket, which is only accessible to its owner.
 //
 // TODO intelligently parse address strings in other formats so long as they
 // unambiguously map to a valid multiaddr. e.g. for convenience, ":8080" should
@@ -58,7 +59,7 @@ func ListenAndServe(n *core.IpfsNode, listeningMultiAddr string, options ...Serv
 		return err
 	}
 
-	list, err := manet.Listen(addr)
+	list, err := Listen(addr, APISocketMode)
 	if err != nil {
 		return err
 	}
@@ -8,6 +8,7 @@ import (
 
 	version "github.com/ipfs/go-ipfs"
 	core "github.com/ipfs/go-ipfs/core"
+	apitoken "github.com/ipfs/go-ipfs/core/apitoken"
 	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
 
 	options "github.com/ipfs/interface-go-ipfs-core/options"
@@ -74,6 +75,7 @@ func GatewayOption(writable bool, paths ...string) ServeOption {
 
 		headers[ACAHeadersName] = cleanHeaderSet(
 			append([]string{
+				"Authorization",
 				"Content-Type",
 				"User-Agent",
 				"Range",
@@ -92,6 +94,11 @@ func GatewayOption(writable bool, paths ...string) ServeOption {
 			Writable:     writable,
 			PathPrefixes: cfg.Gateway.PathPrefixes,
 		}, api)
+		if writable {
+			if gateway.auth, err = apitoken.NewAuthorizer(n.Repo); err != nil {
+				return nil, err
+			}
+		}
 
 		for _, p := range paths {
 			mux.Handle(p+"/", gateway)
@@ -0,0 +1,175 @@
+package corehttp
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	gopath "path"
+	"strings"
+
+	files "github.com/ipfs/go-ipfs-files"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// serveArchive streams nd as a tar or zip archive. The archive is written as
+// the DAG is traversed, so a failure midway can only be reported by aborting
+// the response.
+func (i *gatewayHandler) serveArchive(w http.ResponseWriter, r *http.Request, urlPath string, resolvedPath ipath.Resolved, nd files.Node, format string) {
+	// the name of the root of the archive, which mustn't escape the
+	// directory the archive is extracted to
+	name := getFilename(urlPath)
+	if n := r.URL.Query().Get("filename"); n != "" {
+		name = gopath.Base(gopath.Clean("/" + n))
+	}
+	if name == "" || name == "/" || name == "." {
+		name = resolvedPath.Cid().String()
+	}
+
+	filename := ""
+	if r.URL.Query().Get("download") == "true" {
+		filename = name + "." + format
+	}
+	if !i.setFormatHeaders(w, r, urlPath, resolvedPath.Cid(), format, filename) {
+		return
+	}
+	w.Header().Set("Content-Type", formatMediaTypes[format])
+	w.Header().Set("Accept-Ranges", "none")
+
+	if r.Method == "HEAD" {
+		return
+	}
+
+	// the names of the entries come from the DAG, and are checked as it is
+	// traversed
+	nd = checkedNode(nd)
+
+	var err error
+	switch format {
+	case tarFormat:
+		err = writeTar(w, nd, name)
+	case zipFormat:
+		err = writeZip(w, nd, name)
+	}
+	if err != nil {
+		log.Warningf("aborted %s response for %s: %s", format, urlPath, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// writeTar writes nd to w as a tar archive, the way 'ipfs get -a' does.
+func writeTar(w io.Writer, nd files.Node, name string) error {
+	tw, err := files.NewTarWriter(w)
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteFile(nd, name); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// writeZip writes nd to w as a zip archive.
+func writeZip(w io.Writer, nd files.Node, name string) error {
+	zw := zip.NewWriter(w)
+	if err := writeZipNode(zw, nd, name); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeZipNode(zw *zip.Writer, nd files.Node, fpath string) error {
+	switch nd := nd.(type) {
+	case *files.Symlink:
+		hdr := &zip.FileHeader{Name: fpath, Method: zip.Store}
+		hdr.SetMode(0777 | os.ModeSymlink)
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(fw, nd.Target)
+		return err
+
+	case files.File:
+		hdr := &zip.FileHeader{Name: fpath, Method: zip.Deflate}
+		hdr.SetMode(0644)
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, nd)
+		return err
+
+	case files.Directory:
+		hdr := &zip.FileHeader{Name: fpath + "/", Method: zip.Store}
+		hdr.SetMode(0755 | os.ModeDir)
+		if _, err := zw.CreateHeader(hdr); err != nil {
+			return err
+		}
+
+		it := nd.Entries()
+		for it.Next() {
+			if err := writeZipNode(zw, it.Node(), gopath.Join(fpath, it.Name())); err != nil {
+				return err
+			}
+		}
+		return it.Err()
+
+	default:
+		return fmt.Errorf("unsupported file type %T", nd)
+	}
+}
+
+// checkEntryName returns an error if name, the name of a directory entry,
+// isn't a single path element: an archive entry with such a name could be
+// extracted outside of its directory.
+func checkEntryName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid directory entry name %q", name)
+	}
+	return nil
+}
+
+// checkedNode wraps nd so that the names of the entries of its directories
+// are checked with checkEntryName as they are iterated.
+func checkedNode(nd files.Node) files.Node {
+	if dir, ok := nd.(files.Directory); ok {
+		return &checkedDirectory{dir}
+	}
+	return nd
+}
+
+type checkedDirectory struct {
+	files.Directory
+}
+
+func (d *checkedDirectory) Entries() files.DirIterator {
+	return &checkedIterator{DirIterator: d.Directory.Entries()}
+}
+
+type checkedIterator struct {
+	files.DirIterator
+	err error
+}
+
+func (it *checkedIterator) Next() bool {
+	if it.err != nil || !it.DirIterator.Next() {
+		return false
+	}
+	if it.err = checkEntryName(it.DirIterator.Name()); it.err != nil {
+		return false
+	}
+	return true
+}
+
+func (it *checkedIterator) Node() files.Node {
+	return checkedNode(it.DirIterator.Node())
+}
+
+func (it *checkedIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.DirIterator.Err()
+}
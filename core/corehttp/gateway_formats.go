@@ -0,0 +1,146 @@
+package corehttp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-ipfs/car"
+	"github.com/ipfs/go-ipfs/denylist"
+
+	cid "github.com/ipfs/go-cid"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// Formats the gateway serves content in besides deserialized UnixFS, which
+// are requested with the format query parameter or the Accept header.
+const (
+	rawFormat     = "raw"
+	carFormat     = "car"
+	tarFormat     = "tar"
+	zipFormat     = "zip"
+	jsonFormat    = "json"
+	dagJSONFormat = "dag-json"
+)
+
+const (
+	rawMediaType     = "application/vnd.ipld.raw"
+	carMediaType     = "application/vnd.ipld.car"
+	tarMediaType     = "application/x-tar"
+	zipMediaType     = "application/zip"
+	jsonMediaType    = "application/json"
+	dagJSONMediaType = "application/vnd.ipld.dag-json"
+)
+
+var formatMediaTypes = map[string]string{
+	rawFormat:     rawMediaType,
+	carFormat:     carMediaType,
+	tarFormat:     tarMediaType,
+	zipFormat:     zipMediaType,
+	jsonFormat:    jsonMediaType,
+	dagJSONFormat: dagJSONMediaType,
+}
+
+// responseFormat returns the format requested by r, or an empty string for
+// the default response. The format query parameter takes precedence over
+// the Accept header, in which the first supported media type wins.
+func responseFormat(r *http.Request) (string, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		if _, ok := formatMediaTypes[f]; !ok {
+			return "", fmt.Errorf("unsupported format %q", f)
+		}
+		return f, nil
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+		for f, ft := range formatMediaTypes {
+			if mt == ft {
+				return f, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// formatEtag returns the etag of c served in format.
+func formatEtag(c cid.Cid, format string) string {
+	return "\"" + c.String() + "." + format + "\""
+}
+
+// setFormatHeaders sets the headers shared by the responses in a format,
+// returning false if the request was answered with 304 Not Modified. The
+// response is served as an attachment if filename is set.
+func (i *gatewayHandler) setFormatHeaders(w http.ResponseWriter, r *http.Request, urlPath string, c cid.Cid, format, filename string) bool {
+	etag := formatEtag(c, format)
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Set("Etag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	}
+
+	i.addUserHeaders(w)
+	w.Header().Set("X-IPFS-Path", urlPath)
+	w.Header().Set("Etag", etag)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if filename != "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+	if strings.HasPrefix(urlPath, ipfsPathPrefix) {
+		w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+	}
+	return true
+}
+
+// serveRawBlock serves the single block resolvedPath points to.
+func (i *gatewayHandler) serveRawBlock(w http.ResponseWriter, r *http.Request, urlPath string, resolvedPath ipath.Resolved) {
+	c := resolvedPath.Cid()
+	br, err := i.api.Block().Get(r.Context(), resolvedPath)
+	if err != nil {
+		webError(w, "ipfs block get "+c.String(), err, http.StatusNotFound)
+		return
+	}
+	data, err := ioutil.ReadAll(br)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+
+	if !i.setFormatHeaders(w, r, urlPath, c, rawFormat, c.String()+".bin") {
+		return
+	}
+	w.Header().Set("Content-Type", rawMediaType)
+
+	// blocks are immutable, ServeContent takes care of ranges
+	http.ServeContent(w, r, "", time.Unix(1, 0), bytes.NewReader(data))
+}
+
+// serveCar streams a CAR of the DAG resolvedPath points to. As the CAR is
+// written while the DAG is traversed, a failure midway can only be reported
+// by aborting the response.
+func (i *gatewayHandler) serveCar(w http.ResponseWriter, r *http.Request, urlPath string, resolvedPath ipath.Resolved) {
+	c := resolvedPath.Cid()
+	if !i.setFormatHeaders(w, r, urlPath, c, carFormat, c.String()+".car") {
+		return
+	}
+	w.Header().Set("Content-Type", carMediaType+"; version=1")
+	w.Header().Set("Accept-Ranges", "none")
+
+	if r.Method == "HEAD" {
+		return
+	}
+
+	ng := &denylist.NodeGetter{NodeGetter: i.node.DAG, Denylist: i.node.Denylist}
+	if err := car.WriteCar(r.Context(), ng, []cid.Cid{c}, w); err != nil {
+		log.Warningf("aborted CAR response for %s: %s", urlPath, err)
+		// let the client tell the CAR is truncated
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -5,16 +5,21 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	gopath "path"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ipfs/go-ipfs/core"
+	apitoken "github.com/ipfs/go-ipfs/core/apitoken"
 	"github.com/ipfs/go-ipfs/dagutils"
+	"github.com/ipfs/go-ipfs/denylist"
 	"github.com/ipfs/go-ipfs/namesys/resolve"
+	"github.com/ipfs/go-ipfs/quota"
 
 	"github.com/dustin/go-humanize"
 	"github.com/ipfs/go-cid"
@@ -29,7 +34,6 @@ import (
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	ipath "github.com/ipfs/interface-go-ipfs-core/path"
 	routing "github.com/libp2p/go-libp2p-core/routing"
-	"github.com/multiformats/go-multibase"
 )
 
 const (
@@ -43,6 +47,12 @@ type gatewayHandler struct {
 	node   *core.IpfsNode
 	config GatewayConfig
 	api    coreiface.CoreAPI
+
+	// auth checks the API tokens of write requests
+	auth *apitoken.Authorizer
+
+	// ipnsLk serializes the updates of IPNS names
+	ipnsLk sync.Mutex
 }
 
 func newGatewayHandler(n *core.IpfsNode, c GatewayConfig, api coreiface.CoreAPI) *gatewayHandler {
@@ -71,6 +81,11 @@ func (i *gatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	defer func() {
 		if r := recover(); r != nil {
+			if r == http.ErrAbortHandler {
+				// deliberately aborted response, let net/http close the
+				// connection
+				panic(r)
+			}
 			log.Error("A panic occurred in the gateway handler!")
 			log.Error(r)
 			debug.PrintStack()
@@ -78,6 +93,20 @@ func (i *gatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	if i.config.Writable {
+		switch r.Method {
+		case "POST", "PUT", "DELETE":
+			if strings.HasPrefix(r.URL.Path, ipnsPathPrefix) {
+				i.ipnsWriteHandler(w, r)
+				return
+			}
+			// /ipfs/ writes stay anonymous until a token is configured,
+			// see Gateway.Writable in docs/config.md
+			token, ok := i.writeToken(w, r, false)
+			if !ok || !allowWrite(w, token, gatewayIpfsScope) {
+				return
+			}
+		}
+
 		switch r.Method {
 		case "POST":
 			i.postHandler(w, r)
@@ -140,15 +169,16 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		}
 	}
 
-	// IPNSHostnameOption might have constructed an IPNS path using the Host header.
+	// IPNSHostnameOption and SubdomainGatewayOption might have constructed an
+	// IPFS or IPNS path using the Host header.
 	// In this case, we need the original path for constructing redirects
 	// and links that match the requested URL.
 	// For example, http://example.net would become /ipns/example.net, and
 	// the redirects and links would end up as http://example.net/ipns/example.net
 	originalUrlPath := prefix + urlPath
 	ipnsHostname := false
-	if hdr := r.Header.Get("X-Ipns-Original-Path"); len(hdr) > 0 {
-		originalUrlPath = prefix + hdr
+	if p, ok := originalPath(r); ok {
+		originalUrlPath = prefix + p
 		ipnsHostname = true
 	}
 
@@ -158,16 +188,69 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// The response depends on the Accept header, see responseFormat
+	w.Header().Set("Vary", "Accept")
+	format, err := responseFormat(r)
+	if err != nil {
+		webError(w, "invalid format", err, http.StatusBadRequest)
+		return
+	}
+
+	// The resolution may be bounded by GatewayTimeoutsOption
+	rctx, cancel := resolveContext(r.Context())
+	defer cancel()
+
+	// Refuse denied paths before fetching anything. Failing to resolve the
+	// name is reported by ResolvePath below.
+	if err := i.node.Denylist.CheckPath(rctx, i.node.Namesys, i.node.DAG, path.Path(urlPath)); err == denylist.ErrDenied {
+		webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusGone)
+		return
+	}
+
 	// Resolve path to the final DAG node for the ETag
-	resolvedPath, err := i.api.ResolvePath(r.Context(), parsedPath)
-	if err == coreiface.ErrOffline && !i.node.IsOnline {
+	resolvedPath, err := i.api.ResolvePath(rctx, parsedPath)
+	if err != nil && rctx.Err() == context.DeadlineExceeded && r.Context().Err() == nil {
+		d, _ := r.Context().Value(resolveTimeoutKey{}).(time.Duration)
+		writeTimeoutError(w, r, resolvePhase, d)
+		return
+	} else if err == coreiface.ErrOffline && !i.node.IsOnline {
 		webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusServiceUnavailable)
 		return
 	} else if err != nil {
+		// sites served on their own hostname, or under /ipns/, may handle
+		// missing paths themselves, see serveMissing
+		isSite := ipnsHostname || strings.HasPrefix(urlPath, ipnsPathPrefix)
+		if _, ok := err.(resolver.ErrNoLink); ok && isSite && i.serveMissing(w, r, urlPath, prefix, ipnsHostname) {
+			return
+		}
 		webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusNotFound)
 		return
 	}
 
+	if err := i.node.Denylist.Check(resolvedPath.Cid()); err != nil {
+		webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusGone)
+		return
+	}
+
+	switch format {
+	case rawFormat:
+		i.serveRawBlock(w, r, urlPath, resolvedPath)
+		return
+	case carFormat:
+		i.serveCar(w, r, urlPath, resolvedPath)
+		return
+	case dagJSONFormat:
+		i.serveIPLD(w, r, urlPath, prefix, resolvedPath, format)
+		return
+	}
+
+	// Nodes which aren't UnixFS are rendered rather than failing to be read
+	// as files
+	if !isUnixFSCodec(resolvedPath.Cid()) {
+		i.serveIPLD(w, r, urlPath, prefix, resolvedPath, format)
+		return
+	}
+
 	dr, err := i.api.Unixfs().Get(r.Context(), resolvedPath)
 	if err != nil {
 		webError(w, "ipfs cat "+escapedURLPath, err, http.StatusNotFound)
@@ -178,9 +261,26 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 
 	defer dr.Close()
 
-	// Check etag send back to us
+	switch format {
+	case tarFormat, zipFormat:
+		i.serveArchive(w, r, urlPath, resolvedPath, dr, format)
+		return
+	case jsonFormat:
+		// directories are listed, files are described by their node
+		if _, ok := dr.(files.Directory); ok {
+			i.serveJSONListing(w, r, urlPath, originalUrlPath, resolvedPath)
+		} else {
+			i.serveIPLD(w, r, urlPath, prefix, resolvedPath, format)
+		}
+		return
+	}
+
+	// Check etag send back to us. The etag is the CID of the resolved path
+	// for both namespaces, so that it stays the same as long as an IPNS name
+	// points to the same content.
 	etag := "\"" + resolvedPath.Cid().String() + "\""
-	if r.Header.Get("If-None-Match") == etag || r.Header.Get("If-None-Match") == "W/"+etag {
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Set("Etag", etag)
 		w.WriteHeader(http.StatusNotModified)
 		return
 	}
@@ -189,44 +289,14 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 	w.Header().Set("X-IPFS-Path", urlPath)
 	w.Header().Set("Etag", etag)
 
-	// Suborigin header, sandboxes apps from each other in the browser (even
-	// though they are served from the same gateway domain).
-	//
-	// Omitted if the path was treated by IPNSHostnameOption(), for example
-	// a request for http://example.net/ would be changed to /ipns/example.net/,
-	// which would turn into an incorrect Suborigin header.
-	// In this case the correct thing to do is omit the header because it is already
-	// handled correctly without a Suborigin.
-	//
-	// NOTE: This is not yet widely supported by browsers.
-	if !ipnsHostname {
-		// e.g.: 1="ipfs", 2="QmYuNaKwY...", ...
-		pathComponents := strings.SplitN(urlPath, "/", 4)
-
-		var suboriginRaw []byte
-		cidDecoded, err := cid.Decode(pathComponents[2])
-		if err != nil {
-			// component 2 doesn't decode with cid, so it must be a hostname
-			suboriginRaw = []byte(strings.ToLower(pathComponents[2]))
-		} else {
-			suboriginRaw = cidDecoded.Bytes()
-		}
-
-		base32Encoded, err := multibase.Encode(multibase.Base32, suboriginRaw)
-		if err != nil {
-			internalWebError(w, err)
-			return
-		}
-
-		suborigin := pathComponents[1] + "000" + strings.ToLower(base32Encoded)
-		w.Header().Set("Suborigin", suborigin)
-	}
-
 	// set these headers _after_ the error, for we may just not have it
 	// and dont want the client to cache a 500 response...
 	// and only if it's /ipfs!
 	// TODO: break this out when we split /ipfs /ipns routes.
-	modtime := time.Now()
+	//
+	// The content of an IPNS name has no meaningful modification time, so
+	// none is sent and conditional requests rely on the etag.
+	var modtime time.Time
 
 	if f, ok := dr.(files.File); ok {
 		if strings.HasPrefix(urlPath, ipfsPathPrefix) {
@@ -247,8 +317,7 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		i.serveFile(w, r, name, modtime, f)
 		return
 	}
-	dir, ok := dr.(files.Directory)
-	if !ok {
+	if _, ok := dr.(files.Directory); !ok {
 		internalWebError(w, fmt.Errorf("unsupported file type"))
 		return
 	}
@@ -271,7 +340,7 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		}
 
 		// write to request
-		http.ServeContent(w, r, "index.html", modtime, f)
+		i.serveFile(w, r, "index.html", modtime, f)
 		return
 	case resolver.ErrNoLink:
 		// no index.html; noop
@@ -280,29 +349,56 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// directory listings are generated, byte ranges of them aren't served
+	w.Header().Set("Accept-Ranges", "none")
+
+	offset, limit, err := listingPage(r)
+	if err != nil {
+		webError(w, "ipfs ls "+escapedURLPath, err, http.StatusBadRequest)
+		return
+	}
+
 	if r.Method == "HEAD" {
 		return
 	}
 
-	// storage for directory listing
-	var dirListing []directoryItem
-	dirit := dir.Entries()
-	for dirit.Next() {
-		// See comment above where originalUrlPath is declared.
-		s, err := dirit.Node().Size()
-		if err != nil {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	entries, enumErr, err := i.dirEntries(ctx, resolvedPath, offset, limit)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+	if limit > 0 {
+		// a page is small enough to be read before answering, which lets
+		// errors be reported and the next page be linked
+		page, more := readPage(entries, limit)
+		if err := enumErr(); err != nil {
 			internalWebError(w, err)
 			return
 		}
-
-		di := directoryItem{humanize.Bytes(uint64(s)), dirit.Name(), gopath.Join(originalUrlPath, dirit.Name())}
-		dirListing = append(dirListing, di)
-	}
-	if dirit.Err() != nil {
-		internalWebError(w, dirit.Err())
-		return
+		if more {
+			setNextPageLink(w, r, originalUrlPath, offset, limit)
+		}
+		entries = linkChan(page)
 	}
 
+	// the listing is rendered as the entries are enumerated, so that huge
+	// sharded directories don't have to be loaded first
+	dirListing := make(chan directoryItem)
+	go func() {
+		defer close(dirListing)
+		for l := range entries {
+			// See comment above where originalUrlPath is declared.
+			di := directoryItem{humanize.Bytes(l.Size), l.Name, gopath.Join(originalUrlPath, l.Name)}
+			select {
+			case dirListing <- di:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
 	// construct the correct back link
 	// https://github.com/ipfs/go-ipfs/issues/1365
 	var backLink string = prefix + urlPath
@@ -348,9 +444,12 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		Hash:     hash,
 	}
 	err = listingTemplate.Execute(w, tplData)
+	if err == nil {
+		err = enumErr()
+	}
 	if err != nil {
-		internalWebError(w, err)
-		return
+		log.Warningf("aborted listing of %s: %s", urlPath, err)
+		panic(http.ErrAbortHandler)
 	}
 }
 
@@ -372,6 +471,10 @@ func (s *sizeSeeker) Seek(offset int64, whence int) (int64, error) {
 	return s.sizeReadSeeker.Seek(offset, whence)
 }
 
+// serveFile serves content with http.ServeContent, which handles Range,
+// If-Range and conditional requests using the Etag header set by the caller.
+// Multiple ranges are served as multipart/byteranges, each of them read by
+// seeking in the file rather than reading it from the start.
 func (i *gatewayHandler) serveFile(w http.ResponseWriter, req *http.Request, name string, modtime time.Time, content io.ReadSeeker) {
 	if sp, ok := content.(sizeReadSeeker); ok {
 		content = &sizeSeeker{
@@ -379,10 +482,24 @@ func (i *gatewayHandler) serveFile(w http.ResponseWriter, req *http.Request, nam
 		}
 	}
 
-	http.ServeContent(w, req, name, modtime, content)
+	if n := countRanges(req.Header.Get("Range")); n > maxRanges {
+		// too many ranges to be worth seeking for, serve the whole file
+		req.Header.Del("Range")
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	sw := &statusResponseWriter{ResponseWriter: w}
+	http.ServeContent(sw, req, name, modtime, content)
+	observeRange(req, sw.status)
 }
 
 func (i *gatewayHandler) postHandler(w http.ResponseWriter, r *http.Request) {
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "multipart/form-data" {
+		i.postUploadHandler(w, r)
+		return
+	}
+
 	p, err := i.api.Unixfs().Add(r.Context(), files.NewReaderFile(r.Body))
 	if err != nil {
 		internalWebError(w, err)
@@ -598,6 +715,8 @@ func webError(w http.ResponseWriter, message string, err error, defaultCode int)
 		webErrorWithCode(w, message, err, http.StatusNotFound)
 	} else if err == context.DeadlineExceeded {
 		webErrorWithCode(w, message, err, http.StatusRequestTimeout)
+	} else if quota.IsExceeded(err) {
+		webErrorWithCode(w, message, err, http.StatusInsufficientStorage)
 	} else {
 		webErrorWithCode(w, message, err, defaultCode)
 	}
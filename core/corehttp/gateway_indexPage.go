@@ -11,7 +11,7 @@ import (
 
 // structs for directory listing
 type listingTemplateData struct {
-	Listing  []directoryItem
+	Listing  <-chan directoryItem
 	Path     string
 	BackLink string
 	Hash     string
@@ -0,0 +1,172 @@
+package corehttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// isUnixFSCodec returns whether the gateway serves nodes with the codec of c
+// as UnixFS files and directories.
+func isUnixFSCodec(c cid.Cid) bool {
+	switch c.Type() {
+	case cid.DagProtobuf, cid.Raw:
+		return true
+	}
+	return false
+}
+
+// serveIPLD serves the IPLD node, or the value within it, resolvedPath points
+// to as JSON, or as an HTML page showing the JSON to browsers when no format
+// was requested.
+func (i *gatewayHandler) serveIPLD(w http.ResponseWriter, r *http.Request, urlPath, prefix string, resolvedPath ipath.Resolved, format string) {
+	c := resolvedPath.Cid()
+	nd, err := i.api.Dag().Get(r.Context(), c)
+	if err != nil {
+		webError(w, "ipfs dag get "+c.String(), err, http.StatusNotFound)
+		return
+	}
+
+	var value interface{} = nd
+	if rem := splitSegments(resolvedPath.Remainder()); len(rem) > 0 {
+		v, rest, err := nd.Resolve(rem)
+		if err == nil && len(rest) > 0 {
+			err = fmt.Errorf("no value at %s under %s", resolvedPath.Remainder(), c)
+		}
+		if err != nil {
+			webError(w, "ipfs dag get "+urlPath, err, http.StatusNotFound)
+			return
+		}
+		value = v
+	}
+
+	data, err := json.MarshalIndent(jsonValue(value), "", "  ")
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+
+	asHTML := format == "" && acceptsHTML(r)
+	etagFormat := format
+	switch {
+	case asHTML:
+		etagFormat = "html"
+	case format == "":
+		format = jsonFormat
+		etagFormat = format
+	}
+	if !i.setFormatHeaders(w, r, urlPath, c, etagFormat, "") {
+		return
+	}
+
+	if !asHTML {
+		w.Header().Set("Content-Type", formatMediaTypes[format])
+		if r.Method != "HEAD" {
+			w.Write(data)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == "HEAD" {
+		return
+	}
+	err = ipldTemplate.Execute(w, ipldTemplateData{
+		Path:  urlPath,
+		Cid:   c.String(),
+		Codec: cid.CodecToStr[c.Type()],
+		JSON:  linkCids(data, prefix),
+	})
+	if err != nil {
+		internalWebError(w, err)
+	}
+}
+
+// jsonValue returns v in a form encoding/json marshals as DAG-JSON, links
+// being encoded as {"/": "<cid>"}.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *ipld.Link:
+		return v.Cid
+	case json.Marshaler:
+		return v
+	case ipld.Node:
+		// nodes which can't encode themselves, list their top level fields
+		fields := v.Tree("", 1)
+		if len(fields) == 0 {
+			return map[string]interface{}{"/": map[string][]byte{"bytes": v.RawData()}}
+		}
+		m := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			fv, _, err := v.Resolve([]string{f})
+			if err != nil {
+				continue
+			}
+			m[f] = jsonValue(fv)
+		}
+		return m
+	}
+	return v
+}
+
+// acceptsHTML returns whether the client asked for HTML, as browsers do.
+func acceptsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// escapedLinkRe matches the links of HTML escaped DAG-JSON.
+var escapedLinkRe = regexp.MustCompile(`(&#34;/&#34;: &#34;)([A-Za-z0-9]+)(&#34;)`)
+
+// linkCids HTML escapes the JSON data and turns its links into links to the
+// gateway.
+func linkCids(data []byte, prefix string) template.HTML {
+	escaped := html.EscapeString(string(data))
+	var b bytes.Buffer
+	last := 0
+	for _, m := range escapedLinkRe.FindAllStringSubmatchIndex(escaped, -1) {
+		b.WriteString(escaped[last:m[3]])
+		c := escaped[m[4]:m[5]]
+		if _, err := cid.Decode(c); err == nil {
+			fmt.Fprintf(&b, `<a href="%s">%s</a>`, template.HTMLEscapeString(prefix+ipfsPathPrefix+c), c)
+		} else {
+			b.WriteString(c)
+		}
+		last = m[5]
+	}
+	b.WriteString(escaped[last:])
+	return template.HTML(b.String())
+}
+
+type ipldTemplateData struct {
+	Path  string
+	Cid   string
+	Codec string
+	JSON  template.HTML
+}
+
+var ipldTemplate = template.Must(template.New("ipld").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Path }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+pre { background: #f7f8fa; padding: 1em; overflow: auto; }
+</style>
+</head>
+<body>
+<h1>{{ .Path }}</h1>
+<p>{{ .Codec }} node <code>{{ .Cid }}</code>, also available as <a href="?format=dag-json">DAG-JSON</a> and <a href="?format=raw">raw block</a>.</p>
+<pre>{{ .JSON }}</pre>
+</body>
+</html>
+`))
@@ -0,0 +1,250 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+	uio "github.com/ipfs/go-unixfs/io"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// listingPage returns the page of a directory listing requested with the
+// offset and limit query parameters. A limit of 0 lists every entry.
+func listingPage(r *http.Request) (offset, limit int, err error) {
+	q := r.URL.Query()
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %q", v)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return 0, 0, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	return offset, limit, nil
+}
+
+// errListingDone stops the enumeration of dirEntries once a page is sent.
+var errListingDone = errors.New("listing done")
+
+// dirEntries enumerates the links of the directory at resolvedPath as they
+// are loaded, which for sharded directories means one shard at a time. The
+// shards are walked in order rather than in parallel, so that the entries
+// come in the same order on every request and pages neither repeat nor skip
+// entries. The size of each entry is looked up as it is sent, see entrySize,
+// except in sharded directories, which can be too large for their children to
+// be fetched: their entries have the cumulative sizes recorded in the links.
+// The first offset entries are skipped, and if limit is set, at most limit+1
+// entries are sent so that the caller can tell whether there is a next page.
+//
+// The returned function reports the error which ended the enumeration early,
+// once the channel is closed.
+func (i *gatewayHandler) dirEntries(ctx context.Context, resolvedPath ipath.Resolved, offset, limit int) (<-chan *ipld.Link, func() error, error) {
+	nd, err := i.node.DAG.Get(ctx, resolvedPath.Cid())
+	if err != nil {
+		return nil, nil, err
+	}
+	dir, err := uio.NewDirectoryFromNode(i.node.DAG, nd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, sharded := dir.(*uio.HAMTDirectory)
+
+	out := make(chan *ipld.Link)
+	var enumErr error
+	go func() {
+		defer close(out)
+		n, sent := 0, 0
+		err := dir.ForEachLink(ctx, func(l *ipld.Link) error {
+			if i.node.Denylist.IsDenied(l.Cid) {
+				return nil
+			}
+			if n++; n <= offset {
+				return nil
+			}
+			if !sharded {
+				size, err := entrySize(ctx, i.node.DAG, l)
+				if err != nil {
+					return err
+				}
+				l = &ipld.Link{Name: l.Name, Cid: l.Cid, Size: size}
+			}
+			select {
+			case out <- l:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if sent++; limit > 0 && sent > limit {
+				return errListingDone
+			}
+			return nil
+		})
+		if err != errListingDone {
+			enumErr = err
+		}
+	}()
+	return out, func() error { return enumErr }, nil
+}
+
+// entrySize returns the size of the entry l as the files API reports it: the
+// size of the content of a file, or the cumulative size of a directory.
+func entrySize(ctx context.Context, dserv ipld.DAGService, l *ipld.Link) (uint64, error) {
+	nd, err := l.GetNode(ctx, dserv)
+	if err != nil {
+		return 0, err
+	}
+	pbnd, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		if _, raw := nd.(*dag.RawNode); raw {
+			return uint64(len(nd.RawData())), nil
+		}
+		return nd.Size()
+	}
+	fsn, err := ft.FSNodeFromBytes(pbnd.Data())
+	if err != nil {
+		return 0, err
+	}
+	switch fsn.Type() {
+	case ft.TDirectory, ft.THAMTShard:
+		return pbnd.Size()
+	}
+	return fsn.FileSize(), nil
+}
+
+// readPage reads the page of entries sent by dirEntries with a limit,
+// returning whether there is a next page.
+func readPage(entries <-chan *ipld.Link, limit int) ([]*ipld.Link, bool) {
+	page := make([]*ipld.Link, 0, limit+1)
+	for l := range entries {
+		page = append(page, l)
+	}
+	if len(page) > limit {
+		return page[:limit], true
+	}
+	return page, false
+}
+
+// linkChan returns a closed channel holding links.
+func linkChan(links []*ipld.Link) <-chan *ipld.Link {
+	out := make(chan *ipld.Link, len(links))
+	for _, l := range links {
+		out <- l
+	}
+	close(out)
+	return out
+}
+
+// setNextPageLink points the Link header of the response to the next page of
+// the listing of the directory at originalUrlPath.
+func setNextPageLink(w http.ResponseWriter, r *http.Request, originalUrlPath string, offset, limit int) {
+	q := r.URL.Query()
+	q.Set("offset", strconv.Itoa(offset+limit))
+	q.Set("limit", strconv.Itoa(limit))
+	next := (&url.URL{Path: originalUrlPath, RawQuery: q.Encode()}).String()
+	w.Header().Set("Link", "<"+next+">; rel=\"next\"")
+}
+
+// jsonListingEntry is an entry of a JSON directory listing.
+type jsonListingEntry struct {
+	Name string
+	Cid  cid.Cid
+	Size uint64
+}
+
+// writeJSONListing streams a directory listing as a JSON object holding the
+// path and CID of the directory and its entries.
+func writeJSONListing(w io.Writer, urlPath string, c cid.Cid, entries <-chan *ipld.Link) error {
+	head, err := json.Marshal(struct {
+		Path string
+		Cid  cid.Cid
+	}{urlPath, c})
+	if err != nil {
+		return err
+	}
+	// {"Path":...,"Cid":...,"Entries":[...]}
+	if _, err := w.Write(head[:len(head)-1]); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, `,"Entries":[`); err != nil {
+		return err
+	}
+
+	sep := ""
+	for l := range entries {
+		data, err := json.Marshal(jsonListingEntry{l.Name, l.Cid, l.Size})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		sep = ","
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// serveJSONListing streams the listing of the directory resolvedPath points
+// to as JSON. Like HTML listings, it is paginated with the offset and limit
+// query parameters.
+func (i *gatewayHandler) serveJSONListing(w http.ResponseWriter, r *http.Request, urlPath, originalUrlPath string, resolvedPath ipath.Resolved) {
+	offset, limit, err := listingPage(r)
+	if err != nil {
+		webError(w, "ipfs ls "+urlPath, err, http.StatusBadRequest)
+		return
+	}
+
+	c := resolvedPath.Cid()
+	if !i.setFormatHeaders(w, r, urlPath, c, jsonFormat, "") {
+		return
+	}
+	w.Header().Set("Content-Type", jsonMediaType)
+	w.Header().Set("Accept-Ranges", "none")
+
+	if r.Method == "HEAD" {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	entries, enumErr, err := i.dirEntries(ctx, resolvedPath, offset, limit)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+	if limit > 0 {
+		page, more := readPage(entries, limit)
+		if err := enumErr(); err != nil {
+			internalWebError(w, err)
+			return
+		}
+		if more {
+			setNextPageLink(w, r, originalUrlPath, offset, limit)
+		}
+		entries = linkChan(page)
+	}
+
+	err = writeJSONListing(w, originalUrlPath, c, entries)
+	if err == nil {
+		err = enumErr()
+	}
+	if err != nil {
+		log.Warningf("aborted JSON listing of %s: %s", urlPath, err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,91 @@
+package corehttp
+
+import (
+	"net/http"
+	"strings"
+)
+
+// maxRanges is the maximum number of byte ranges served in a single
+// multipart/byteranges response. Requests for more ranges get the whole file.
+const maxRanges = 32
+
+// statusResponseWriter records the status code of a response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// etagMatch returns whether the If-None-Match header value hdr matches etag,
+// using the weak comparison of RFC 7232.
+func etagMatch(hdr string, etag string) bool {
+	hdr = strings.TrimSpace(hdr)
+	if hdr == "" {
+		return false
+	}
+	if hdr == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(hdr, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(t), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// countRanges returns the number of byte ranges requested by the Range header
+// value hdr, or 0 if it doesn't request byte ranges.
+func countRanges(hdr string) int {
+	if !strings.HasPrefix(hdr, "bytes=") {
+		return 0
+	}
+	return strings.Count(hdr, ",") + 1
+}
+
+// observeRange updates the metrics on range requests once req was answered
+// with the given status.
+func observeRange(req *http.Request, status int) {
+	n := countRanges(req.Header.Get("Range"))
+	if n == 0 {
+		return
+	}
+
+	var result string
+	switch status {
+	case http.StatusPartialContent:
+		if n == 1 {
+			result = "single"
+		} else {
+			result = "multi"
+		}
+	case http.StatusOK:
+		// If-Range didn't match or the ranges were ignored
+		result = "full"
+	case http.StatusRequestedRangeNotSatisfiable:
+		result = "unsatisfiable"
+	default:
+		return
+	}
+
+	namespace := "ipfs"
+	if strings.HasPrefix(req.URL.Path, ipnsPathPrefix) {
+		namespace = "ipns"
+	}
+	rangeRequestsMetric.WithLabelValues(namespace, result).Inc()
+}
@@ -0,0 +1,261 @@
+package corehttp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	gopath "path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	files "github.com/ipfs/go-ipfs-files"
+	path "github.com/ipfs/go-path"
+	"github.com/ipfs/go-path/resolver"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// Files at the root of a site served on its own hostname which customize how
+// missing paths are handled.
+const (
+	redirectsFile = "_redirects"
+	notFoundFile  = "404.html"
+)
+
+// maxRedirectsSize bounds the size of the _redirects file read.
+const maxRedirectsSize = 64 << 10
+
+// redirectRule is a rule of a _redirects file, one per line:
+//
+//	/from/:placeholder/*  /to/:placeholder/:splat  [status]
+//
+// The status defaults to 301. With 200, the content at the target is served
+// instead, with other statuses it's served with that status.
+type redirectRule struct {
+	from   []string
+	to     string
+	status int
+}
+
+var placeholderRe = regexp.MustCompile(`:[A-Za-z0-9_]+`)
+
+func parseRedirects(r io.Reader) ([]redirectRule, error) {
+	var rules []redirectRule
+	s := bufio.NewScanner(io.LimitReader(r, maxRedirectsSize))
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: too many fields", redirectsFile, line)
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: missing target", redirectsFile, line)
+		}
+		if !strings.HasPrefix(fields[0], "/") {
+			return nil, fmt.Errorf("%s:%d: %q isn't an absolute path", redirectsFile, line, fields[0])
+		}
+
+		rule := redirectRule{
+			from:   splitSegments(fields[0]),
+			to:     fields[1],
+			status: http.StatusMovedPermanently,
+		}
+		for i, seg := range rule.from {
+			if seg == "*" && i != len(rule.from)-1 {
+				return nil, fmt.Errorf("%s:%d: a splat must end the path", redirectsFile, line)
+			}
+		}
+		if len(fields) == 3 {
+			status, err := strconv.Atoi(fields[2])
+			if err != nil || !validRedirectStatus(status) {
+				return nil, fmt.Errorf("%s:%d: unsupported status %q", redirectsFile, line, fields[2])
+			}
+			rule.status = status
+		}
+		if !isRedirect(rule.status) && !strings.HasPrefix(rule.to, "/") {
+			return nil, fmt.Errorf("%s:%d: only redirects may point to other sites", redirectsFile, line)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, s.Err()
+}
+
+func validRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusOK, http.StatusNotFound, http.StatusGone, http.StatusUnavailableForLegalReasons:
+		return true
+	}
+	return isRedirect(status)
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+func splitSegments(p string) []string {
+	var segs []string
+	for _, s := range strings.Split(p, "/") {
+		if s != "" {
+			segs = append(segs, s)
+		}
+	}
+	return segs
+}
+
+// match returns the target of the rule for the path segments, with its
+// placeholders replaced, if the rule matches them.
+func (rule *redirectRule) match(segs []string) (string, bool) {
+	values := make(map[string]string)
+	for i, from := range rule.from {
+		if from == "*" {
+			values[":splat"] = strings.Join(segs[i:], "/")
+			return rule.expand(values), true
+		}
+		if i >= len(segs) {
+			return "", false
+		}
+		if strings.HasPrefix(from, ":") {
+			values[from] = segs[i]
+		} else if from != segs[i] {
+			return "", false
+		}
+	}
+	if len(segs) != len(rule.from) {
+		return "", false
+	}
+	return rule.expand(values), true
+}
+
+func (rule *redirectRule) expand(values map[string]string) string {
+	return placeholderRe.ReplaceAllStringFunc(rule.to, func(p string) string {
+		if v, ok := values[p]; ok {
+			return v
+		}
+		return p
+	})
+}
+
+type rewrittenKey struct{}
+
+// serveMissing handles a request for a missing path of a site, served on its
+// own hostname if hosted is set or under its /ipns/ path otherwise, following
+// the rules of the _redirects file at the root of the site, or serving its
+// 404.html page. It returns false if neither applies.
+func (i *gatewayHandler) serveMissing(w http.ResponseWriter, r *http.Request, urlPath, prefix string, hosted bool) bool {
+	// urlPath is /ipfs/<cid>/<path> or /ipns/<name>/<path>
+	parts := strings.SplitN(strings.TrimPrefix(urlPath, "/"), "/", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	siteRoot := "/" + parts[0] + "/" + parts[1]
+	sitePath := "/"
+	if len(parts) == 3 {
+		sitePath += parts[2]
+	}
+	// the absolute targets of the rules are relative to the site root
+	base := prefix
+	if !hosted {
+		base += siteRoot
+	}
+
+	// a rewritten request doesn't go through the rules again, so that they
+	// can't loop
+	if r.Context().Value(rewrittenKey{}) == nil {
+		if rules := i.siteRedirects(r.Context(), siteRoot); rules != nil {
+			segs := splitSegments(sitePath)
+			for _, rule := range rules {
+				to, ok := rule.match(segs)
+				if !ok {
+					continue
+				}
+				switch {
+				case isRedirect(rule.status):
+					if strings.HasPrefix(to, "/") {
+						to = base + to
+					}
+					i.addUserHeaders(w)
+					http.Redirect(w, r, to, rule.status)
+					return true
+				case rule.status == http.StatusOK:
+					r2 := r.WithContext(context.WithValue(r.Context(), rewrittenKey{}, true))
+					u := *r.URL
+					u.Path = siteRoot + strings.SplitN(to, "?", 2)[0]
+					r2.URL = &u
+					i.getOrHeadHandler(w, r2)
+					return true
+				default:
+					if i.serveWithStatus(w, r, siteRoot+to, rule.status) {
+						return true
+					}
+				}
+			}
+		}
+	}
+
+	return i.serveWithStatus(w, r, siteRoot+"/"+notFoundFile, http.StatusNotFound)
+}
+
+// siteRedirects returns the rules of the _redirects file at the root of a
+// site, if any.
+func (i *gatewayHandler) siteRedirects(ctx context.Context, siteRoot string) []redirectRule {
+	nd, err := i.api.Unixfs().Get(ctx, ipath.New(siteRoot+"/"+redirectsFile))
+	if err != nil {
+		if _, ok := err.(resolver.ErrNoLink); !ok {
+			log.Debugf("no %s at %s: %s", redirectsFile, siteRoot, err)
+		}
+		return nil
+	}
+	defer nd.Close()
+
+	f, ok := nd.(files.File)
+	if !ok {
+		return nil
+	}
+	rules, err := parseRedirects(f)
+	if err != nil {
+		log.Warningf("ignoring invalid %s of %s: %s", redirectsFile, siteRoot, err)
+		return nil
+	}
+	return rules
+}
+
+// serveWithStatus serves the file at p with the given status, returning false
+// if there is no file at p.
+func (i *gatewayHandler) serveWithStatus(w http.ResponseWriter, r *http.Request, p string, status int) bool {
+	if err := i.node.Denylist.CheckPath(r.Context(), i.node.Namesys, i.node.DAG, path.Path(p)); err != nil {
+		return false
+	}
+
+	nd, err := i.api.Unixfs().Get(r.Context(), ipath.New(p))
+	if err != nil {
+		return false
+	}
+	defer nd.Close()
+
+	f, ok := nd.(files.File)
+	if !ok {
+		return false
+	}
+
+	ctype := mime.TypeByExtension(gopath.Ext(p))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+
+	i.addUserHeaders(w)
+	w.Header().Set("Content-Type", ctype)
+	w.WriteHeader(status)
+	if r.Method != "HEAD" {
+		io.Copy(w, f)
+	}
+	return true
+}
@@ -1,18 +1,34 @@
 package corehttp
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
 	version "github.com/ipfs/go-ipfs"
+	"github.com/ipfs/go-ipfs/car"
 	core "github.com/ipfs/go-ipfs/core"
+	apitoken "github.com/ipfs/go-ipfs/core/apitoken"
 	"github.com/ipfs/go-ipfs/core/coreapi"
+	"github.com/ipfs/go-ipfs/denylist"
+	keystore "github.com/ipfs/go-ipfs/keystore"
 	namesys "github.com/ipfs/go-ipfs/namesys"
 	repo "github.com/ipfs/go-ipfs/repo"
 
@@ -20,12 +36,18 @@ import (
 	syncds "github.com/ipfs/go-datastore/sync"
 	config "github.com/ipfs/go-ipfs-config"
 	files "github.com/ipfs/go-ipfs-files"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	dag "github.com/ipfs/go-merkledag"
 	path "github.com/ipfs/go-path"
+	ft "github.com/ipfs/go-unixfs"
+	uio "github.com/ipfs/go-unixfs/io"
 	iface "github.com/ipfs/interface-go-ipfs-core"
 	nsopts "github.com/ipfs/interface-go-ipfs-core/options/namesys"
 	ipath "github.com/ipfs/interface-go-ipfs-core/path"
 	ci "github.com/libp2p/go-libp2p-core/crypto"
+	peer "github.com/libp2p/go-libp2p-core/peer"
 	id "github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	mh "github.com/multiformats/go-multihash"
 )
 
 // `ipfs object new unixfs-dir`
@@ -68,11 +90,16 @@ func (m mockNamesys) ResolveAsync(ctx context.Context, name string, opts ...nsop
 }
 
 func (m mockNamesys) Publish(ctx context.Context, name ci.PrivKey, value path.Path) error {
-	return errors.New("not implemented for mockNamesys")
+	return m.PublishWithEOL(ctx, name, value, time.Time{})
 }
 
 func (m mockNamesys) PublishWithEOL(ctx context.Context, name ci.PrivKey, value path.Path, _ time.Time) error {
-	return errors.New("not implemented for mockNamesys")
+	id, err := peer.IDFromPrivateKey(name)
+	if err != nil {
+		return err
+	}
+	m["/ipns/"+id.Pretty()] = value
+	return nil
 }
 
 func (m mockNamesys) GetResolver(subs string) (namesys.Resolver, bool) {
@@ -120,6 +147,14 @@ func doWithoutRedirect(req *http.Request) (*http.Response, error) {
 }
 
 func newTestServerAndNode(t *testing.T, ns mockNamesys) (*httptest.Server, iface.CoreAPI, context.Context) {
+	return newTestServerWithOptions(t, ns,
+		IPNSHostnameOption(),
+		GatewayOption(false, "/ipfs", "/ipns"),
+		VersionOption(),
+	)
+}
+
+func newTestServerWithOptions(t *testing.T, ns mockNamesys, opts ...ServeOption) (*httptest.Server, iface.CoreAPI, context.Context) {
 	n, err := newNodeWithMockNamesys(ns)
 	if err != nil {
 		t.Fatal(err)
@@ -136,12 +171,7 @@ func newTestServerAndNode(t *testing.T, ns mockNamesys) (*httptest.Server, iface
 	dh := &delegatedHandler{}
 	ts := httptest.NewServer(dh)
 
-	dh.Handler, err = makeHandler(n,
-		ts.Listener,
-		IPNSHostnameOption(),
-		GatewayOption(false, "/ipfs", "/ipns"),
-		VersionOption(),
-	)
+	dh.Handler, err = makeHandler(n, ts.Listener, opts...)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -534,6 +564,79 @@ func TestIPNSHostnameBacklinks(t *testing.T) {
 	}
 }
 
+func TestSubdomainGateway(t *testing.T) {
+	ns := mockNamesys{}
+	ts, api, ctx := newTestServerWithOptions(t, ns,
+		SubdomainGatewayOption(map[string]*GatewaySpec{
+			"example.net": {
+				Paths:         []string{"/ipfs", "/ipns"},
+				UseSubdomains: true,
+			},
+		}),
+		IPNSHostnameOption(),
+		GatewayOption(false, "/ipfs", "/ipns"),
+	)
+	defer ts.Close()
+
+	k, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"file.txt": files.NewBytesFile([]byte("fnord")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.com"] = path.FromString(k.String())
+
+	v0 := k.Cid().String()
+	v1 := toCidV1Base32(k.Cid())
+
+	for i, test := range []struct {
+		host     string
+		path     string
+		status   int
+		location string
+		text     string
+	}{
+		// path requests are redirected to the subdomain, converting the cid
+		{"example.net", "/ipfs/" + v0 + "/file.txt?x=1", http.StatusMovedPermanently, "http://" + v1 + ".ipfs.example.net/file.txt?x=1", ""},
+		{"example.net:8080", "/ipns/example.com/file.txt", http.StatusMovedPermanently, "http://example.com.ipns.example.net:8080/file.txt", ""},
+		// subdomains serve content
+		{v1 + ".ipfs.example.net", "/file.txt", http.StatusOK, "", "fnord"},
+		{"example.com.ipns.example.net", "/file.txt", http.StatusOK, "", "fnord"},
+		// non-canonical cids are redirected, invalid ones rejected
+		{strings.ToUpper(v1) + ".ipfs.example.net", "/file.txt", http.StatusMovedPermanently, "http://" + v1 + ".ipfs.example.net/file.txt", ""},
+		{"not-a-cid.ipfs.example.net", "/", http.StatusBadRequest, "", ""},
+		// other hosts are not affected
+		{"localhost", "/ipfs/" + v0 + "/file.txt", http.StatusOK, "", "fnord"},
+	} {
+		req, err := http.NewRequest("GET", ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = test.host
+
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != test.status {
+			t.Errorf("(%d) got %d, expected %d: %s", i, res.StatusCode, test.status, body)
+			continue
+		}
+		if loc := res.Header.Get("Location"); loc != test.location {
+			t.Errorf("(%d) got location %q, expected %q", i, loc, test.location)
+		}
+		if test.text != "" && string(body) != test.text {
+			t.Errorf("(%d) got body %q, expected %q", i, body, test.text)
+		}
+	}
+}
+
 func TestCacheControlImmutable(t *testing.T) {
 	ts, _, _ := newTestServerAndNode(t, nil)
 	t.Logf("test server url: %s", ts.URL)
@@ -616,3 +719,1163 @@ func TestVersion(t *testing.T) {
 		t.Fatalf("response doesn't contain protocol version:\n%s", s)
 	}
 }
+
+func TestGatewayRange(t *testing.T) {
+	ns := mockNamesys{}
+	ts, api, ctx := newTestServerAndNode(t, ns)
+	defer ts.Close()
+
+	// large enough to span several blocks
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	k, err := api.Unixfs().Add(ctx, files.NewBytesFile(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.com"] = path.FromString(k.String())
+	etag := `"` + k.Cid().String() + `"`
+
+	get := func(p string, hdrs map[string]string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest("GET", ts.URL+p, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for h, v := range hdrs {
+			req.Header.Set(h, v)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for _, p := range []string{k.String(), "/ipns/example.com"} {
+		res := get(p, map[string]string{"Range": "bytes=300000-300009"})
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusPartialContent {
+			t.Fatalf("%s: expected status 206, got %d", p, res.StatusCode)
+		}
+		if !bytes.Equal(body, data[300000:300010]) {
+			t.Fatalf("%s: unexpected range content", p)
+		}
+		if res.Header.Get("Etag") != etag {
+			t.Fatalf("%s: expected etag %s, got %s", p, etag, res.Header.Get("Etag"))
+		}
+		if res.Header.Get("Accept-Ranges") != "bytes" {
+			t.Fatalf("%s: expected Accept-Ranges: bytes, got %q", p, res.Header.Get("Accept-Ranges"))
+		}
+
+		// a matching If-Range resumes the download
+		res = get(p, map[string]string{"Range": "bytes=1048570-", "If-Range": etag})
+		body, _ = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusPartialContent || !bytes.Equal(body, data[1048570:]) {
+			t.Fatalf("%s: expected the end of the file, got status %d", p, res.StatusCode)
+		}
+
+		// a stale one returns the whole file
+		res = get(p, map[string]string{"Range": "bytes=0-9", "If-Range": `"stale"`})
+		body, _ = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK || !bytes.Equal(body, data) {
+			t.Fatalf("%s: expected the whole file, got status %d", p, res.StatusCode)
+		}
+
+		res = get(p, map[string]string{"If-None-Match": `"other", W/` + etag})
+		res.Body.Close()
+		if res.StatusCode != http.StatusNotModified {
+			t.Fatalf("%s: expected status 304, got %d", p, res.StatusCode)
+		}
+	}
+
+	res := get(k.String(), map[string]string{"Range": "bytes=0-4,500000-500004,1048000-1048004"})
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent {
+		t.Fatalf("expected status 206, got %d", res.StatusCode)
+	}
+	mt, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt != "multipart/byteranges" {
+		t.Fatalf("expected a multipart/byteranges response, got %s", mt)
+	}
+	mr := multipart.NewReader(res.Body, params["boundary"])
+	for _, off := range []int{0, 500000, 1048000} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, data[off:off+5]) {
+			t.Fatalf("unexpected content for the range at %d", off)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Fatalf("expected 3 parts, got %v", err)
+	}
+}
+
+func TestGatewayDenylist(t *testing.T) {
+	ns := mockNamesys{}
+	n, err := newNodeWithMockNamesys(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	defer ts.Close()
+	dh.Handler, err = makeHandler(n, ts.Listener, GatewayOption(false, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := n.Context()
+
+	root, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"a": files.NewBytesFile([]byte("a")),
+		"b": files.NewBytesFile([]byte("b")),
+		"c": files.NewBytesFile([]byte("c")),
+		"d": files.NewBytesFile([]byte("d")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.com"] = path.FromString(root.String())
+
+	c, err := api.ResolvePath(ctx, ipath.Join(root, "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.Denylist.Add(
+		denylist.Entry{Cid: root.Cid(), Path: "a"},
+		denylist.HashEntry(root.Cid(), "b"),
+		denylist.Entry{Cid: c.Cid()},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{root.String() + "/a", http.StatusGone},
+		{root.String() + "/b", http.StatusGone},
+		{root.String() + "/c", http.StatusGone},
+		{root.String() + "/d", http.StatusOK},
+		{"/ipns/example.com/a", http.StatusGone},
+		{"/ipns/example.com/d", http.StatusOK},
+	} {
+		resp, err := http.Get(ts.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: got %d, expected %d", test.path, resp.StatusCode, test.status)
+		}
+	}
+}
+
+func TestGatewayFormats(t *testing.T) {
+	ns := mockNamesys{}
+	ts, api, ctx := newTestServerAndNode(t, ns)
+	defer ts.Close()
+
+	// large enough to span several blocks
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	k, err := api.Unixfs().Add(ctx, files.NewBytesFile(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	br, err := api.Block().Get(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootBlock, err := ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(p string, hdrs map[string]string) (*http.Response, []byte) {
+		t.Helper()
+		req, err := http.NewRequest("GET", ts.URL+p, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for h, v := range hdrs {
+			req.Header.Set(h, v)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, body
+	}
+
+	for _, raw := range []struct {
+		path string
+		hdrs map[string]string
+	}{
+		{k.String() + "?format=raw", nil},
+		{k.String(), map[string]string{"Accept": "application/vnd.ipld.raw"}},
+		{k.String(), map[string]string{"Accept": "text/html;q=0.9, application/vnd.ipld.raw"}},
+	} {
+		res, body := get(raw.path, raw.hdrs)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d", raw.path, res.StatusCode)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/vnd.ipld.raw" {
+			t.Errorf("%s: unexpected content type %s", raw.path, ct)
+		}
+		if !bytes.Equal(body, rootBlock) {
+			t.Errorf("%s: expected the root block", raw.path)
+		}
+	}
+
+	res, body := get(k.String()+"?format=car", nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", res.StatusCode)
+	}
+	etag := res.Header.Get("Etag")
+	if etag != `"`+k.Cid().String()+`.car"` {
+		t.Errorf("unexpected etag %s", etag)
+	}
+	cr, err := car.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cr.Header.Roots) != 1 || !cr.Header.Roots[0].Equals(k.Cid()) {
+		t.Fatalf("unexpected roots %v", cr.Header.Roots)
+	}
+	nblocks := 0
+	for {
+		_, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		nblocks++
+	}
+	if nblocks < 2 {
+		t.Errorf("expected the CAR to hold the whole DAG, got %d blocks", nblocks)
+	}
+
+	res, _ = get(k.String(), map[string]string{"Accept": "application/vnd.ipld.car", "If-None-Match": etag})
+	if res.StatusCode != http.StatusNotModified {
+		t.Errorf("expected %d, got %d", http.StatusNotModified, res.StatusCode)
+	}
+
+	res, _ = get(k.String()+"?format=nope", nil)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected %d for an unknown format, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+
+	// browsers get the file
+	res, body = get(k.String(), map[string]string{"Accept": "text/html,*/*;q=0.8"})
+	if res.StatusCode != http.StatusOK || !bytes.Equal(body, data) {
+		t.Errorf("expected the file, got status %d", res.StatusCode)
+	}
+}
+
+func TestGatewayArchives(t *testing.T) {
+	ns := mockNamesys{}
+	ts, api, ctx := newTestServerAndNode(t, ns)
+	defer ts.Close()
+
+	root, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"a.txt": files.NewBytesFile([]byte("a")),
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"b.txt": files.NewBytesFile([]byte("b")),
+		}),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cidStr := root.Cid().String()
+	expected := map[string]string{
+		cidStr + "/a.txt":     "a",
+		cidStr + "/sub/b.txt": "b",
+	}
+
+	get := func(p string) (*http.Response, []byte) {
+		t.Helper()
+		res, err := http.Get(ts.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d: %s", p, res.StatusCode, body)
+		}
+		return res, body
+	}
+
+	res, body := get(root.String() + "?format=tar")
+	if ct := res.Header.Get("Content-Type"); ct != "application/x-tar" {
+		t.Errorf("unexpected content type %s", ct)
+	}
+	if cd := res.Header.Get("Content-Disposition"); cd != "" {
+		t.Errorf("unexpected content disposition %s", cd)
+	}
+	found := make(map[string]string)
+	tr := tar.NewReader(bytes.NewReader(body))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found[hdr.Name] = string(data)
+		}
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("unexpected tar content %v", found)
+	}
+
+	res, body = get(root.String() + "?format=zip&download=true")
+	if cd := res.Header.Get("Content-Disposition"); cd != `attachment; filename="`+cidStr+`.zip"` {
+		t.Errorf("unexpected content disposition %s", cd)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found = make(map[string]string)
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		found[f.Name] = string(data)
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("unexpected zip content %v", found)
+	}
+
+	// subdirectories are archived under their name
+	_, body = get(root.String() + "/sub?format=zip")
+	zr, err = zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 2 || zr.File[1].Name != "sub/b.txt" {
+		t.Errorf("unexpected zip entries %v", zr.File)
+	}
+
+	// entries which would be extracted outside of the archive directory
+	// abort the archive
+	child := dag.NodeWithData(ft.FilePBData([]byte("x"), 1))
+	if err := api.Dag().Add(ctx, child); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"..", ".", "../../etc/passwd"} {
+		dir := ft.EmptyDirNode()
+		if err := dir.AddNodeLink(name, child); err != nil {
+			t.Fatal(err)
+		}
+		if err := api.Dag().Add(ctx, dir); err != nil {
+			t.Fatal(err)
+		}
+		for _, format := range []string{"tar", "zip"} {
+			res, err := http.Get(ts.URL + "/ipfs/" + dir.Cid().String() + "?format=" + format)
+			if err != nil {
+				continue
+			}
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err == nil {
+				t.Errorf("%s archive with an entry named %q: expected an aborted response, got %d %q", format, name, res.StatusCode, body)
+			}
+		}
+	}
+}
+
+func TestGatewayRedirects(t *testing.T) {
+	ns := mockNamesys{}
+	ts, api, ctx := newTestServerAndNode(t, ns)
+	defer ts.Close()
+
+	redirects := `
+# comment
+/old/:name      /new/:name
+/moved          https://example.org/  302
+/app/*          /app/index.html       200
+/gone/*         /gone.html            410
+`
+	site, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"_redirects": files.NewBytesFile([]byte(redirects)),
+		"404.html":   files.NewBytesFile([]byte("custom 404")),
+		"gone.html":  files.NewBytesFile([]byte("gone")),
+		"app": files.NewMapDirectory(map[string]files.Node{
+			"index.html": files.NewBytesFile([]byte("app")),
+		}),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.net"] = path.FromString(site.String())
+
+	for _, test := range []struct {
+		path     string
+		status   int
+		location string
+		body     string
+	}{
+		{"/old/page", http.StatusMovedPermanently, "/new/page", ""},
+		{"/moved", http.StatusFound, "https://example.org/", ""},
+		{"/app/some/route", http.StatusOK, "", "app"},
+		{"/gone/thing", http.StatusGone, "", "gone"},
+		{"/nothing/here", http.StatusNotFound, "", "custom 404"},
+		{"/gone.html", http.StatusOK, "", "gone"},
+	} {
+		req, err := http.NewRequest("GET", ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = "example.net"
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != test.status {
+			t.Errorf("%s: got %d, expected %d", test.path, res.StatusCode, test.status)
+			continue
+		}
+		if loc := res.Header.Get("Location"); loc != test.location {
+			t.Errorf("%s: unexpected location %q", test.path, loc)
+		}
+		if test.body != "" && string(body) != test.body {
+			t.Errorf("%s: unexpected body %q", test.path, body)
+		}
+	}
+
+	// nor on the path gateway under /ipns/
+	for _, test := range []struct {
+		path     string
+		status   int
+		location string
+		body     string
+	}{
+		{"/ipns/example.net/old/page", http.StatusMovedPermanently, "/ipns/example.net/new/page", ""},
+		{"/ipns/example.net/app/some/route", http.StatusOK, "", "app"},
+		{"/ipns/example.net/nothing/here", http.StatusNotFound, "", "custom 404"},
+	} {
+		req, err := http.NewRequest("GET", ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != test.status {
+			t.Errorf("%s: got %d, expected %d", test.path, res.StatusCode, test.status)
+			continue
+		}
+		if loc := res.Header.Get("Location"); loc != test.location {
+			t.Errorf("%s: unexpected location %q", test.path, loc)
+		}
+		if test.body != "" && string(body) != test.body {
+			t.Errorf("%s: unexpected body %q", test.path, body)
+		}
+	}
+
+	// immutable paths aren't sites
+	res, err := http.Get(ts.URL + site.String() + "/nothing/here")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusNotFound || string(body) == "custom 404" {
+		t.Errorf("unexpected response %d %q", res.StatusCode, body)
+	}
+
+	// nor when the client pretends the host was mapped to a path
+	req, err := http.NewRequest("GET", ts.URL+site.String()+"/nothing/here", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Ipns-Original-Path", "/nothing/here")
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	body, err = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusNotFound || string(body) == "custom 404" {
+		t.Errorf("unexpected response with a forged header %d %q", res.StatusCode, body)
+	}
+}
+
+func TestParseRedirects(t *testing.T) {
+	for _, bad := range []string{
+		"/a",
+		"a /b",
+		"/a /b 999",
+		"/a/*/b /c",
+		"/a https://example.org 200",
+		"/a /b 301 extra",
+	} {
+		if _, err := parseRedirects(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected %q to be invalid", bad)
+		}
+	}
+
+	rules, err := parseRedirects(strings.NewReader("/blog/:year/:slug /posts/:year-:slug\n/docs/* /v2/:splat 302\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		path string
+		to   string
+	}{
+		{"/blog/2019/hello", "/posts/2019-hello"},
+		{"/blog/2019/hello/", "/posts/2019-hello"},
+		{"/blog/2019", ""},
+		{"/docs/a/b", "/v2/a/b"},
+		{"/docs", "/v2/"},
+	} {
+		to := ""
+		for _, rule := range rules {
+			if m, ok := rule.match(splitSegments(test.path)); ok {
+				to = m
+				break
+			}
+		}
+		if to != test.to {
+			t.Errorf("%s: got %q, expected %q", test.path, to, test.to)
+		}
+	}
+}
+
+func TestGatewayIPLD(t *testing.T) {
+	ns := mockNamesys{}
+	ts, api, ctx := newTestServerAndNode(t, ns)
+	defer ts.Close()
+
+	file, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("fnord")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := cbor.WrapObject(map[string]interface{}{
+		"name":   "thing",
+		"nested": map[string]interface{}{"n": 1},
+		"file":   file.Cid(),
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Dag().Add(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+	base := "/ipfs/" + nd.Cid().String()
+
+	get := func(p, accept string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest("GET", ts.URL+p, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(body)
+	}
+
+	res, body := get(base, "")
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["name"] != "thing" {
+		t.Errorf("unexpected JSON %s", body)
+	}
+	link, ok := decoded["file"].(map[string]interface{})
+	if !ok || link["/"] != file.Cid().String() {
+		t.Errorf("expected the link to be encoded as DAG-JSON, got %s", body)
+	}
+
+	// traversal into fields
+	res, body = get(base+"/nested/n", "")
+	if res.StatusCode != http.StatusOK || body != "1" {
+		t.Errorf("unexpected response %d %q", res.StatusCode, body)
+	}
+	res, _ = get(base+"/nested/missing", "")
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected %d for a missing field, got %d", http.StatusNotFound, res.StatusCode)
+	}
+
+	// traversal through links
+	res, body = get(base+"/file", "")
+	if res.StatusCode != http.StatusOK || body != "fnord" {
+		t.Errorf("unexpected response %d %q", res.StatusCode, body)
+	}
+
+	res, body = get(base, "text/html,*/*;q=0.8")
+	if ct := res.Header.Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("unexpected content type %s", ct)
+	}
+	if !strings.Contains(body, `<a href="/ipfs/`+file.Cid().String()+`">`) {
+		t.Errorf("expected the link to be linked, got %s", body)
+	}
+
+	res, _ = get(file.String()+"?format=dag-json", "")
+	if ct := res.Header.Get("Content-Type"); res.StatusCode != http.StatusOK || ct != "application/vnd.ipld.dag-json" {
+		t.Errorf("unexpected response %d %s", res.StatusCode, ct)
+	}
+}
+
+func TestGatewayListing(t *testing.T) {
+	ns := mockNamesys{}
+	ts, api, ctx := newTestServerAndNode(t, ns)
+	defer ts.Close()
+
+	names := []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"}
+	entries := make(map[string]files.Node)
+	for _, name := range names {
+		entries[name] = files.NewBytesFile([]byte(name))
+	}
+	root, err := api.Unixfs().Add(ctx, files.NewMapDirectory(entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := root.String() + "/"
+
+	get := func(p, accept string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL+p, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(body)
+	}
+
+	res, body := get(base, "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", res.StatusCode, body)
+	}
+	for _, name := range names {
+		if !strings.Contains(body, `href="`+base+name+`"`) {
+			t.Errorf("expected the listing to link to %s", name)
+		}
+	}
+	// the files are shown with the size of their content, not of their DAG
+	if !strings.Contains(body, "<td>5 B</td>") {
+		t.Errorf("expected the listing to show the file sizes: %s", body)
+	}
+	if l := res.Header.Get("Link"); l != "" {
+		t.Errorf("unexpected Link header %s", l)
+	}
+
+	// walk the pages through the Link header
+	var listed []string
+	next := base + "?limit=2"
+	for pages := 0; next != ""; pages++ {
+		if pages > len(names) {
+			t.Fatal("too many pages")
+		}
+		res, body := get(next, "")
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d: %s", res.StatusCode, body)
+		}
+		for _, name := range names {
+			if strings.Contains(body, `href="`+base+name+`"`) {
+				listed = append(listed, name)
+			}
+		}
+		next = ""
+		if l := res.Header.Get("Link"); l != "" {
+			if !strings.HasPrefix(l, "<") || !strings.HasSuffix(l, `>; rel="next"`) {
+				t.Fatalf("unexpected Link header %s", l)
+			}
+			next = strings.TrimSuffix(strings.TrimPrefix(l, "<"), `>; rel="next"`)
+		}
+	}
+	if !reflect.DeepEqual(listed, names) {
+		t.Errorf("expected the pages to list %v, got %v", names, listed)
+	}
+
+	res, body = get(base+"?format=json&offset=1&limit=3", "")
+	if ct := res.Header.Get("Content-Type"); res.StatusCode != http.StatusOK || ct != "application/json" {
+		t.Fatalf("unexpected response %d %s: %s", res.StatusCode, ct, body)
+	}
+	if !strings.Contains(res.Header.Get("Link"), "offset=4") {
+		t.Errorf("expected a link to the next page, got %q", res.Header.Get("Link"))
+	}
+	var listing struct {
+		Path    string
+		Entries []struct {
+			Name string
+			Size uint64
+		}
+	}
+	if err := json.Unmarshal([]byte(body), &listing); err != nil {
+		t.Fatalf("invalid JSON listing %s: %s", body, err)
+	}
+	if listing.Path != base {
+		t.Errorf("unexpected path %s", listing.Path)
+	}
+	var jsonNames []string
+	for _, e := range listing.Entries {
+		if e.Size != uint64(len(e.Name)) {
+			t.Errorf("expected %s to have the size of its content, got %d", e.Name, e.Size)
+		}
+		jsonNames = append(jsonNames, e.Name)
+	}
+	if !reflect.DeepEqual(jsonNames, names[1:4]) {
+		t.Errorf("expected %v, got %v", names[1:4], jsonNames)
+	}
+
+	res, body = get(base, "application/json")
+	if err := json.Unmarshal([]byte(body), &listing); err != nil || len(listing.Entries) != len(names) {
+		t.Errorf("expected the whole listing, got %d %s", res.StatusCode, body)
+	}
+
+	for _, q := range []string{"?limit=0", "?limit=x", "?offset=-1"} {
+		if res, _ := get(base+q, ""); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", q, res.StatusCode)
+		}
+	}
+}
+
+func TestGatewayShardedListing(t *testing.T) {
+	ns := mockNamesys{}
+	ts, api, ctx := newTestServerAndNode(t, ns)
+	defer ts.Close()
+
+	uio.UseHAMTSharding = true
+	defer func() { uio.UseHAMTSharding = false }()
+
+	var names []string
+	entries := make(map[string]files.Node)
+	for n := 0; n < 50; n++ {
+		name := fmt.Sprintf("file-%02d", n)
+		names = append(names, name)
+		entries[name] = files.NewBytesFile([]byte(name))
+	}
+	root, err := api.Unixfs().Add(ctx, files.NewMapDirectory(entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := api.Dag().Get(ctx, root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fsn, err := ft.FSNodeFromBytes(nd.(*dag.ProtoNode).Data()); err != nil || fsn.Type() != ft.THAMTShard {
+		t.Fatal("expected a sharded directory")
+	}
+
+	// walk the pages through the Link header
+	var listed []string
+	next := root.String() + "/?format=json&limit=7"
+	for pages := 0; next != ""; pages++ {
+		if pages > len(names) {
+			t.Fatal("too many pages")
+		}
+		res, err := http.Get(ts.URL + next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var listing struct {
+			Entries []struct{ Name string }
+		}
+		err = json.NewDecoder(res.Body).Decode(&listing)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range listing.Entries {
+			listed = append(listed, e.Name)
+		}
+		next = ""
+		if l := res.Header.Get("Link"); l != "" {
+			next = strings.TrimSuffix(strings.TrimPrefix(l, "<"), `>; rel="next"`)
+		}
+	}
+
+	// every entry is listed once
+	sort.Strings(listed)
+	if !reflect.DeepEqual(listed, names) {
+		t.Errorf("expected the pages to list %v, got %v", names, listed)
+	}
+}
+
+func TestWritableGateway(t *testing.T) {
+	sk, pk, err := ci.GenerateKeyPair(ci.RSA, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self, err := peer.IDFromPublicKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kbytes, err := sk.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeToken, err := apitoken.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	readToken, err := apitoken.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &tokenRepo{
+		Mock: repo.Mock{
+			C: config.Config{
+				Identity: config.Identity{
+					PeerID:  self.Pretty(),
+					PrivKey: base64.StdEncoding.EncodeToString(kbytes),
+				},
+			},
+			D: syncds.MutexWrap(datastore.NewMapDatastore()),
+			K: keystore.NewMemKeystore(),
+		},
+		tokens: map[string]*apitoken.Token{
+			"write": {Hash: apitoken.Hash(writeToken), Allow: []string{"gateway/ipfs", "gateway/ipns/self"}},
+			"read":  {Hash: apitoken.Hash(readToken), Allow: []string{"cat"}},
+		},
+	}
+	n, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := mockNamesys{}
+	n.Namesys = ns
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	defer ts.Close()
+	dh.Handler, err = makeHandler(n, ts.Listener, GatewayOption(true, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, p, token, ctype string, body io.Reader) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+p, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if ctype != "" {
+			req.Header.Set("Content-Type", ctype)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+	cat := func(p string) (int, string) {
+		t.Helper()
+		res, err := http.Get(ts.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(body)
+	}
+	form := func(names ...string) (string, io.Reader) {
+		var b bytes.Buffer
+		mw := multipart.NewWriter(&b)
+		for _, name := range names {
+			fw, err := mw.CreateFormFile("file", name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(fw, "content of "+name)
+		}
+		if err := mw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return mw.FormDataContentType(), &b
+	}
+
+	// writes to /ipfs/ need a token once tokens are configured
+	ctype, body := form("site/index.html", "site/css/style.css")
+	if res := do("POST", "/ipfs/", "", ctype, body); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected an anonymous upload to be rejected, got %d", res.StatusCode)
+	}
+	ctype, body = form("site/index.html", "site/css/style.css")
+	if res := do("POST", "/ipfs/", readToken, ctype, body); res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a read-only token to be rejected, got %d", res.StatusCode)
+	}
+	ctype, body = form("site/index.html", "site/css/style.css")
+	res := do("POST", "/ipfs/", writeToken, ctype, body)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the upload to succeed, got %d", res.StatusCode)
+	}
+	root := res.Header.Get("IPFS-Hash")
+	if loc := res.Header.Get("Location"); loc != "/ipfs/"+root {
+		t.Errorf("unexpected location %s", loc)
+	}
+	for _, name := range []string{"site/index.html", "site/css/style.css"} {
+		if code, content := cat("/ipfs/" + root + "/" + name); code != http.StatusOK || content != "content of "+name {
+			t.Errorf("%s: unexpected response %d %q", name, code, content)
+		}
+	}
+
+	// uploading to a directory adds to a copy of it
+	ctype, body = form("js/app.js")
+	res = do("POST", "/ipfs/"+root+"/site", writeToken, ctype, body)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the upload to succeed, got %d", res.StatusCode)
+	}
+	root2 := res.Header.Get("IPFS-Hash")
+	for _, name := range []string{"site/index.html", "site/js/app.js"} {
+		if code, _ := cat("/ipfs/" + root2 + "/" + name); code != http.StatusOK {
+			t.Errorf("%s: unexpected status %d", name, code)
+		}
+	}
+
+	// IPNS writes always need a token allowing the key
+	ipnsFile := "/ipns/" + self.Pretty() + "/hello.txt"
+	if res := do("PUT", "/ipns/self/hello.txt", "", "", strings.NewReader("hello")); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected an anonymous IPNS write to be rejected, got %d", res.StatusCode)
+	}
+	if res := do("PUT", "/ipns/self/hello.txt", readToken, "", strings.NewReader("hello")); res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a read-only token to be rejected, got %d", res.StatusCode)
+	}
+	if res := do("PUT", "/ipns/other/hello.txt", writeToken, "", strings.NewReader("hello")); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a write to an unknown key to fail, got %d", res.StatusCode)
+	}
+	res = do("PUT", "/ipns/self/hello.txt", writeToken, "", strings.NewReader("hello"))
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the IPNS write to succeed, got %d", res.StatusCode)
+	}
+	if code, content := cat(ipnsFile); code != http.StatusOK || content != "hello" {
+		t.Fatalf("unexpected response %d %q", code, content)
+	}
+
+	ctype, body = form("docs/a.txt")
+	if res := do("POST", "/ipns/"+self.Pretty(), writeToken, ctype, body); res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the IPNS upload to succeed, got %d", res.StatusCode)
+	}
+	if code, _ := cat("/ipns/" + self.Pretty() + "/docs/a.txt"); code != http.StatusOK {
+		t.Errorf("expected the uploaded file to be published, got %d", code)
+	}
+
+	res = do("DELETE", "/ipns/self/hello.txt", writeToken, "", nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the IPNS delete to succeed, got %d", res.StatusCode)
+	}
+	if code, _ := cat(ipnsFile); code != http.StatusNotFound {
+		t.Errorf("expected the deleted file to be gone, got %d", code)
+	}
+	if code, _ := cat("/ipns/" + self.Pretty() + "/docs/a.txt"); code != http.StatusOK {
+		t.Errorf("expected the other files to be kept, got %d", code)
+	}
+
+	// only the content the key points to stays pinned
+	var pinned []string
+	for _, pi := range n.Pinning.NamedPins() {
+		if pi.Name == "gateway/ipns/self" {
+			pinned = append(pinned, pi.Key.String())
+		}
+	}
+	if len(pinned) != 1 || pinned[0] != res.Header.Get("IPFS-Hash") {
+		t.Fatalf("expected %s to be pinned, got %v", res.Header.Get("IPFS-Hash"), pinned)
+	}
+}
+
+// stallingNamesys never resolves stalledName, like an unreachable IPNS record.
+type stallingNamesys struct {
+	mockNamesys
+}
+
+const stalledName = "/ipns/stall.example.com"
+
+func (ns stallingNamesys) Resolve(ctx context.Context, name string, opts ...nsopts.ResolveOpt) (path.Path, error) {
+	if name == stalledName {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	return ns.mockNamesys.Resolve(ctx, name, opts...)
+}
+
+func TestGatewayTimeouts(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		timeouts GatewayTimeouts
+		phase    string
+	}{
+		{"resolve", GatewayTimeouts{Resolve: "50ms"}, "resolve"},
+		{"first byte", GatewayTimeouts{FirstByte: "50ms"}, "first_byte"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := newNodeWithMockNamesys(mockNamesys{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			n.Namesys = stallingNamesys{mockNamesys{}}
+
+			dh := &delegatedHandler{}
+			ts := httptest.NewServer(dh)
+			defer ts.Close()
+			dh.Handler, err = makeHandler(n, ts.Listener,
+				GatewayTimeoutsOption(tc.timeouts),
+				GatewayOption(false, "/ipfs", "/ipns"),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := http.Get(ts.URL + emptyDir + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("expected available content to be served, got %d", res.StatusCode)
+			}
+
+			begin := time.Now()
+			res, err = http.Get(ts.URL + stalledName + "/index.html")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if time.Since(begin) > 5*time.Second {
+				t.Errorf("the request took %s", time.Since(begin))
+			}
+			if res.StatusCode != http.StatusGatewayTimeout {
+				t.Fatalf("expected 504, got %d", res.StatusCode)
+			}
+			if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("unexpected content type %s", ct)
+			}
+			var body gatewayTimeoutError
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Phase != tc.phase || body.Code != http.StatusGatewayTimeout || body.Path != stalledName+"/index.html" {
+				t.Errorf("unexpected error %+v", body)
+			}
+		})
+	}
+}
+
+func TestGatewayStallTimeout(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("start"))
+		w.(http.Flusher).Flush()
+		// the content stops coming
+		<-r.Context().Done()
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveWithTimeouts(w, r, time.Second, 50*time.Millisecond, h)
+	}))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/ipfs/stalled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err == nil {
+		t.Fatal("expected the stalled response to be aborted")
+	}
+	if string(body) != "start" {
+		t.Errorf("unexpected body %q", body)
+	}
+}
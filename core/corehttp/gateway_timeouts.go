@@ -0,0 +1,314 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/ipfs/go-ipfs/core"
+)
+
+// GatewayTimeoutsConfigKey is the config key holding the timeouts of the
+// gateway requests.
+const GatewayTimeoutsConfigKey = "Gateway.Timeouts"
+
+// GatewayTimeouts bounds the time spent serving gateway requests, with
+// durations like "30s". An empty duration disables a timeout.
+type GatewayTimeouts struct {
+	// Resolve bounds the resolution of the requested path, IPNS names
+	// included.
+	Resolve string
+
+	// FirstByte bounds the time until the response starts.
+	FirstByte string
+
+	// Stall aborts responses which don't progress for that long while
+	// they are streamed.
+	Stall string
+}
+
+// Phases of a gateway request which may time out.
+const (
+	resolvePhase   = "resolve"
+	firstBytePhase = "first_byte"
+	stallPhase     = "stall"
+)
+
+var errTimedOut = errors.New("gateway request timed out")
+
+type resolveTimeoutKey struct{}
+
+// GatewayTimeoutsOption applies the timeouts to the GET and HEAD requests for
+// /ipfs/ and /ipns/ paths served by the following options. Requests which time
+// out before their response starts are answered with 504 Gateway Timeout,
+// stalled responses are aborted.
+func GatewayTimeoutsOption(t GatewayTimeouts) ServeOption {
+	return func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		resolve, err := parseTimeout("Resolve", t.Resolve)
+		if err != nil {
+			return nil, err
+		}
+		firstByte, err := parseTimeout("FirstByte", t.FirstByte)
+		if err != nil {
+			return nil, err
+		}
+		stall, err := parseTimeout("Stall", t.Stall)
+		if err != nil {
+			return nil, err
+		}
+
+		childMux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			gatewayPath := strings.HasPrefix(r.URL.Path, ipfsPathPrefix) || strings.HasPrefix(r.URL.Path, ipnsPathPrefix)
+			if !gatewayPath || (r.Method != "GET" && r.Method != "HEAD") {
+				childMux.ServeHTTP(w, r)
+				return
+			}
+
+			if resolve > 0 {
+				r = r.WithContext(context.WithValue(r.Context(), resolveTimeoutKey{}, resolve))
+			}
+			serveWithTimeouts(w, r, firstByte, stall, childMux)
+		})
+		return childMux, nil
+	}
+}
+
+func parseTimeout(name, v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s.%s: %q", GatewayTimeoutsConfigKey, name, v)
+	}
+	return d, nil
+}
+
+// resolveContext returns the context bounding the resolution of the path
+// requested with ctx.
+func resolveContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d, ok := ctx.Value(resolveTimeoutKey{}).(time.Duration); ok {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+// gatewayTimeoutError is the body of 504 Gateway Timeout responses.
+type gatewayTimeoutError struct {
+	Message string
+	Code    int
+	Type    string
+	Phase   string
+	Path    string
+	Timeout string
+}
+
+// writeTimeoutError answers r with a 504 Gateway Timeout response, the request
+// having timed out in phase after d.
+func writeTimeoutError(w http.ResponseWriter, r *http.Request, phase string, d time.Duration) {
+	gatewayTimeoutsMetric.WithLabelValues(pathNamespace(r.URL.Path), phase).Inc()
+
+	body, _ := json.Marshal(&gatewayTimeoutError{
+		Message: fmt.Sprintf("%s timed out after %s", r.URL.Path, d),
+		Code:    http.StatusGatewayTimeout,
+		Type:    "timeout",
+		Phase:   phase,
+		Path:    r.URL.Path,
+		Timeout: d.String(),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusGatewayTimeout)
+	w.Write(body)
+	w.Write([]byte("\n"))
+}
+
+func pathNamespace(p string) string {
+	if strings.HasPrefix(p, ipnsPathPrefix) {
+		return "ipns"
+	}
+	return "ipfs"
+}
+
+// serveWithTimeouts serves r with h, answering it with 504 Gateway Timeout if
+// the response doesn't start within firstByte, and aborting the response if
+// nothing is written to it for stall once it started.
+func serveWithTimeouts(w http.ResponseWriter, r *http.Request, firstByte, stall time.Duration, h http.Handler) {
+	if firstByte <= 0 && stall <= 0 {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	tw := &timeoutWriter{
+		rw:        w,
+		header:    make(http.Header),
+		req:       r,
+		cancel:    cancel,
+		firstByte: firstByte,
+		stall:     stall,
+	}
+	tw.start()
+	defer tw.stop()
+
+	h.ServeHTTP(tw, r.WithContext(ctx))
+	tw.finish()
+
+	if tw.stalled() {
+		// let the client tell the response is truncated
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// timeoutWriter watches the progress of a response. The handler gets its own
+// header map, so that a 504 response can be written in its place until it
+// starts its response.
+type timeoutWriter struct {
+	rw     http.ResponseWriter
+	header http.Header
+	req    *http.Request
+	cancel context.CancelFunc
+
+	firstByte time.Duration
+	stall     time.Duration
+
+	mu        sync.Mutex
+	timer     *time.Timer
+	started   bool
+	writing   bool
+	lastWrite time.Time
+	timedOut  string
+	done      bool
+}
+
+func (tw *timeoutWriter) start() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.firstByte > 0 {
+		tw.timer = time.AfterFunc(tw.firstByte, tw.fire)
+	}
+}
+
+func (tw *timeoutWriter) stop() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.done = true
+	if tw.timer != nil {
+		tw.timer.Stop()
+	}
+}
+
+// finish starts the response if the handler returned without writing to it.
+func (tw *timeoutWriter) finish() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeader(http.StatusOK)
+}
+
+func (tw *timeoutWriter) stalled() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.timedOut == stallPhase
+}
+
+func (tw *timeoutWriter) fire() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.done || tw.timedOut != "" {
+		return
+	}
+
+	if !tw.started {
+		tw.timedOut = firstBytePhase
+		writeTimeoutError(tw.rw, tw.req, firstBytePhase, tw.firstByte)
+		tw.cancel()
+		return
+	}
+	if tw.stall <= 0 {
+		// the first byte timer, which fired as the response started
+		return
+	}
+
+	// the timer may have fired while it was reset, and a write blocked on a
+	// slow client isn't a stall
+	if idle := time.Since(tw.lastWrite); tw.writing || idle < tw.stall {
+		tw.timer.Reset(tw.stall - idle)
+		return
+	}
+	tw.timedOut = stallPhase
+	gatewayTimeoutsMetric.WithLabelValues(pathNamespace(tw.req.URL.Path), stallPhase).Inc()
+	log.Warningf("aborted stalled response for %s after %s", tw.req.URL.Path, tw.stall)
+	tw.cancel()
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeader(code)
+}
+
+// writeHeader starts the response, unless it timed out. tw.mu is held.
+func (tw *timeoutWriter) writeHeader(code int) {
+	if tw.started || tw.timedOut != "" {
+		return
+	}
+	tw.started = true
+
+	dst := tw.rw.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+	tw.rw.WriteHeader(code)
+
+	// from now on, the response must keep progressing
+	tw.lastWrite = time.Now()
+	if tw.timer != nil {
+		tw.timer.Stop()
+		tw.timer = nil
+	}
+	if tw.stall > 0 {
+		tw.timer = time.AfterFunc(tw.stall, tw.fire)
+	}
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	tw.writeHeader(http.StatusOK)
+	if tw.timedOut != "" {
+		tw.mu.Unlock()
+		return 0, errTimedOut
+	}
+	tw.writing = true
+	tw.mu.Unlock()
+
+	n, err := tw.rw.Write(b)
+
+	tw.mu.Lock()
+	tw.writing = false
+	tw.lastWrite = time.Now()
+	tw.mu.Unlock()
+	return n, err
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.started || tw.timedOut != "" {
+		return
+	}
+	if f, ok := tw.rw.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,398 @@
+package corehttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	gopath "path"
+	"strings"
+
+	apitoken "github.com/ipfs/go-ipfs/core/apitoken"
+	"github.com/ipfs/go-ipfs/dagutils"
+	namesys "github.com/ipfs/go-ipfs/namesys"
+	"github.com/ipfs/go-ipfs/namesys/resolve"
+	"github.com/ipfs/go-ipfs/pin"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	path "github.com/ipfs/go-path"
+	ft "github.com/ipfs/go-unixfs"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// Paths of the API tokens allowed to write through the gateway, checked like
+// command paths: a token allowing "gateway" may do both, and one allowing
+// "gateway/ipns" may publish under any key.
+const (
+	gatewayIpfsScope = "gateway/ipfs"
+	gatewayIpnsScope = "gateway/ipns"
+)
+
+// writeToken authenticates a write request. Once API tokens are configured,
+// or if required is set, the request must carry a valid token, which is
+// returned. Otherwise the request is answered and ok is false.
+func (i *gatewayHandler) writeToken(w http.ResponseWriter, r *http.Request, required bool) (token *apitoken.Token, ok bool) {
+	enabled, err := i.auth.Enabled()
+	if err != nil {
+		internalWebError(w, err)
+		return nil, false
+	}
+	if !enabled && !required {
+		return nil, true
+	}
+
+	hdr := r.Header.Get("Authorization")
+	if !strings.HasPrefix(hdr, "Bearer ") {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "missing API token", http.StatusUnauthorized)
+		return nil, false
+	}
+	token, err = i.auth.Authorize(strings.TrimSpace(hdr[len("Bearer "):]))
+	if err != nil {
+		internalWebError(w, err)
+		return nil, false
+	}
+	if token == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "invalid API token", http.StatusUnauthorized)
+		return nil, false
+	}
+	return token, true
+}
+
+// allowWrite checks that token may write to scope, answering the request
+// with 403 Forbidden if it may not. A nil token, for unauthenticated gateways,
+// may write anywhere.
+func allowWrite(w http.ResponseWriter, token *apitoken.Token, scope string) bool {
+	if token != nil && !token.Allows(scope) {
+		http.Error(w, fmt.Sprintf("API token not allowed to write to %q", scope), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// upload is a file of a multipart upload, added to the DAG.
+type upload struct {
+	path string
+	node ipld.Node
+}
+
+// readUpload adds the files of a multipart/form-data request to the DAG. The
+// filename of each part is its path in the uploaded directory, the directories
+// on the way are created as needed.
+func (i *gatewayHandler) readUpload(r *http.Request) ([]upload, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	var uploads []upload
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		// Part.FileName only keeps the base name, the path is needed
+		_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if err != nil {
+			return nil, err
+		}
+		name, ok := params["filename"]
+		if !ok {
+			// a form field
+			continue
+		}
+		name = strings.Trim(gopath.Clean("/"+name), "/")
+		if name == "" {
+			return nil, fmt.Errorf("invalid filename %q", params["filename"])
+		}
+
+		nd, err := i.newDagFromReader(part)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload{name, nd})
+	}
+	if len(uploads) == 0 {
+		return nil, fmt.Errorf("no file uploaded")
+	}
+	return uploads, nil
+}
+
+// insertUploads inserts the uploaded files under dir in the directory root,
+// returning the new root.
+func (i *gatewayHandler) insertUploads(ctx context.Context, root *dag.ProtoNode, dir string, uploads []upload) (ipld.Node, error) {
+	if err := checkEditable(root); err != nil {
+		return nil, err
+	}
+	e := dagutils.NewDagEditor(root, i.node.DAG)
+	for _, u := range uploads {
+		if err := e.InsertNodeAtPath(ctx, gopath.Join(dir, u.path), u.node, ft.EmptyDirNode); err != nil {
+			return nil, err
+		}
+	}
+	return e.Finalize(ctx, i.node.DAG)
+}
+
+// checkEditable returns an error unless nd is a plain UnixFS directory, the
+// only kind of node the gateway links files into.
+func checkEditable(nd *dag.ProtoNode) error {
+	fsn, err := ft.FSNodeFromBytes(nd.Data())
+	if err != nil {
+		return err
+	}
+	if fsn.Type() != ft.TDirectory {
+		return fmt.Errorf("%s isn't a directory the gateway can modify", nd.Cid())
+	}
+	return nil
+}
+
+// postUploadHandler handles multipart uploads to /ipfs/ paths. Uploading to
+// /ipfs/ creates a new directory, uploading to /ipfs/<cid>/<path> adds the
+// files to a copy of that directory.
+func (i *gatewayHandler) postUploadHandler(w http.ResponseWriter, r *http.Request) {
+	root := ft.EmptyDirNode()
+	var dir string
+	if p := strings.Trim(strings.TrimPrefix(r.URL.Path, ipfsPathPrefix), "/"); p != "" {
+		c, components, err := path.SplitAbsPath(path.Path(ipfsPathPrefix + p))
+		if err != nil {
+			webError(w, "postHandler: IPFS path not valid", err, http.StatusBadRequest)
+			return
+		}
+		nd, err := i.node.DAG.Get(r.Context(), c)
+		if err != nil {
+			webError(w, "postHandler: could not get root", err, http.StatusNotFound)
+			return
+		}
+		pbnd, ok := nd.(*dag.ProtoNode)
+		if !ok {
+			webError(w, "Cannot read non protobuf nodes through gateway", dag.ErrNotProtobuf, http.StatusBadRequest)
+			return
+		}
+		root = pbnd
+		dir = path.Join(components)
+	}
+
+	uploads, err := i.readUpload(r)
+	if err != nil {
+		webError(w, "postHandler: invalid upload", err, http.StatusBadRequest)
+		return
+	}
+	nnode, err := i.insertUploads(r.Context(), root, dir, uploads)
+	if err != nil {
+		webError(w, "postHandler: could not add the files", err, http.StatusBadRequest)
+		return
+	}
+
+	i.addUserHeaders(w) // ok, _now_ write user's headers.
+	w.Header().Set("IPFS-Hash", nnode.Cid().String())
+	http.Redirect(w, r, gopath.Join(ipfsPathPrefix, nnode.Cid().String(), dir), http.StatusCreated)
+}
+
+// ipnsWriteHandler applies PUT, DELETE and multipart POST requests to
+// /ipns/<key>/<path> to the directory the key points to, and republishes the
+// result under the key. The request must carry an API token allowing
+// gateway/ipns/<key name>.
+func (i *gatewayHandler) ipnsWriteHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := i.writeToken(w, r, true)
+	if !ok {
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, ipnsPathPrefix), "/", 2)
+	keyName, subpath := parts[0], ""
+	if len(parts) == 2 {
+		subpath = strings.Trim(gopath.Clean("/"+parts[1]), "/")
+	}
+
+	k, err := i.ownKey(r.Context(), keyName)
+	if err != nil {
+		webError(w, "ipns write", err, http.StatusNotFound)
+		return
+	}
+	if !allowWrite(w, token, gatewayIpnsScope+"/"+k.Name()) {
+		return
+	}
+
+	// the uploads are only added to the DAG by edit, which updateIpns runs
+	// under the pin lock so that GC doesn't collect them before they're pinned
+	var edit func(root *dag.ProtoNode) (ipld.Node, error)
+	switch r.Method {
+	case "PUT":
+		edit = func(root *dag.ProtoNode) (ipld.Node, error) {
+			nd, err := i.newDagFromReader(r.Body)
+			if err != nil {
+				return nil, fmt.Errorf("could not create DAG from request: %s", err)
+			}
+			if subpath == "" {
+				// the key now points to the uploaded file
+				return nd, nil
+			}
+			return i.insertUploads(r.Context(), root, "", []upload{{subpath, nd}})
+		}
+
+	case "POST":
+		edit = func(root *dag.ProtoNode) (ipld.Node, error) {
+			uploads, err := i.readUpload(r)
+			if err != nil {
+				return nil, fmt.Errorf("invalid upload: %s", err)
+			}
+			return i.insertUploads(r.Context(), root, subpath, uploads)
+		}
+
+	case "DELETE":
+		if subpath == "" {
+			webError(w, "deleteHandler: nothing to delete", fmt.Errorf("no path under %s", keyName), http.StatusBadRequest)
+			return
+		}
+		edit = func(root *dag.ProtoNode) (ipld.Node, error) {
+			if err := checkEditable(root); err != nil {
+				return nil, err
+			}
+			e := dagutils.NewDagEditor(root, i.node.DAG)
+			if err := e.RmLink(r.Context(), subpath); err != nil {
+				return nil, err
+			}
+			return e.Finalize(r.Context(), i.node.DAG)
+		}
+	}
+
+	c, err := i.updateIpns(r.Context(), k, edit)
+	if err != nil {
+		if err == dag.ErrLinkNotFound {
+			webError(w, "ipns write", err, http.StatusNotFound)
+		} else {
+			webError(w, "ipns write", err, http.StatusBadRequest)
+		}
+		return
+	}
+
+	location := gopath.Join(ipnsPathPrefix, keyName, subpath)
+	if r.Method == "DELETE" {
+		location = gopath.Dir(location)
+	}
+	i.addUserHeaders(w) // ok, _now_ write user's headers.
+	w.Header().Set("IPFS-Hash", c.String())
+	http.Redirect(w, r, location, http.StatusCreated)
+}
+
+// ownKey returns the key of this node named name, or whose IPNS name is name.
+func (i *gatewayHandler) ownKey(ctx context.Context, name string) (coreiface.Key, error) {
+	keys, err := i.api.Key().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if k.Name() == name || k.Path().String() == ipnsPathPrefix+name {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("no key named %q", name)
+}
+
+// updateIpns applies edit to the directory k points to, publishes the result
+// under k and returns its CID. A key which was never published starts from an
+// empty directory.
+func (i *gatewayHandler) updateIpns(ctx context.Context, k coreiface.Key, edit func(root *dag.ProtoNode) (ipld.Node, error)) (cid.Cid, error) {
+	// updates are read-modify-write cycles, which mustn't interleave
+	i.ipnsLk.Lock()
+	defer i.ipnsLk.Unlock()
+
+	var root *dag.ProtoNode
+	nd, err := resolve.Resolve(ctx, i.node.Namesys, i.node.Resolver, path.Path(k.Path().String()))
+	switch err {
+	case nil:
+		pbnd, ok := nd.(*dag.ProtoNode)
+		if !ok {
+			return cid.Undef, dag.ErrNotProtobuf
+		}
+		root = pbnd
+	case namesys.ErrResolveFailed:
+		root = ft.EmptyDirNode()
+	default:
+		return cid.Undef, fmt.Errorf("could not resolve %s: %s", k.Path(), err)
+	}
+
+	// the content the key points to is pinned under a name of its own, the
+	// previous root being unpinned once the new one is published
+	pinName := ipnsPinName(k)
+	nnode, oldRoots, repinned, err := i.editIpnsRoot(ctx, root, edit, pinName)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	_, err = i.api.Name().Publish(ctx, ipath.IpfsPath(nnode.Cid()),
+		options.Name.Key(k.Name()), options.Name.AllowOffline(true))
+	if err != nil {
+		if !repinned {
+			if uerr := i.unpinIpnsRoots(ctx, []cid.Cid{nnode.Cid()}, pinName); uerr != nil {
+				log.Errorf("could not unpin unpublished %s: %s", nnode.Cid(), uerr)
+			}
+		}
+		return cid.Undef, fmt.Errorf("could not publish %s: %s", nnode.Cid(), err)
+	}
+
+	if err := i.unpinIpnsRoots(ctx, oldRoots, pinName); err != nil {
+		log.Errorf("could not unpin the previous content of %s: %s", k.Name(), err)
+	}
+	return nnode.Cid(), nil
+}
+
+// ipnsPinName returns the name the content published under k through the
+// gateway is pinned under.
+func ipnsPinName(k coreiface.Key) string {
+	return gatewayIpnsScope + "/" + k.Name()
+}
+
+// editIpnsRoot applies edit to root and pins the result under name, holding
+// the pin lock from the first block edit writes until the result is pinned.
+// It returns the other roots pinned under name, and whether the result already
+// was one of them.
+func (i *gatewayHandler) editIpnsRoot(ctx context.Context, root *dag.ProtoNode, edit func(root *dag.ProtoNode) (ipld.Node, error), name string) (nd ipld.Node, oldRoots []cid.Cid, repinned bool, err error) {
+	defer i.node.Blockstore.PinLock().Unlock()
+
+	nd, err = edit(root)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	for _, pi := range i.node.Pinning.NamedPins() {
+		if pi.Mode != pin.Recursive || pi.Name != name {
+			continue
+		}
+		if pi.Key.Equals(nd.Cid()) {
+			repinned = true
+		} else {
+			oldRoots = append(oldRoots, pi.Key)
+		}
+	}
+	if err := i.node.Pinning.PinNamed(ctx, nd, true, name, nil); err != nil {
+		return nil, nil, false, fmt.Errorf("could not pin %s: %s", nd.Cid(), err)
+	}
+	if err := i.node.Pinning.Flush(); err != nil {
+		return nil, nil, false, fmt.Errorf("could not pin %s: %s", nd.Cid(), err)
+	}
+	return nd, oldRoots, repinned, nil
+}
+
+func (i *gatewayHandler) unpinIpnsRoots(ctx context.Context, roots []cid.Cid, name string) error {
+	if len(roots) == 0 {
+		return nil
+	}
+	defer i.node.Blockstore.PinLock().Unlock()
+	for _, c := range roots {
+		if err := i.node.Pinning.UnpinNamed(ctx, c, true, name); err != nil && err != pin.ErrNotPinned {
+			return err
+		}
+	}
+	return i.node.Pinning.Flush()
+}
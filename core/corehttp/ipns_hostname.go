@@ -23,13 +23,18 @@ func IPNSHostnameOption() ServeOption {
 			ctx, cancel := context.WithCancel(n.Context())
 			defer cancel()
 
+			// SubdomainGatewayOption has already mapped the host to a path
+			if _, ok := originalPath(r); ok {
+				childMux.ServeHTTP(w, r)
+				return
+			}
+
 			host := strings.SplitN(r.Host, ":", 2)[0]
 			if len(host) > 0 && isd.IsDomain(host) {
 				name := "/ipns/" + host
 				_, err := n.Namesys.Resolve(ctx, name, nsopts.Depth(1))
 				if err == nil || err == namesys.ErrResolveRecursion {
-					r.Header.Set("X-Ipns-Original-Path", r.URL.Path)
-					r.URL.Path = name + r.URL.Path
+					r = rewritePath(r, name+r.URL.Path)
 				}
 			}
 			childMux.ServeHTTP(w, r)
@@ -37,3 +42,20 @@ func IPNSHostnameOption() ServeOption {
 		return childMux, nil
 	}
 }
+
+type originalPathKey struct{}
+
+// rewritePath points r at p, recording the requested path for the gateway
+// handler to build the links and redirects of the response. The path is
+// kept in the context, where clients can't set it.
+func rewritePath(r *http.Request, p string) *http.Request {
+	r = r.WithContext(context.WithValue(r.Context(), originalPathKey{}, r.URL.Path))
+	r.URL.Path = p
+	return r
+}
+
+// originalPath returns the path requested before rewritePath, if any.
+func originalPath(r *http.Request) (string, bool) {
+	p, ok := r.Context().Value(originalPathKey{}).(string)
+	return p, ok
+}
@@ -0,0 +1,119 @@
+package corehttp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// Permissions of the unix domain sockets created by Listen. The API can only
+// be used by the user running the daemon, the gateway also by its group, for
+// example by a reverse proxy.
+const (
+	APISocketMode     os.FileMode = 0600
+	GatewaySocketMode os.FileMode = 0660
+)
+
+// Listen listens on addr, which may be a TCP or a unix domain socket
+// multiaddr such as /unix/run/ipfs/api.sock. Unix domain sockets are created
+// with the given permissions, after removing a socket left by a daemon which
+// didn't exit cleanly.
+func Listen(addr ma.Multiaddr, mode os.FileMode) (manet.Listener, error) {
+	path, err := addr.ValueForProtocol(ma.P_UNIX)
+	if err != nil {
+		// not a unix domain socket
+		return manet.Listen(addr)
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	// The socket is created in a directory only its owner can enter, so
+	// that nobody else may connect before its permissions are set, then
+	// moved into place.
+	tmpDir, err := ioutil.TempDir(filepath.Dir(path), ".ipfs-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpPath := filepath.Join(tmpDir, "sock")
+	tmpAddr, err := ma.NewMultiaddr("/unix" + tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	lis, err := manet.Listen(tmpAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		lis.Close()
+		return nil, fmt.Errorf("could not set the permissions of %s: %s", path, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		lis.Close()
+		return nil, err
+	}
+	return &unixListener{Listener: lis, addr: addr, path: path}, nil
+}
+
+// unixListener is a listener on a unix domain socket moved to path after it
+// was created.
+type unixListener struct {
+	manet.Listener
+	addr ma.Multiaddr
+	path string
+}
+
+func (l *unixListener) Multiaddr() ma.Multiaddr {
+	return l.addr
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// Close closes the listener and removes its socket, which the listener only
+// knows by the path it was created at.
+func (l *unixListener) Close() error {
+	err := l.Listener.Close()
+	if rerr := os.Remove(l.path); rerr != nil && !os.IsNotExist(rerr) && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// IsUnixAddr returns whether addr is the multiaddr of a unix domain socket.
+func IsUnixAddr(addr ma.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(ma.P_UNIX)
+	return err == nil
+}
+
+// removeStaleSocket removes the unix domain socket at path if nothing listens
+// on it anymore.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a unix domain socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already in use", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,88 @@
+package corehttp
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestListenUnix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix domain sockets are not supported on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "ipfs-listen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "api.sock")
+	addr, err := ma.NewMultiaddr("/unix" + sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsUnixAddr(addr) {
+		t.Fatalf("%s is a unix domain socket address", addr)
+	}
+
+	lis, err := Listen(addr, APISocketMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != APISocketMode {
+		t.Fatalf("expected mode %s, got %s", APISocketMode, fi.Mode().Perm())
+	}
+	if !lis.Multiaddr().Equal(addr) {
+		t.Fatalf("expected to listen on %s, got %s", addr, lis.Multiaddr())
+	}
+
+	// the socket is in use
+	if _, err := Listen(addr, APISocketMode); err == nil {
+		t.Fatal("expected listening on a socket in use to fail")
+	}
+	lis.Close()
+
+	// leave a stale socket behind, as a crashed daemon would
+	ul, err := net.ListenUnix("unix", &net.UnixAddr{Name: sock, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ul.SetUnlinkOnClose(false)
+	ul.Close()
+
+	lis, err = Listen(addr, GatewaySocketMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	if fi, err = os.Stat(sock); err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != GatewaySocketMode {
+		t.Fatalf("expected mode %s, got %s", GatewaySocketMode, fi.Mode().Perm())
+	}
+
+	// the socket is created elsewhere and moved into place
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "api.sock" {
+		t.Fatalf("expected only the socket in %s, got %d entries", dir, len(entries))
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -104,8 +104,26 @@ var (
 		Name:      "unixfs_get_latency_seconds",
 		Help:      "The time till the first block is received when 'getting' a file from the gateway.",
 	}, []string{"namespace"})
+
+	rangeRequestsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ipfs",
+		Subsystem: "http",
+		Name:      "gateway_range_requests_total",
+		Help:      "The number of range requests to the gateway by result: single or multi for partial responses, full or unsatisfiable otherwise.",
+	}, []string{"namespace", "result"})
+
+	gatewayTimeoutsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ipfs",
+		Subsystem: "http",
+		Name:      "gateway_timeouts_total",
+		Help:      "The number of gateway requests which timed out by phase: resolve or first_byte for 504 responses, stall for aborted responses.",
+	}, []string{"namespace", "phase"})
 )
 
+func init() {
+	prometheus.MustRegister(unixfsGetMetric, rangeRequestsMetric, gatewayTimeoutsMetric)
+}
+
 type IpfsNodeCollector struct {
 	Node *core.IpfsNode
 }
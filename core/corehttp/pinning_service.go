@@ -0,0 +1,113 @@
+package corehttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	core "github.com/ipfs/go-ipfs/core"
+	apitoken "github.com/ipfs/go-ipfs/core/apitoken"
+	remote "github.com/ipfs/go-ipfs/pin/remote"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// PinningServiceConfigKey is the config key enabling the pinning service
+// served by the gateway.
+const PinningServiceConfigKey = "Pinning.Service"
+
+// PinningServiceConfig configures the pinning service.
+type PinningServiceConfig struct {
+	Enabled bool
+}
+
+// PinningServicePath is the endpoint of the pinning service, the Pinning
+// Services API being served under PinningServicePath/pins.
+const PinningServicePath = "/pinning"
+
+// PinningServiceScope is the scope an API token must allow to use the
+// pinning service.
+const PinningServiceScope = "pinning-service"
+
+// PinningServiceOption serves the Pinning Services API, pinning the requested
+// content on the node. Requests must carry an API token allowing
+// PinningServiceScope, so the service is unusable until tokens are
+// configured.
+func PinningServiceOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		auth, err := apitoken.NewAuthorizer(n.Repo)
+		if err != nil {
+			return nil, err
+		}
+		server, err := remote.NewServer(n.Context(), n.Pinning, n.DAG, n.Blockstore)
+		if err != nil {
+			return nil, err
+		}
+
+		if n.PeerHost != nil {
+			for _, addr := range n.PeerHost.Addrs() {
+				server.Delegates = append(server.Delegates, addr.String()+"/ipfs/"+n.Identity.Pretty())
+			}
+			server.Connect = func(ctx context.Context, origins []string) {
+				connectOrigins(ctx, n, origins)
+			}
+		}
+
+		handler := &pinningServiceAuth{auth: auth, next: server}
+		mux.Handle(PinningServicePath+"/", http.StripPrefix(PinningServicePath, handler))
+		return mux, nil
+	}
+}
+
+// connectOrigins connects to the peers holding the content of a pin request,
+// ignoring the invalid and unreachable ones.
+func connectOrigins(ctx context.Context, n *core.IpfsNode, origins []string) {
+	for _, o := range origins {
+		addr, err := ma.NewMultiaddr(o)
+		if err != nil {
+			continue
+		}
+		pi, err := peer.AddrInfoFromP2pAddr(addr)
+		if err != nil || pi.ID == n.Identity {
+			continue
+		}
+		if err := n.PeerHost.Connect(ctx, *pi); err != nil {
+			log.Debugf("could not connect to origin %s: %s", o, err)
+		}
+	}
+}
+
+// pinningServiceAuth checks the bearer token of pinning service requests.
+type pinningServiceAuth struct {
+	auth *apitoken.Authorizer
+	next http.Handler
+}
+
+func (h *pinningServiceAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hdr := r.Header.Get("Authorization")
+	if !strings.HasPrefix(hdr, "Bearer ") {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "missing API token", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := h.auth.Authorize(strings.TrimSpace(hdr[len("Bearer "):]))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if token == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "invalid API token", http.StatusUnauthorized)
+		return
+	}
+	if !token.Allows(PinningServiceScope) {
+		http.Error(w, "API token not allowed to use the pinning service", http.StatusForbidden)
+		return
+	}
+
+	// each token only sees the pin requests it made
+	h.next.ServeHTTP(w, remote.WithOwner(r, token.Name))
+}
@@ -0,0 +1,181 @@
+package corehttp
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	core "github.com/ipfs/go-ipfs/core"
+
+	cid "github.com/ipfs/go-cid"
+	mbase "github.com/multiformats/go-multibase"
+)
+
+// PublicGatewaysConfigKey is the config key holding the hostnames served by
+// SubdomainGatewayOption.
+const PublicGatewaysConfigKey = "Gateway.PublicGateways"
+
+// libp2pKeyCodec is the multicodec used when a peer ID is put into a
+// subdomain as a CIDv1.
+const libp2pKeyCodec = 0x72
+
+// GatewaySpec describes how a single public gateway hostname is served.
+type GatewaySpec struct {
+	// Paths are the namespaces ("/ipfs", "/ipns") served by this hostname.
+	Paths []string
+
+	// UseSubdomains makes the gateway serve content from
+	// <cidv1b32>.ipfs.<hostname> and <name>.ipns.<hostname>, giving each
+	// root its own web origin. Path-style requests are redirected there.
+	UseSubdomains bool
+}
+
+func (gw *GatewaySpec) hasPath(ns string) bool {
+	for _, p := range gw.Paths {
+		if strings.TrimSuffix(p, "/") == "/"+ns {
+			return true
+		}
+	}
+	return false
+}
+
+// SubdomainGatewayOption serves the given public gateway hostnames. For every
+// hostname with UseSubdomains set, requests for
+// <cidv1b32>.ipfs.<hostname>/<path> and <name>.ipns.<hostname>/<path> are
+// rewritten to /ipfs/<cid>/<path> and /ipns/<name>/<path> respectively, and
+// path-style requests made directly to <hostname> are redirected to their
+// canonical subdomain.
+func SubdomainGatewayOption(gateways map[string]*GatewaySpec) ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		childMux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			host := strings.SplitN(r.Host, ":", 2)[0]
+
+			if gw, ok := gateways[host]; ok {
+				if gw.UseSubdomains {
+					if to, ok := toSubdomainURL(gw, r); ok {
+						http.Redirect(w, r, to, http.StatusMovedPermanently)
+						return
+					}
+				}
+				childMux.ServeHTTP(w, r)
+				return
+			}
+
+			if gw, gwHost, ns, rootID, ok := parseSubdomain(host, gateways); ok {
+				switch ns {
+				case "ipfs":
+					c, err := cid.Decode(rootID)
+					if err != nil {
+						webError(w, "invalid cid in subdomain", err, http.StatusBadRequest)
+						return
+					}
+					// redirect to the canonical, case-insensitive form
+					if canonical := toCidV1Base32(c); canonical != rootID {
+						http.Redirect(w, r, subdomainURL(r, canonical, ns, gwHost, r.URL.Path), http.StatusMovedPermanently)
+						return
+					}
+				case "ipns":
+					// peer IDs are case sensitive in their base58 form and are
+					// therefore put into the hostname as a base32 CIDv1
+					if c, err := cid.Decode(rootID); err == nil {
+						rootID = c.Hash().B58String()
+					}
+				}
+
+				if gw.hasPath(ns) {
+					r = rewritePath(r, "/"+ns+"/"+rootID+r.URL.Path)
+				}
+			}
+			childMux.ServeHTTP(w, r)
+		})
+		return childMux, nil
+	}
+}
+
+// parseSubdomain checks whether host is a subdomain of one of the gateways
+// which have UseSubdomains enabled. It returns the matching gateway and its
+// hostname, the namespace and the root identifier encoded in the subdomain.
+func parseSubdomain(host string, gateways map[string]*GatewaySpec) (*GatewaySpec, string, string, string, bool) {
+	for gwHost, gw := range gateways {
+		if !gw.UseSubdomains {
+			continue
+		}
+		for _, ns := range []string{"ipfs", "ipns"} {
+			suffix := "." + ns + "." + gwHost
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return gw, gwHost, ns, host[:len(host)-len(suffix)], true
+			}
+		}
+	}
+	return nil, "", "", "", false
+}
+
+// toSubdomainURL returns the subdomain URL for a path-style request made to
+// a gateway hostname. It returns false if the request path doesn't point
+// into a namespace served by the gateway.
+func toSubdomainURL(gw *GatewaySpec, r *http.Request) (string, bool) {
+	parts := strings.SplitN(r.URL.Path, "/", 4)
+	if len(parts) < 3 || parts[2] == "" {
+		return "", false
+	}
+
+	ns, rootID := parts[1], parts[2]
+	if (ns != "ipfs" && ns != "ipns") || !gw.hasPath(ns) {
+		return "", false
+	}
+
+	switch c, err := cid.Decode(rootID); {
+	case err == nil && ns == "ipfs":
+		rootID = toCidV1Base32(c)
+	case err == nil && ns == "ipns":
+		rootID = toCidV1Base32(cid.NewCidV1(libp2pKeyCodec, c.Hash()))
+	case ns == "ipfs":
+		// not a cid, let the gateway handler report the error
+		return "", false
+	}
+
+	rest := "/"
+	if len(parts) == 4 {
+		rest += parts[3]
+	}
+
+	return subdomainURL(r, rootID, ns, strings.SplitN(r.Host, ":", 2)[0], rest), true
+}
+
+// subdomainURL builds the absolute URL of rest under <rootID>.<ns>.<gwHost>,
+// keeping the port, scheme and query of the original request.
+func subdomainURL(r *http.Request, rootID, ns, gwHost, rest string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	host := rootID + "." + ns + "." + gwHost
+	if hp := strings.SplitN(r.Host, ":", 2); len(hp) == 2 {
+		host += ":" + hp[1]
+	}
+
+	u := url.URL{
+		Scheme:   scheme,
+		Host:     host,
+		Path:     rest,
+		RawQuery: r.URL.RawQuery,
+	}
+	return u.String()
+}
+
+// toCidV1Base32 returns the case-insensitive string form of c, which is safe
+// to use as a DNS label.
+func toCidV1Base32(c cid.Cid) string {
+	if c.Version() == 0 {
+		c = cid.NewCidV1(cid.DagProtobuf, c.Hash())
+	}
+	s, err := c.StringOfBase(mbase.Base32)
+	if err != nil {
+		// base32 is always supported
+		panic(err)
+	}
+	return s
+}
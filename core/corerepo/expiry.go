@@ -0,0 +1,50 @@
+package corerepo
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/pin"
+)
+
+// PinExpiryPeriod is how often PeriodicPinExpiry removes the expired pins.
+var PinExpiryPeriod = time.Minute
+
+// RemoveExpiredPins removes the pins of the node which expired and returns
+// them.
+func RemoveExpiredPins(n *core.IpfsNode) ([]pin.PinInfo, error) {
+	defer n.Blockstore.PinLock().Unlock()
+
+	expired, err := n.Pinning.RemoveExpired(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+	for _, p := range expired {
+		if p.Name != "" {
+			log.Infof("pin of %s named %q expired", p.Key, p.Name)
+		} else {
+			log.Infof("pin of %s expired", p.Key)
+		}
+	}
+	return expired, n.Pinning.Flush()
+}
+
+// PeriodicPinExpiry removes the expired pins of the node every
+// PinExpiryPeriod until ctx is done.
+func PeriodicPinExpiry(ctx context.Context, n *core.IpfsNode) error {
+	for {
+		if _, err := RemoveExpiredPins(n); err != nil {
+			log.Errorf("could not remove expired pins: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(PinExpiryPeriod):
+		}
+	}
+}
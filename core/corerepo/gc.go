@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/ipfs/go-ipfs/core"
@@ -20,6 +21,15 @@ var log = logging.Logger("corerepo")
 
 var ErrMaxStorageExceeded = errors.New("maximum storage limit exceeded. Try to unpin some files")
 
+// ErrNoIncrementalGC is returned when pausing or resuming the garbage
+// collection of a node which isn't running an incremental one.
+var ErrNoIncrementalGC = errors.New("no incremental garbage collection is running")
+
+var (
+	incrementalGCLk sync.Mutex
+	incrementalGC   = make(map[*core.IpfsNode]*gc.Control)
+)
+
 type GC struct {
 	Node       *core.IpfsNode
 	Repo       repo.Repo
@@ -159,6 +169,88 @@ func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context) <-chan gc.Result
 	return gc.GC(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots)
 }
 
+// GarbageCollectDryRun reports the blocks a garbage collection of the node
+// would remove without removing them, see gc.DryRun.
+func GarbageCollectDryRun(n *core.IpfsNode, ctx context.Context) <-chan gc.Result {
+	roots, err := BestEffortRoots(n.FilesRoot)
+	if err != nil {
+		out := make(chan gc.Result, 1)
+		out <- gc.Result{Error: err}
+		close(out)
+		return out
+	}
+
+	return gc.DryRun(ctx, n.Blockstore, n.Pinning, roots)
+}
+
+// GarbageCollectIncremental starts an incremental garbage collection of the
+// node's blockstore which doesn't block writers while marking, see
+// gc.IncrementalGC. While it runs, it can be paused with PauseGC.
+func GarbageCollectIncremental(n *core.IpfsNode, ctx context.Context, opts gc.IncrementalOptions) (<-chan gc.Result, error) {
+	tbs, ok := n.Blockstore.(*gc.TrackingBlockstore)
+	if !ok {
+		return nil, errors.New("incremental garbage collection is not supported by this blockstore")
+	}
+
+	roots, err := BestEffortRoots(n.FilesRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	incrementalGCLk.Lock()
+	if _, ok := incrementalGC[n]; ok {
+		incrementalGCLk.Unlock()
+		return nil, gc.ErrGCRunning
+	}
+	opts.Control = new(gc.Control)
+	incrementalGC[n] = opts.Control
+	incrementalGCLk.Unlock()
+
+	res := gc.IncrementalGC(ctx, tbs, n.Repo.Datastore(), n.Pinning, roots, opts)
+
+	out := make(chan gc.Result)
+	go func() {
+		defer close(out)
+		defer func() {
+			incrementalGCLk.Lock()
+			delete(incrementalGC, n)
+			incrementalGCLk.Unlock()
+		}()
+
+		for r := range res {
+			select {
+			case out <- r:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return out, nil
+}
+
+// PauseGC pauses the incremental garbage collection running on the node.
+func PauseGC(n *core.IpfsNode) error {
+	incrementalGCLk.Lock()
+	defer incrementalGCLk.Unlock()
+	c, ok := incrementalGC[n]
+	if !ok {
+		return ErrNoIncrementalGC
+	}
+	c.Pause()
+	return nil
+}
+
+// ResumeGC resumes the incremental garbage collection paused with PauseGC.
+func ResumeGC(n *core.IpfsNode) error {
+	incrementalGCLk.Lock()
+	defer incrementalGCLk.Unlock()
+	c, ok := incrementalGC[n]
+	if !ok {
+		return ErrNoIncrementalGC
+	}
+	c.Resume()
+	return nil
+}
+
 func PeriodicGC(ctx context.Context, node *core.IpfsNode) error {
 	cfg, err := node.Repo.Config()
 	if err != nil {
@@ -188,6 +280,10 @@ func PeriodicGC(ctx context.Context, node *core.IpfsNode) error {
 		case <-ctx.Done():
 			return nil
 		case <-time.After(period):
+			// don't keep the content of expired pins
+			if _, err := RemoveExpiredPins(node); err != nil {
+				log.Errorf("could not remove expired pins: %s", err)
+			}
 			// the private func maybeGC doesn't compute storageMax, storageGC, slackGC so that they are not re-computed for every cycle
 			if err := gc.maybeGC(ctx, 0); err != nil {
 				log.Error(err)
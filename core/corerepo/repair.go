@@ -0,0 +1,169 @@
+package corerepo
+
+import (
+	"context"
+
+	"github.com/ipfs/go-ipfs/core"
+
+	blocks "github.com/ipfs/go-block-format"
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+// BlockFetcher fetches blocks missing from the blockstore, sending the
+// blocks it finds on the returned channel and closing it once done. The data
+// of the returned blocks is checked against their CIDs.
+type BlockFetcher func(ctx context.Context, cids []cid.Cid) (<-chan blocks.Block, error)
+
+// PinRepair counts the blocks of a pin refetched by RepairPin.
+type PinRepair struct {
+	// Fetched is the number of missing or corrupted blocks fetched again.
+	Fetched int
+
+	// Corrupted is the number of blocks whose data didn't match their CID.
+	// They were removed from the blockstore, and replaced if they could be
+	// fetched.
+	Corrupted int
+
+	// Unrecoverable is the number of blocks which couldn't be fetched. The
+	// blocks they link to are unknown and couldn't be checked.
+	Unrecoverable int
+}
+
+// RepairPin walks the DAG of the recursive pin root, checking that the data of
+// every block matches its CID and fetching the missing and corrupted blocks
+// with fetch. The blocks which can't be fetched are skipped: an error is only
+// returned when the blockstore fails.
+//
+// The DAG is walked level by level, the missing blocks of a level being
+// fetched together. The pin lock is only taken to replace each block, so
+// that a long repair doesn't hold the GC and the other pin operations back.
+func RepairPin(ctx context.Context, n *core.IpfsNode, root cid.Cid, fetch BlockFetcher) (PinRepair, error) {
+	var rep PinRepair
+	getLinks := dag.GetLinksWithDAG(dag.NewDAGService(bserv.New(n.Blockstore, offline.Exchange(n.Blockstore))))
+	visited := cid.NewSet()
+	level := []cid.Cid{root}
+	for len(level) > 0 {
+		var next, missing []cid.Cid
+		corrupted := make(map[cid.Cid]bool)
+		for _, c := range level {
+			if !visited.Visit(c) {
+				continue
+			}
+			present, ok, err := checkBlock(n.Blockstore, c)
+			if err != nil {
+				return rep, err
+			}
+			if !ok {
+				if present {
+					rep.Corrupted++
+					corrupted[c] = true
+				}
+				missing = append(missing, c)
+				continue
+			}
+			links, err := getLinks(ctx, c)
+			if err != nil {
+				log.Warningf("could not decode block %s of pin %s: %s", c, root, err)
+				rep.Unrecoverable++
+				continue
+			}
+			for _, l := range links {
+				next = append(next, l.Cid)
+			}
+		}
+
+		fetched := fetchBlocks(ctx, fetch, missing)
+		for _, c := range missing {
+			blk := fetched[c]
+			if err := replaceBlock(n.Blockstore, c, blk, corrupted[c]); err != nil {
+				return rep, err
+			}
+			if blk == nil {
+				log.Warningf("could not fetch block %s of pin %s", c, root)
+				rep.Unrecoverable++
+				continue
+			}
+			rep.Fetched++
+
+			links, err := getLinks(ctx, c)
+			if err != nil {
+				log.Warningf("could not decode block %s of pin %s: %s", c, root, err)
+				rep.Unrecoverable++
+				continue
+			}
+			for _, l := range links {
+				next = append(next, l.Cid)
+			}
+		}
+		level = next
+	}
+	return rep, nil
+}
+
+// replaceBlock removes the block c from bs if corrupted and stores blk
+// instead if not nil, under the pin lock.
+func replaceBlock(bs bstore.GCBlockstore, c cid.Cid, blk blocks.Block, corrupted bool) error {
+	defer bs.PinLock().Unlock()
+	if corrupted {
+		if err := bs.DeleteBlock(c); err != nil {
+			return err
+		}
+	}
+	if blk == nil {
+		return nil
+	}
+	return bs.Put(blk)
+}
+
+// checkBlock returns whether bs holds the block c, and whether its data
+// matches c.
+func checkBlock(bs bstore.Blockstore, c cid.Cid) (present, ok bool, err error) {
+	blk, err := bs.Get(c)
+	switch err {
+	case nil:
+	case bstore.ErrNotFound:
+		return false, false, nil
+	case bstore.ErrHashMismatch:
+		// the blockstore checks hashes on read
+		return true, false, nil
+	default:
+		return false, false, err
+	}
+	ok, err = blockMatches(blk)
+	return true, ok, err
+}
+
+// fetchBlocks fetches the blocks cids with fetch, dropping the blocks whose
+// data doesn't match their CID.
+func fetchBlocks(ctx context.Context, fetch BlockFetcher, cids []cid.Cid) map[cid.Cid]blocks.Block {
+	fetched := make(map[cid.Cid]blocks.Block, len(cids))
+	if len(cids) == 0 {
+		return fetched
+	}
+	blks, err := fetch(ctx, cids)
+	if err != nil {
+		log.Warningf("could not fetch %d blocks: %s", len(cids), err)
+		return fetched
+	}
+	for blk := range blks {
+		ok, err := blockMatches(blk)
+		if err != nil || !ok {
+			log.Warningf("fetched corrupted block %s", blk.Cid())
+			continue
+		}
+		fetched[blk.Cid()] = blk
+	}
+	return fetched
+}
+
+func blockMatches(blk blocks.Block) (bool, error) {
+	chk, err := blk.Cid().Prefix().Sum(blk.RawData())
+	if err != nil {
+		return false, err
+	}
+	return chk.Equals(blk.Cid()), nil
+}
@@ -0,0 +1,103 @@
+package corerepo
+
+import (
+	"context"
+	"testing"
+
+	coremock "github.com/ipfs/go-ipfs/core/mock"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func TestRepairPin(t *testing.T) {
+	ctx := context.Background()
+	n, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing := dag.NodeWithData([]byte("missing"))
+	corrupted := dag.NodeWithData([]byte("corrupted"))
+	root := dag.NodeWithData([]byte("root"))
+	for _, child := range []*dag.ProtoNode{missing, corrupted} {
+		if err := root.AddNodeLink(string(child.Data()), child); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backup := make(map[cid.Cid]blocks.Block)
+	for _, nd := range []*dag.ProtoNode{root, missing, corrupted} {
+		if err := n.DAG.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		backup[nd.Cid()] = nd
+	}
+	if err := n.Pinning.Pin(ctx, root, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.Blockstore.DeleteBlock(missing.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Blockstore.DeleteBlock(corrupted.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	bad, err := blocks.NewBlockWithCid([]byte("garbage"), corrupted.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Blockstore.Put(bad); err != nil {
+		t.Fatal(err)
+	}
+
+	fetchFrom := func(backup map[cid.Cid]blocks.Block) BlockFetcher {
+		return func(ctx context.Context, cids []cid.Cid) (<-chan blocks.Block, error) {
+			// the GC may run while blocks are fetched
+			n.Blockstore.GCLock().Unlock()
+
+			out := make(chan blocks.Block, len(cids))
+			for _, c := range cids {
+				if blk, ok := backup[c]; ok {
+					out <- blk
+				}
+			}
+			close(out)
+			return out, nil
+		}
+	}
+
+	// without a backup of the missing block, it stays missing
+	partial := make(map[cid.Cid]blocks.Block)
+	for c, blk := range backup {
+		if !c.Equals(missing.Cid()) {
+			partial[c] = blk
+		}
+	}
+	rep, err := RepairPin(ctx, n, root.Cid(), fetchFrom(partial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep != (PinRepair{Fetched: 1, Corrupted: 1, Unrecoverable: 1}) {
+		t.Fatalf("unexpected repair %+v", rep)
+	}
+
+	rep, err = RepairPin(ctx, n, root.Cid(), fetchFrom(backup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep != (PinRepair{Fetched: 1}) {
+		t.Fatalf("unexpected repair %+v", rep)
+	}
+
+	for c := range backup {
+		blk, err := n.Blockstore.Get(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := blockMatches(blk); !ok {
+			t.Fatalf("block %s still corrupted", c)
+		}
+	}
+}
@@ -16,6 +16,12 @@ import (
 type SizeStat struct {
 	RepoSize   uint64 // size in bytes
 	StorageMax uint64 // size in bytes
+
+	// StorageMaxEnforced is set when the writes which would exceed
+	// StorageMax are refused, RejectedWrites counts them since the node
+	// started.
+	StorageMaxEnforced bool   `json:",omitempty"`
+	RejectedWrites     uint64 `json:",omitempty"`
 }
 
 // Stat wraps information about the objects stored on disk.
@@ -52,10 +58,7 @@ func RepoStat(ctx context.Context, n *core.IpfsNode) (Stat, error) {
 	}
 
 	return Stat{
-		SizeStat: SizeStat{
-			RepoSize:   sizeStat.RepoSize,
-			StorageMax: sizeStat.StorageMax,
-		},
+		SizeStat:   sizeStat,
 		NumObjects: count,
 		RepoPath:   path,
 		Version:    fmt.Sprintf("fs-repo@%d", fsrepo.RepoVersion),
@@ -84,8 +87,13 @@ func RepoSize(ctx context.Context, n *core.IpfsNode) (SizeStat, error) {
 		}
 	}
 
-	return SizeStat{
+	stat := SizeStat{
 		RepoSize:   usage,
 		StorageMax: storageMax,
-	}, nil
+	}
+	if n.Quota != nil {
+		stat.StorageMaxEnforced = true
+		stat.RejectedWrites = n.Quota.Rejected()
+	}
+	return stat, nil
 }
@@ -3,9 +3,12 @@ package node
 import (
 	"context"
 	"fmt"
+	"path/filepath"
 
 	"github.com/ipfs/go-ipfs/core/node/helpers"
+	"github.com/ipfs/go-ipfs/denylist"
 	"github.com/ipfs/go-ipfs/pin"
+	pinqueue "github.com/ipfs/go-ipfs/pin/queue"
 	"github.com/ipfs/go-ipfs/repo"
 
 	"github.com/ipfs/go-bitswap"
@@ -40,17 +43,15 @@ func BlockService(lc fx.Lifecycle, bs blockstore.Blockstore, rem exchange.Interf
 
 // Pinning creates new pinner which tells GC which blocks should be kept
 func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo) (pin.Pinner, error) {
+	// the internal DAG is only read to migrate pin sets written by older
+	// versions
 	internalDag := merkledag.NewDAGService(blockservice.New(bstore, offline.Exchange(bstore)))
-	pinning, err := pin.LoadPinner(repo.Datastore(), ds, internalDag)
-	if err != nil {
-		// TODO: we should move towards only running 'NewPinner' explicitly on
-		// node init instead of implicitly here as a result of the pinner keys
-		// not being found in the datastore.
-		// this is kinda sketchy and could cause data loss
-		pinning = pin.NewPinner(repo.Datastore(), ds, internalDag)
-	}
+	return pin.LoadDatastorePinner(repo.Datastore(), ds, internalDag)
+}
 
-	return pinning, nil
+// PinQueue loads the queue of the pins added in the background
+func PinQueue(repo repo.Repo) (*pinqueue.Queue, error) {
+	return pinqueue.New(repo.Datastore())
 }
 
 // Dag creates new DAGService
@@ -58,10 +59,23 @@ func Dag(bs blockservice.BlockService) format.DAGService {
 	return merkledag.NewDAGService(bs)
 }
 
+// Denylist loads the denylists of the repo. Repos without a directory, like
+// mock repos, get an empty denylist kept in memory.
+func Denylist(repo repo.Repo) (*denylist.Denylist, error) {
+	dir := ""
+	if r, ok := repo.(interface{ Path() string }); ok {
+		dir = filepath.Join(r.Path(), denylist.DirName)
+	}
+	return denylist.New(dir)
+}
+
 // OnlineExchange creates new LibP2P backed block exchange (BitSwap)
 func OnlineExchange(provide bool) interface{} {
-	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, host host.Host, rt routing.Routing, bs blockstore.GCBlockstore) exchange.Interface {
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, host host.Host, rt routing.Routing, bs blockstore.GCBlockstore, dl *denylist.Denylist) exchange.Interface {
 		bitswapNetwork := network.NewFromIpfsHost(host, rt)
+		// bitswap serves blocks from the blockstore it's given, hide the
+		// denied ones from it
+		bs = &denylist.Blockstore{GCBlockstore: bs, Denylist: dl}
 		exch := bitswap.New(helpers.LifecycleCtx(mctx, lc), bitswapNetwork, bs, bitswap.ProvideEnabled(provide))
 		lc.Append(fx.Hook{
 			OnStop: func(ctx context.Context) error {
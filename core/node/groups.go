@@ -9,11 +9,13 @@ import (
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	"github.com/ipfs/go-ipfs-config"
 	util "github.com/ipfs/go-ipfs-util"
+	crypto "github.com/libp2p/go-libp2p-core/crypto"
 	peer "github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 
 	"github.com/ipfs/go-ipfs/core/node/libp2p"
+	"github.com/ipfs/go-ipfs/keystore"
 	"github.com/ipfs/go-ipfs/p2p"
 
 	offline "github.com/ipfs/go-ipfs-exchange-offline"
@@ -134,13 +136,16 @@ func Storage(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 	return fx.Options(
 		fx.Provide(RepoConfig),
 		fx.Provide(Datastore),
+		fx.Provide(StorageQuota),
 		fx.Provide(BaseBlockstoreCtor(cacheOpts, bcfg.NilRepo, cfg.Datastore.HashOnRead)),
 		finalBstore,
 	)
 }
 
-// Identity groups units providing cryptographic identity
-func Identity(cfg *config.Config) fx.Option {
+// Identity groups units providing cryptographic identity. The private key is
+// read from the config, or from the keystore if it has been moved to an
+// encrypted one.
+func Identity(cfg *config.Config, ks keystore.Keystore) fx.Option {
 	// PeerID
 
 	cid := cfg.Identity.PeerID
@@ -158,18 +163,26 @@ func Identity(cfg *config.Config) fx.Option {
 
 	// Private Key
 
-	if cfg.Identity.PrivKey == "" {
+	var sk crypto.PrivKey
+	if cfg.Identity.PrivKey != "" {
+		sk, err = cfg.Identity.DecodePrivateKey("")
+		if err != nil {
+			return fx.Error(err)
+		}
+	} else if eks, ok := ks.(*keystore.EncryptedKeystore); ok {
+		sk, err = eks.Identity()
+		if err != nil && err != keystore.ErrNoSuchKey {
+			return fx.Error(fmt.Errorf("cannot read identity from keystore: %s", err))
+		}
+	}
+
+	if sk == nil {
 		return fx.Options( // No PK (usually in tests)
 			fx.Provide(PeerID(id)),
 			fx.Provide(pstoremem.NewPeerstore),
 		)
 	}
 
-	sk, err := cfg.Identity.DecodePrivateKey("passphrase todo!")
-	if err != nil {
-		return fx.Error(err)
-	}
-
 	return fx.Options( // Full identity
 		fx.Provide(PeerID(id)),
 		fx.Provide(PrivateKey(sk)),
@@ -251,11 +264,14 @@ func Offline(cfg *config.Config) fx.Option {
 
 // Core groups basic IPFS services
 var Core = fx.Options(
+	fx.Provide(Denylist),
 	fx.Provide(BlockService),
 	fx.Provide(Dag),
 	fx.Provide(resolver.NewBasicResolver),
 	fx.Provide(Pinning),
+	fx.Provide(PinQueue),
 	fx.Provide(Files),
+	fx.Invoke(QuotaGC),
 )
 
 func Networked(bcfg *BuildCfg, cfg *config.Config) fx.Option {
@@ -285,7 +301,7 @@ func IPFS(ctx context.Context, bcfg *BuildCfg) fx.Option {
 		fx.Provide(baseProcess),
 
 		Storage(bcfg, cfg),
-		Identity(cfg),
+		Identity(cfg, bcfg.Repo.Keystore()),
 		IPNS,
 		Networked(bcfg, cfg),
 
@@ -1,18 +1,26 @@
 package node
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"syscall"
 	"time"
 
+	humanize "github.com/dustin/go-humanize"
+	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/retrystore"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	config "github.com/ipfs/go-ipfs-config"
+	"github.com/ipfs/go-mfs"
 	"go.uber.org/fx"
 
 	"github.com/ipfs/go-filestore"
 	"github.com/ipfs/go-ipfs/core/node/helpers"
+	"github.com/ipfs/go-ipfs/pin"
+	"github.com/ipfs/go-ipfs/pin/gc"
+	"github.com/ipfs/go-ipfs/quota"
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/thirdparty/cidv0v1"
 	"github.com/ipfs/go-ipfs/thirdparty/verifbs"
@@ -71,23 +79,76 @@ func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead
 	}
 }
 
+// EnforceStorageMaxConfigKey is the config key enabling the enforcement of
+// Datastore.StorageMax.
+const EnforceStorageMaxConfigKey = "Datastore.EnforceStorageMax"
+
+// StorageQuota provides the quota enforcing Datastore.StorageMax, or nil if it
+// isn't enforced. Repos without a directory, like mock repos, are not limited.
+func StorageQuota(r repo.Repo, cfg *config.Config) (*quota.Quota, error) {
+	if _, ok := r.(interface{ Path() string }); !ok || cfg.Datastore.StorageMax == "" {
+		return nil, nil
+	}
+
+	var enforce bool
+	if _, err := repo.ReadExtendedConfig(r, EnforceStorageMaxConfigKey, &enforce); err != nil {
+		return nil, err
+	}
+	if !enforce {
+		return nil, nil
+	}
+
+	max, err := humanize.ParseBytes(cfg.Datastore.StorageMax)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Datastore.StorageMax: %s", err)
+	}
+	return quota.New(max, r.GetStorageUsage)
+}
+
+// QuotaGC lets the storage quota collect garbage when the repo is full.
+func QuotaGC(q *quota.Quota, bs blockstore.GCBlockstore, repo repo.Repo, pinning pin.Pinner, files *mfs.Root) {
+	if q == nil {
+		return
+	}
+	q.SetGC(func(ctx context.Context) error {
+		root, err := files.GetDirectory().GetNode()
+		if err != nil {
+			return err
+		}
+		for res := range gc.GC(ctx, bs, repo.Datastore(), pinning, []cid.Cid{root.Cid()}) {
+			if res.Error != nil {
+				return res.Error
+			}
+		}
+		return nil
+	})
+}
+
 // GcBlockstoreCtor wraps the base blockstore with GC and Filestore layers
-func GcBlockstoreCtor(bb BaseBlocks) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore) {
+func GcBlockstoreCtor(bb BaseBlocks, q *quota.Quota) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore) {
 	gclocker = blockstore.NewGCLocker()
 	gcbs = blockstore.NewGCBlockstore(bb, gclocker)
+	if q != nil {
+		gcbs = &quota.Blockstore{GCBlockstore: gcbs, Quota: q}
+	}
+	gcbs = gc.NewTrackingBlockstore(gcbs)
 
 	bs = gcbs
 	return
 }
 
 // GcBlockstoreCtor wraps GcBlockstore and adds Filestore support
-func FilestoreBlockstoreCtor(repo repo.Repo, bb BaseBlocks) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore, fstore *filestore.Filestore) {
+func FilestoreBlockstoreCtor(repo repo.Repo, bb BaseBlocks, q *quota.Quota) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore, fstore *filestore.Filestore) {
 	gclocker = blockstore.NewGCLocker()
 
 	// hash security
 	fstore = filestore.NewFilestore(bb, repo.FileManager())
 	gcbs = blockstore.NewGCBlockstore(fstore, gclocker)
 	gcbs = &verifbs.VerifBSGC{GCBlockstore: gcbs}
+	if q != nil {
+		gcbs = &quota.Blockstore{GCBlockstore: gcbs, Quota: q}
+	}
+	gcbs = gc.NewTrackingBlockstore(gcbs)
 
 	bs = gcbs
 	return
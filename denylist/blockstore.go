@@ -0,0 +1,66 @@
+package denylist
+
+import (
+	"context"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// Blockstore hides the blocks denied by a Denylist. It is handed to bitswap
+// so that the node never sends denied blocks to its peers, while still
+// storing the blocks it fetches. Only the denied CIDs themselves are hidden,
+// as a block doesn't tell which DAGs it belongs to.
+type Blockstore struct {
+	bstore.GCBlockstore
+	Denylist *Denylist
+}
+
+func (bs *Blockstore) Has(c cid.Cid) (bool, error) {
+	if bs.Denylist.IsDenied(c) {
+		return false, nil
+	}
+	return bs.GCBlockstore.Has(c)
+}
+
+func (bs *Blockstore) Get(c cid.Cid) (blocks.Block, error) {
+	if bs.Denylist.IsDenied(c) {
+		return nil, bstore.ErrNotFound
+	}
+	return bs.GCBlockstore.Get(c)
+}
+
+func (bs *Blockstore) GetSize(c cid.Cid) (int, error) {
+	if bs.Denylist.IsDenied(c) {
+		return -1, bstore.ErrNotFound
+	}
+	return bs.GCBlockstore.GetSize(c)
+}
+
+// NodeGetter fails to get the nodes denied by a Denylist with ErrDenied. It
+// guards DAG traversals serving whole DAGs, like CAR exports.
+type NodeGetter struct {
+	ipld.NodeGetter
+	Denylist *Denylist
+}
+
+func (ng *NodeGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	if ng.Denylist.IsDenied(c) {
+		return nil, ErrDenied
+	}
+	return ng.NodeGetter.Get(ctx, c)
+}
+
+func (ng *NodeGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	for _, c := range cids {
+		if ng.Denylist.IsDenied(c) {
+			out := make(chan *ipld.NodeOption, 1)
+			out <- &ipld.NodeOption{Err: ErrDenied}
+			close(out)
+			return out
+		}
+	}
+	return ng.NodeGetter.GetMany(ctx, cids)
+}
@@ -0,0 +1,407 @@
+// Package denylist implements the lists of content a node refuses to serve,
+// fetch for the gateway or pin.
+//
+// Denylists are read from the files with the FileExt extension in the DirName
+// directory of the repo. Each line of a denylist holds one entry:
+//
+//	/ipfs/<cid>         denies the CID and every path under it
+//	/ipfs/<cid>/<path>  denies the path under the CID and every path below it
+//	//<sha256>          a hashed entry, see HashEntry
+//
+// Empty lines and lines starting with '#' are ignored. CIDs match by
+// multihash, so that an entry denies the content whatever the version and
+// codec of the CID it is requested with.
+package denylist
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-ipfs/namesys"
+	"github.com/ipfs/go-ipfs/namesys/resolve"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	path "github.com/ipfs/go-path"
+	"github.com/ipfs/go-path/resolver"
+	uio "github.com/ipfs/go-unixfs/io"
+	mbase "github.com/multiformats/go-multibase"
+)
+
+const (
+	// DirName is the directory of the repo holding the denylists.
+	DirName = "denylists"
+
+	// FileExt is the extension of denylist files.
+	FileExt = ".deny"
+
+	// LocalFile is the denylist Add appends to.
+	LocalFile = "local" + FileExt
+)
+
+// hashPrefix prefixes hashed entries.
+const hashPrefix = "//"
+
+// ErrDenied is returned when accessing denied content.
+var ErrDenied = errors.New("content is blocked by a denylist")
+
+// Entry is an entry of a denylist.
+type Entry struct {
+	// Cid and Path are the denied CID and the path under it, which is empty
+	// when the whole CID is denied. Cid is undefined for hashed entries.
+	Cid  cid.Cid
+	Path string
+
+	// Hash is the hex encoded hash of a hashed entry.
+	Hash string
+
+	// Source is the name of the file the entry was read from.
+	Source string
+}
+
+// ParseEntry parses an entry of a denylist.
+func ParseEntry(s string) (Entry, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, hashPrefix) {
+		h := strings.ToLower(s[len(hashPrefix):])
+		if b, err := hex.DecodeString(h); err != nil || len(b) != sha256.Size {
+			return Entry{}, fmt.Errorf("invalid hashed denylist entry %q: expected a hex encoded SHA-256 hash", s)
+		}
+		return Entry{Hash: h}, nil
+	}
+
+	p := strings.Trim(strings.TrimPrefix(s, "/ipfs/"), "/")
+	segs := strings.SplitN(p, "/", 2)
+	c, err := cid.Decode(segs[0])
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid denylist entry %q: %s", s, err)
+	}
+	e := Entry{Cid: c}
+	if len(segs) > 1 {
+		e.Path = cleanPath(segs[1])
+	}
+	return e, nil
+}
+
+// String returns the entry as written in a denylist.
+func (e Entry) String() string {
+	if e.Hash != "" {
+		return hashPrefix + e.Hash
+	}
+	if e.Path == "" {
+		return "/ipfs/" + e.Cid.String()
+	}
+	return "/ipfs/" + e.Cid.String() + "/" + e.Path
+}
+
+// HashEntry returns the hashed entry denying the path p under c. Hashed
+// entries let denylists be shared without listing the content they deny. The
+// hash is the SHA-256 hash of the base32 CIDv1 of c, a slash and p.
+func HashEntry(c cid.Cid, p string) Entry {
+	return Entry{Hash: hashKey(c, cleanPath(p))}
+}
+
+func hashKey(c cid.Cid, p string) string {
+	if c.Version() == 0 {
+		c = cid.NewCidV1(cid.DagProtobuf, c.Hash())
+	}
+	s, err := c.StringOfBase(mbase.Base32)
+	if err != nil {
+		// base32 is always supported
+		panic(err)
+	}
+	h := sha256.Sum256([]byte(s + "/" + p))
+	return hex.EncodeToString(h[:])
+}
+
+func plainKey(c cid.Cid, p string) string {
+	return string(c.Hash()) + "/" + p
+}
+
+func cleanPath(p string) string {
+	var segs []string
+	for _, s := range strings.Split(p, "/") {
+		if s != "" {
+			segs = append(segs, s)
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// Denylist holds the entries of the denylists of a repo.
+type Denylist struct {
+	dir string
+
+	lk      sync.RWMutex
+	entries []Entry
+	byKey   map[string]Entry
+}
+
+// New returns the Denylist read from the denylist files in dir. If dir is
+// empty, the denylist is only kept in memory.
+func New(dir string) (*Denylist, error) {
+	d := &Denylist{dir: dir, byKey: make(map[string]Entry)}
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Reload reads the denylist files again.
+func (d *Denylist) Reload() error {
+	if d.dir == "" {
+		return nil
+	}
+
+	entries, err := readDir(d.dir)
+	if err != nil {
+		return err
+	}
+
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.set(entries)
+	return nil
+}
+
+func (d *Denylist) set(entries []Entry) {
+	byKey := make(map[string]Entry, len(entries))
+	for _, e := range entries {
+		if e.Hash != "" {
+			byKey[e.Hash] = e
+		} else {
+			byKey[plainKey(e.Cid, e.Path)] = e
+		}
+	}
+	d.entries = entries
+	d.byKey = byKey
+}
+
+func readDir(dir string) ([]Entry, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+FileExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var entries []Entry
+	for _, name := range names {
+		fe, err := readFile(name)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fe...)
+	}
+	return entries, nil
+}
+
+func readFile(name string) ([]Entry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		e, err := ParseEntry(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", name, line, err)
+		}
+		e.Source = filepath.Base(name)
+		entries = append(entries, e)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %s", name, err)
+	}
+	return entries, nil
+}
+
+// Entries returns the entries of the denylist.
+func (d *Denylist) Entries() []Entry {
+	if d == nil {
+		return nil
+	}
+
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	return append([]Entry(nil), d.entries...)
+}
+
+// Add appends the entries to the LocalFile denylist and reloads the
+// denylists.
+func (d *Denylist) Add(entries ...Entry) error {
+	if d.dir == "" {
+		d.lk.Lock()
+		defer d.lk.Unlock()
+		d.set(append(append([]Entry(nil), d.entries...), entries...))
+		return nil
+	}
+
+	var b strings.Builder
+	for _, e := range entries {
+		b.WriteString(e.String())
+		b.WriteByte('\n')
+	}
+
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return err
+	}
+	// serialize writers, Reload takes the lock again
+	d.lk.Lock()
+	err := appendFile(filepath.Join(d.dir, LocalFile), b.String())
+	d.lk.Unlock()
+	if err != nil {
+		return err
+	}
+	return d.Reload()
+}
+
+func appendFile(name, data string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Match returns the entry denying the path segments under c, if any. An entry
+// denying a path also denies the paths below it.
+func (d *Denylist) Match(c cid.Cid, segments ...string) (Entry, bool) {
+	if d == nil {
+		return Entry{}, false
+	}
+
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	if len(d.byKey) == 0 {
+		return Entry{}, false
+	}
+
+	prefixes := []string{""}
+	p := ""
+	for _, s := range segments {
+		if s == "" {
+			continue
+		}
+		if p != "" {
+			p += "/"
+		}
+		p += s
+		prefixes = append(prefixes, p)
+	}
+
+	for _, p := range prefixes {
+		if e, ok := d.byKey[plainKey(c, p)]; ok {
+			return e, true
+		}
+		if e, ok := d.byKey[hashKey(c, p)]; ok {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+func (d *Denylist) empty() bool {
+	if d == nil {
+		return true
+	}
+
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	return len(d.byKey) == 0
+}
+
+// IsDenied returns whether the path segments under c are denied.
+func (d *Denylist) IsDenied(c cid.Cid, segments ...string) bool {
+	_, ok := d.Match(c, segments...)
+	return ok
+}
+
+// MatchPath returns the entry denying the /ipfs/ path p, if any.
+func (d *Denylist) MatchPath(p path.Path) (Entry, bool, error) {
+	c, segs, err := path.SplitAbsPath(p)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	e, ok := d.Match(c, segs...)
+	return e, ok, nil
+}
+
+// CheckPath returns ErrDenied if p is denied. The IPNS name p may start with
+// is resolved with nsys, then p is resolved with ng one link at a time, so
+// that a denied CID or path is also refused when reached through a parent
+// directory. Failing to resolve p further isn't an error: the caller resolves
+// p itself and reports it. As the last node p resolves to may only be
+// reachable by the caller, its CID should be checked as well.
+func (d *Denylist) CheckPath(ctx context.Context, nsys namesys.NameSystem, ng ipld.NodeGetter, p path.Path) error {
+	if d.empty() {
+		// don't resolve names for nothing
+		return nil
+	}
+
+	p, err := resolve.ResolveIPNS(ctx, nsys, p)
+	if err != nil {
+		return err
+	}
+	c, segs, err := path.SplitAbsPath(p)
+	if err != nil {
+		return err
+	}
+	if d.IsDenied(c, segs...) {
+		return ErrDenied
+	}
+	if len(segs) == 0 {
+		return nil
+	}
+
+	var resolveOnce resolver.ResolveOnce = uio.ResolveUnixfsOnce
+	if p.Segments()[0] == "ipld" {
+		resolveOnce = resolver.ResolveSingle
+	}
+
+	nd, err := ng.Get(ctx, c)
+	if err != nil {
+		return nil
+	}
+	for len(segs) > 0 {
+		lnk, rest, err := resolveOnce(ctx, ng, nd, segs)
+		if err != nil || lnk == nil {
+			return nil
+		}
+		if d.IsDenied(lnk.Cid, rest...) {
+			return ErrDenied
+		}
+		if nd, err = lnk.GetNode(ctx, ng); err != nil {
+			return nil
+		}
+		segs = rest
+	}
+	return nil
+}
+
+// Check returns ErrDenied if c is denied.
+func (d *Denylist) Check(c cid.Cid) error {
+	if d.IsDenied(c) {
+		return ErrDenied
+	}
+	return nil
+}
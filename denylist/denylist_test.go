@@ -0,0 +1,290 @@
+package denylist
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	mdtest "github.com/ipfs/go-merkledag/test"
+	path "github.com/ipfs/go-path"
+	ft "github.com/ipfs/go-unixfs"
+)
+
+const testCid = "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn"
+
+func TestParseEntry(t *testing.T) {
+	c, err := cid.Decode(testCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := HashEntry(c, "a/b").String()
+
+	for _, test := range []struct {
+		in, out string
+	}{
+		{"/ipfs/" + testCid, "/ipfs/" + testCid},
+		{testCid, "/ipfs/" + testCid},
+		{"/ipfs/" + testCid + "/a//b/", "/ipfs/" + testCid + "/a/b"},
+		{" " + hashed + " ", hashed},
+	} {
+		e, err := ParseEntry(test.in)
+		if err != nil {
+			t.Errorf("%q: %s", test.in, err)
+			continue
+		}
+		if e.String() != test.out {
+			t.Errorf("%q: got %q, expected %q", test.in, e.String(), test.out)
+		}
+	}
+
+	for _, in := range []string{"", "/ipfs/", "/ipfs/notacid", "//abcd", "//" + testCid} {
+		if _, err := ParseEntry(in); err == nil {
+			t.Errorf("expected %q to be invalid", in)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	d, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := cid.Decode(testCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := blocks.NewBlock([]byte("other")).Cid()
+	hashed := blocks.NewBlock([]byte("hashed")).Cid()
+
+	err = d.Add(
+		Entry{Cid: root, Path: "a/b"},
+		Entry{Cid: other},
+		HashEntry(hashed, "x"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same content as CIDv1
+	rootV1 := cid.NewCidV1(cid.DagProtobuf, root.Hash())
+
+	for _, test := range []struct {
+		c      cid.Cid
+		path   []string
+		denied bool
+	}{
+		{root, nil, false},
+		{root, []string{"a"}, false},
+		{root, []string{"a", "b"}, true},
+		{root, []string{"a", "b", "c"}, true},
+		{rootV1, []string{"a", "b"}, true},
+		{root, []string{"a", "bc"}, false},
+		{other, nil, true},
+		{other, []string{"a"}, true},
+		{hashed, nil, false},
+		{hashed, []string{"x"}, true},
+		{hashed, []string{"x", "y"}, true},
+		{hashed, []string{"y"}, false},
+	} {
+		if d.IsDenied(test.c, test.path...) != test.denied {
+			t.Errorf("%s %v: expected denied to be %t", test.c, test.path, test.denied)
+		}
+	}
+
+	_, denied, err := d.MatchPath(path.Path("/ipfs/" + testCid + "/a/b/c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !denied {
+		t.Error("expected the path to be denied")
+	}
+
+	var nilDenylist *Denylist
+	if nilDenylist.IsDenied(root) {
+		t.Error("expected a nil denylist to deny nothing")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "denylist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := cid.Decode(testCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "badbits.deny"), []byte("# comment\n\n/ipfs/"+testCid+"/a\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// files with other extensions are ignored
+	err = ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not an entry\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := d.Match(c, "a")
+	if !ok {
+		t.Fatal("expected the entry to be loaded")
+	}
+	if e.Source != "badbits.deny" {
+		t.Errorf("unexpected source %q", e.Source)
+	}
+
+	if err := d.Add(Entry{Cid: c, Path: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if !d.IsDenied(c, "b") {
+		t.Error("expected the added entry to be denied")
+	}
+	if _, err := os.Stat(filepath.Join(dir, LocalFile)); err != nil {
+		t.Fatal(err)
+	}
+
+	// entries are reloaded from the files
+	if err := os.Remove(filepath.Join(dir, "badbits.deny")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if d.IsDenied(c, "a") {
+		t.Error("expected the removed entry to be allowed")
+	}
+	if len(d.Entries()) != 1 {
+		t.Errorf("expected 1 entry, got %d", len(d.Entries()))
+	}
+
+	// an invalid entry fails the reload and keeps the entries loaded
+	err = ioutil.WriteFile(filepath.Join(dir, "broken.deny"), []byte("/ipfs/notacid\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Reload(); err == nil {
+		t.Error("expected an invalid entry to fail")
+	}
+	if !d.IsDenied(c, "b") {
+		t.Error("expected the entries to be kept")
+	}
+}
+
+func TestBlockstore(t *testing.T) {
+	base := bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	d, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := &Blockstore{GCBlockstore: bstore.NewGCBlockstore(base, bstore.NewGCLocker()), Denylist: d}
+
+	good := blocks.NewBlock([]byte("good"))
+	bad := blocks.NewBlock([]byte("bad"))
+	if err := bs.PutMany([]blocks.Block{good, bad}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Add(Entry{Cid: bad.Cid()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if has, _ := bs.Has(good.Cid()); !has {
+		t.Error("expected the allowed block to be found")
+	}
+	if has, _ := bs.Has(bad.Cid()); has {
+		t.Error("expected the denied block to be hidden")
+	}
+	if _, err := bs.Get(bad.Cid()); err != bstore.ErrNotFound {
+		t.Errorf("expected %s, got %v", bstore.ErrNotFound, err)
+	}
+	if _, err := bs.GetSize(bad.Cid()); err != bstore.ErrNotFound {
+		t.Errorf("expected %s, got %v", bstore.ErrNotFound, err)
+	}
+
+	// the block is still stored
+	if has, _ := base.Has(bad.Cid()); !has {
+		t.Error("expected the denied block to be stored")
+	}
+}
+
+func TestCheckPath(t *testing.T) {
+	ctx := context.Background()
+	dserv := mdtest.Mock()
+
+	file := dag.NodeWithData([]byte("file"))
+	dir := ft.EmptyDirNode()
+	if err := dir.AddNodeLink("file", file); err != nil {
+		t.Fatal(err)
+	}
+	parent := ft.EmptyDirNode()
+	if err := parent.AddNodeLink("dir", dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := dserv.AddMany(ctx, []ipld.Node{file, dir, parent}); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(p string) error {
+		return d.CheckPath(ctx, nil, dserv, path.Path(p))
+	}
+
+	if err := check("/ipfs/" + parent.Cid().String() + "/dir/file"); err != nil {
+		t.Fatalf("expected the path to be allowed, got %v", err)
+	}
+
+	// a denied CID is refused when reached through its parent
+	if err := d.Add(Entry{Cid: dir.Cid()}); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{
+		"/ipfs/" + dir.Cid().String(),
+		"/ipfs/" + parent.Cid().String() + "/dir",
+		"/ipfs/" + parent.Cid().String() + "/dir/file",
+	} {
+		if err := check(p); err != ErrDenied {
+			t.Errorf("%s: expected %s, got %v", p, ErrDenied, err)
+		}
+	}
+	if err := check("/ipfs/" + parent.Cid().String()); err != nil {
+		t.Errorf("expected the parent to be allowed, got %v", err)
+	}
+
+	// so is a path denied under a CID
+	d, err = New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Add(Entry{Cid: dir.Cid(), Path: "file"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := check("/ipfs/" + parent.Cid().String() + "/dir/file"); err != ErrDenied {
+		t.Errorf("expected %s, got %v", ErrDenied, err)
+	}
+	if err := check("/ipfs/" + parent.Cid().String() + "/dir"); err != nil {
+		t.Errorf("expected the directory to be allowed, got %v", err)
+	}
+
+	// missing links are left to the caller to report
+	if err := check("/ipfs/" + parent.Cid().String() + "/missing"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,358 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	ci "github.com/libp2p/go-libp2p-core/crypto"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// markerFile marks a keystore directory as encrypted. It holds a
+	// known value encrypted with the passphrase, which allows checking the
+	// passphrase when the keystore is opened.
+	markerFile = ".encrypted"
+
+	// identityFile holds the encrypted private key of the node identity.
+	identityFile = ".identity"
+
+	markerValue = "ipfs keystore"
+)
+
+// scrypt parameters used for new key files
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// encryptedKeyMagic prefixes every encrypted key file.
+var encryptedKeyMagic = []byte("ipfs-encrypted-key\n")
+
+// ErrWrongPassphrase is returned when opening an encrypted keystore with a
+// passphrase it wasn't encrypted with.
+var ErrWrongPassphrase = errors.New("wrong keystore passphrase")
+
+// encryptedKey is the content of an encrypted key file, following
+// encryptedKeyMagic. The key is derived from the passphrase with scrypt and
+// Data is sealed with AES-256-GCM, using the key name as additional data.
+type encryptedKey struct {
+	N, R, P int
+	Salt    []byte
+	Nonce   []byte
+	Data    []byte
+}
+
+// EncryptedKeystore is a keystore backed by files in a given directory, each
+// of them encrypted with a key derived from a passphrase.
+type EncryptedKeystore struct {
+	dir        string
+	passphrase []byte
+
+	lk      sync.Mutex
+	salt    []byte            // salt used for keys written by this instance
+	derived map[string][]byte // derived keys by scrypt parameters
+}
+
+var _ Keystore = (*EncryptedKeystore)(nil)
+
+// IsEncrypted returns whether the keystore in dir is encrypted.
+func IsEncrypted(dir string) (bool, error) {
+	_, err := os.Stat(filepath.Join(dir, markerFile))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// OpenEncryptedKeystore opens the encrypted keystore in dir, returning
+// ErrWrongPassphrase if it wasn't encrypted with passphrase.
+func OpenEncryptedKeystore(dir string, passphrase []byte) (*EncryptedKeystore, error) {
+	ks := newEncryptedKeystore(dir, passphrase)
+
+	data, err := ks.readFile(markerFile)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(data, []byte(markerValue)) {
+		return nil, ErrWrongPassphrase
+	}
+
+	return ks, nil
+}
+
+// EncryptFSKeystore encrypts the plain keys of the keystore in dir with
+// passphrase and returns the encrypted keystore. It may be run again on a
+// keystore it has already encrypted, for example after being interrupted.
+func EncryptFSKeystore(dir string, passphrase []byte) (*EncryptedKeystore, error) {
+	encrypted, err := IsEncrypted(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ks *EncryptedKeystore
+	if encrypted {
+		ks, err = OpenEncryptedKeystore(dir, passphrase)
+	} else {
+		ks = newEncryptedKeystore(dir, passphrase)
+		err = ks.writeFile(markerFile, []byte(markerValue))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := ks.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(data, encryptedKeyMagic) {
+			continue
+		}
+
+		if _, err := ci.UnmarshalPrivateKey(data); err != nil {
+			return nil, fmt.Errorf("cannot encrypt key %s: %s", name, err)
+		}
+		if err := ks.writeFile(name, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return ks, nil
+}
+
+func newEncryptedKeystore(dir string, passphrase []byte) *EncryptedKeystore {
+	return &EncryptedKeystore{
+		dir:        dir,
+		passphrase: passphrase,
+		derived:    make(map[string][]byte),
+	}
+}
+
+// Has returns whether or not a key exist in the Keystore
+func (ks *EncryptedKeystore) Has(name string) (bool, error) {
+	return (&FSKeystore{ks.dir}).Has(name)
+}
+
+// Put stores a key in the Keystore, if a key with the same name already exists, returns ErrKeyExists
+func (ks *EncryptedKeystore) Put(name string, k ci.PrivKey) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+
+	b, err := k.Bytes()
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(filepath.Join(ks.dir, name))
+	if err == nil {
+		return ErrKeyExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return ks.writeFile(name, b)
+}
+
+// Get retrieves a key from the Keystore if it exists, and returns ErrNoSuchKey
+// otherwise.
+func (ks *EncryptedKeystore) Get(name string) (ci.PrivKey, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+
+	data, err := ks.readFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return ci.UnmarshalPrivateKey(data)
+}
+
+// Delete removes a key from the Keystore
+func (ks *EncryptedKeystore) Delete(name string) error {
+	return (&FSKeystore{ks.dir}).Delete(name)
+}
+
+// List return a list of key identifier
+func (ks *EncryptedKeystore) List() ([]string, error) {
+	return (&FSKeystore{ks.dir}).List()
+}
+
+// Identity returns the private key of the node identity, or ErrNoSuchKey if
+// it isn't kept in the keystore.
+func (ks *EncryptedKeystore) Identity() (ci.PrivKey, error) {
+	data, err := ks.readFile(identityFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return ci.UnmarshalPrivateKey(data)
+}
+
+// PutIdentity stores the private key of the node identity, replacing any
+// previous one.
+func (ks *EncryptedKeystore) PutIdentity(k ci.PrivKey) error {
+	b, err := k.Bytes()
+	if err != nil {
+		return err
+	}
+
+	return ks.writeFile(identityFile, b)
+}
+
+// readFile reads and decrypts the given file of the keystore.
+func (ks *EncryptedKeystore) readFile(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(ks.dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoSuchKey
+		}
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, encryptedKeyMagic) {
+		return nil, fmt.Errorf("key %s is not encrypted, run 'ipfs key encrypt' to encrypt it", name)
+	}
+
+	var ek encryptedKey
+	if err := json.Unmarshal(data[len(encryptedKeyMagic):], &ek); err != nil {
+		return nil, fmt.Errorf("invalid encrypted key %s: %s", name, err)
+	}
+
+	key, err := ks.deriveKey(ek.Salt, ek.N, ek.R, ek.P)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ek.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid encrypted key %s: bad nonce size", name)
+	}
+
+	out, err := aead.Open(nil, ek.Nonce, ek.Data, []byte(name))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return out, nil
+}
+
+// writeFile encrypts data and atomically writes it to the given file of the
+// keystore.
+func (ks *EncryptedKeystore) writeFile(name string, data []byte) error {
+	salt, err := ks.writeSalt()
+	if err != nil {
+		return err
+	}
+
+	key, err := ks.deriveKey(salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(&encryptedKey{
+		N:     scryptN,
+		R:     scryptR,
+		P:     scryptP,
+		Salt:  salt,
+		Nonce: nonce,
+		Data:  aead.Seal(nil, nonce, data, []byte(name)),
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(ks.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(encryptedKeyMagic); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(ks.dir, name))
+}
+
+// writeSalt returns the salt used for new key files, so that the key is only
+// derived once per keystore instance.
+func (ks *EncryptedKeystore) writeSalt() ([]byte, error) {
+	ks.lk.Lock()
+	defer ks.lk.Unlock()
+
+	if ks.salt == nil {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		ks.salt = salt
+	}
+	return ks.salt, nil
+}
+
+// deriveKey derives the key for the given scrypt parameters from the
+// passphrase. Derived keys are cached as scrypt is slow by design.
+func (ks *EncryptedKeystore) deriveKey(salt []byte, n, r, p int) ([]byte, error) {
+	ks.lk.Lock()
+	defer ks.lk.Unlock()
+
+	params := fmt.Sprintf("%d/%d/%d/%x", n, r, p, salt)
+	if key, ok := ks.derived[params]; ok {
+		return key, nil
+	}
+
+	key, err := scrypt.Key(ks.passphrase, salt, n, r, p, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	ks.derived[params] = key
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,74 @@
+package keystore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestEncryptedKeystore(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "keystore-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tdir)
+
+	plain, err := NewFSKeystore(tdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k1 := privKeyOrFatal(t)
+	k2 := privKeyOrFatal(t)
+	if err := plain.Put("foo", k1); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := EncryptFSKeystore(tdir, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Put("bar", k2); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.PutIdentity(k2); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := plain.Get("foo"); err == nil {
+		t.Fatal("expected encrypted key to be unreadable without passphrase")
+	}
+
+	if _, err := OpenEncryptedKeystore(tdir, []byte("wrong")); err != ErrWrongPassphrase {
+		t.Fatalf("expected ErrWrongPassphrase, got %v", err)
+	}
+
+	ks, err = OpenEncryptedKeystore(tdir, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := ks.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 2 {
+		t.Fatalf("expected 2 keys, got %v", l)
+	}
+
+	got, err := ks.Get("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equals(k1) {
+		t.Fatal("migrated key changed")
+	}
+
+	got, err = ks.Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equals(k2) {
+		t.Fatal("identity changed")
+	}
+}
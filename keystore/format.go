@@ -0,0 +1,143 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+
+	ci "github.com/libp2p/go-libp2p-core/crypto"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Formats supported by MarshalPrivateKey
+const (
+	// FormatProtobuf is the libp2p protobuf encoding used by the keystore.
+	FormatProtobuf = "libp2p-protobuf-cleartext"
+
+	// FormatPEM is an unencrypted PKCS#8 private key in a PEM block.
+	FormatPEM = "pem-pkcs8-cleartext"
+)
+
+const pemPrivateKeyType = "PRIVATE KEY"
+
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// pkcs8 is the ASN.1 structure of a PKCS#8 private key, see RFC 5208. It is
+// only used for Ed25519 keys, which aren't supported by crypto/x509.
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// ValidateName returns an error if name can't be used as the name of a key.
+func ValidateName(name string) error {
+	return validateName(name)
+}
+
+// MarshalPrivateKey encodes k in the given format.
+func MarshalPrivateKey(k ci.PrivKey, format string) ([]byte, error) {
+	switch format {
+	case FormatProtobuf:
+		return k.Bytes()
+	case FormatPEM:
+		der, err := marshalPKCS8(k)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: pemPrivateKeyType, Bytes: der}), nil
+	default:
+		return nil, fmt.Errorf("unknown key format %q, must be %s or %s", format, FormatProtobuf, FormatPEM)
+	}
+}
+
+// UnmarshalPrivateKey decodes a private key encoded in any of the formats
+// supported by MarshalPrivateKey.
+func UnmarshalPrivateKey(data []byte) (ci.PrivKey, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		return ci.UnmarshalPrivateKey(data)
+	}
+
+	block, _ := pem.Decode(bytes.TrimSpace(data))
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM data")
+	}
+	if block.Type != pemPrivateKeyType {
+		return nil, fmt.Errorf("unsupported PEM block %q, expected %q", block.Type, pemPrivateKeyType)
+	}
+	if _, encrypted := block.Headers["Proc-Type"]; encrypted {
+		return nil, fmt.Errorf("encrypted PEM keys are not supported")
+	}
+
+	return unmarshalPKCS8(block.Bytes)
+}
+
+func marshalPKCS8(k ci.PrivKey) ([]byte, error) {
+	raw, err := k.Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	switch k.(type) {
+	case *ci.RsaPrivateKey:
+		sk, err := x509.ParsePKCS1PrivateKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		return x509.MarshalPKCS8PrivateKey(sk)
+	case *ci.ECDSAPrivateKey:
+		sk, err := x509.ParseECPrivateKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		return x509.MarshalPKCS8PrivateKey(sk)
+	case *ci.Ed25519PrivateKey:
+		seed, err := asn1.Marshal(raw[:ed25519.SeedSize])
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(pkcs8{
+			Algo:       pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+			PrivateKey: seed,
+		})
+	default:
+		return nil, fmt.Errorf("keys of type %T can't be encoded as PKCS#8", k)
+	}
+}
+
+func unmarshalPKCS8(der []byte) (ci.PrivKey, error) {
+	var p pkcs8
+	if _, err := asn1.Unmarshal(der, &p); err == nil && p.Algo.Algorithm.Equal(oidEd25519) {
+		var seed []byte
+		if _, err := asn1.Unmarshal(p.PrivateKey, &seed); err != nil {
+			return nil, fmt.Errorf("invalid Ed25519 private key: %s", err)
+		}
+		if len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid Ed25519 private key length %d", len(seed))
+		}
+		return ci.UnmarshalEd25519PrivateKey(ed25519.NewKeyFromSeed(seed))
+	}
+
+	sk, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	switch sk := sk.(type) {
+	case *rsa.PrivateKey:
+		return ci.UnmarshalRsaPrivateKey(x509.MarshalPKCS1PrivateKey(sk))
+	case *ecdsa.PrivateKey:
+		b, err := x509.MarshalECPrivateKey(sk)
+		if err != nil {
+			return nil, err
+		}
+		return ci.UnmarshalECDSAPrivateKey(b)
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", sk)
+	}
+}
@@ -0,0 +1,40 @@
+package keystore
+
+import (
+	"crypto/rand"
+	"testing"
+
+	ci "github.com/libp2p/go-libp2p-core/crypto"
+)
+
+func TestMarshalPrivateKey(t *testing.T) {
+	rsaKey, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, _, err := ci.GenerateKeyPairWithReader(ci.ECDSA, 256, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []ci.PrivKey{privKeyOrFatal(t), rsaKey, ecdsaKey} {
+		for _, format := range []string{FormatProtobuf, FormatPEM} {
+			b, err := MarshalPrivateKey(k, format)
+			if err != nil {
+				t.Fatalf("%T %s: %s", k, format, err)
+			}
+
+			got, err := UnmarshalPrivateKey(b)
+			if err != nil {
+				t.Fatalf("%T %s: %s", k, format, err)
+			}
+			if !got.Equals(k) {
+				t.Fatalf("%T %s: key changed in roundtrip", k, format)
+			}
+		}
+	}
+
+	if _, err := MarshalPrivateKey(rsaKey, "der"); err == nil {
+		t.Fatal("expected unknown format to be rejected")
+	}
+}
@@ -52,6 +52,12 @@ func validateName(name string) error {
 	return nil
 }
 
+// isInternalFile returns whether name is a file used by the keystore itself
+// rather than a key.
+func isInternalFile(name string) bool {
+	return name == markerFile || name == identityFile || strings.HasPrefix(name, ".tmp-")
+}
+
 func NewFSKeystore(dir string) (*FSKeystore, error) {
 	_, err := os.Stat(dir)
 	if err != nil {
@@ -164,6 +170,10 @@ func (ks *FSKeystore) List() ([]string, error) {
 	list := make([]string, 0, len(dirs))
 
 	for _, name := range dirs {
+		if isInternalFile(name) {
+			continue
+		}
+
 		err := validateName(name)
 		if err == nil {
 			list = append(list, name)
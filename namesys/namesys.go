@@ -186,6 +186,13 @@ func (ns *mpns) PublishWithEOL(ctx context.Context, name ci.PrivKey, value path.
 	if ttEol := time.Until(eol); ttEol < ttl {
 		ttl = ttEol
 	}
+	if IsSuccession([]byte(value)) {
+		s, err := ParseSuccession([]byte(value))
+		if err != nil {
+			return err
+		}
+		value = s.Path()
+	}
 	ns.cacheSet(peer.IDB58Encode(id), value, ttl)
 	return nil
 }
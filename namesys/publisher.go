@@ -154,6 +154,11 @@ func (p *IpnsPublisher) updateRecord(ctx context.Context, k ci.PrivKey, value pa
 		return nil, err
 	}
 
+	if rec != nil && IsSuccession(rec.GetValue()) && value != path.Path(rec.GetValue()) {
+		// a succession record is the final record of a name
+		return nil, ErrNameRotated
+	}
+
 	seqno := rec.GetSequence() // returns 0 if rec is nil
 	if rec != nil && value != path.Path(rec.GetValue()) {
 		// Don't bother incrementing the sequence number unless the
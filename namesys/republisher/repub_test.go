@@ -3,6 +3,7 @@ package republisher_test
 import (
 	"context"
 	"errors"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,8 +14,15 @@ import (
 	. "github.com/ipfs/go-ipfs/namesys/republisher"
 	path "github.com/ipfs/go-path"
 
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	offroute "github.com/ipfs/go-ipfs-routing/offline"
+	ipns "github.com/ipfs/go-ipns"
 	goprocess "github.com/jbenet/goprocess"
+	ci "github.com/libp2p/go-libp2p-core/crypto"
 	peer "github.com/libp2p/go-libp2p-core/peer"
+	pstoremem "github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	record "github.com/libp2p/go-libp2p-record"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
 )
 
@@ -112,6 +120,91 @@ func TestRepublish(t *testing.T) {
 	}
 }
 
+// eolPublisher records the EOLs records are published with.
+type eolPublisher struct {
+	namesys.Publisher
+
+	mu   sync.Mutex
+	eols []time.Time
+}
+
+func (p *eolPublisher) PublishWithEOL(ctx context.Context, k ci.PrivKey, value path.Path, eol time.Time) error {
+	p.mu.Lock()
+	p.eols = append(p.eols, eol)
+	p.mu.Unlock()
+	return p.Publisher.PublishWithEOL(ctx, k, value, eol)
+}
+
+func (p *eolPublisher) published() []time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]time.Time(nil), p.eols...)
+}
+
+func TestRepublishSuccession(t *testing.T) {
+	ctx := context.Background()
+
+	dst := dssync.MutexWrap(ds.NewMapDatastore())
+	ps := pstoremem.NewPeerstore()
+	routing := offroute.NewOfflineRouter(dst, record.NamespacedValidator{
+		"ipns": ipns.Validator{KeyBook: ps},
+		"pk":   record.PublicKeyValidator{},
+	})
+
+	var keys []ci.PrivKey
+	for i := 0; i < 2; i++ {
+		k, _, err := ci.GenerateKeyPair(ci.RSA, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := peer.IDFromPrivateKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ps.AddPrivKey(id, k); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+	oldKey, newKey := keys[0], keys[1]
+
+	s, err := namesys.NewSuccession(oldKey, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := s.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := &eolPublisher{Publisher: namesys.NewIpnsPublisher(routing, dst)}
+	eol := time.Now().Add(namesys.SuccessionRecordEOL)
+	if err := pub.Publisher.PublishWithEOL(ctx, oldKey, v, eol); err != nil {
+		t.Fatal(err)
+	}
+
+	repub := NewRepublisher(pub, dst, oldKey, nil)
+	repub.Interval = 100 * time.Millisecond
+	repub.RecordLifetime = time.Hour
+
+	proc := goprocess.Go(repub.Run)
+	defer proc.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(pub.published()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the succession record wasn't republished")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// the succession record keeps its EOL rather than the record lifetime
+	for _, e := range pub.published() {
+		if !e.Equal(eol) {
+			t.Fatalf("succession record republished with EOL %s, expected %s", e, eol)
+		}
+	}
+}
+
 func verifyResolution(nodes []*core.IpfsNode, key string, exp path.Path) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -73,7 +73,7 @@ func (r *IpnsResolver) resolveOnceAsync(ctx context.Context, name string, option
 	// store before calling GetValue() on the DHT - the DHT will call the
 	// ipns validator, which in turn will get the public key from the peer
 	// store to verify the record signature
-	_, err = routing.GetPublicKey(r.routing, ctx, pid)
+	pubk, err := routing.GetPublicKey(r.routing, ctx, pid)
 	if err != nil {
 		log.Debugf("RoutingResolver: could not retrieve public key %s: %s\n", name, err)
 		out <- onceResult{err: err}
@@ -115,8 +115,19 @@ func (r *IpnsResolver) resolveOnceAsync(ctx context.Context, name string, option
 				}
 
 				var p path.Path
-				// check for old style record:
-				if valh, err := mh.Cast(entry.GetValue()); err == nil {
+				if IsSuccession(entry.GetValue()) {
+					// The key was rotated, continue with its successor
+					s, err := ParseSuccession(entry.GetValue())
+					if err == nil {
+						err = s.Verify(pubk)
+					}
+					if err != nil {
+						emitOnceResult(ctx, out, onceResult{err: err})
+						return
+					}
+					log.Debugf("RoutingResolver: %s was succeeded by %s", name, s.Successor)
+					p = s.Path()
+				} else if valh, err := mh.Cast(entry.GetValue()); err == nil {
 					// Its an old style multihash record
 					log.Debugf("encountered CIDv0 ipns entry: %s", valh)
 					p = path.FromCid(cid.NewCidV0(valh))
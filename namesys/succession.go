@@ -0,0 +1,156 @@
+package namesys
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	path "github.com/ipfs/go-path"
+	ci "github.com/libp2p/go-libp2p-core/crypto"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// successionPrefix prefixes the value of the IPNS record of a rotated key.
+const successionPrefix = "/ipns-succession/"
+
+// successionSigPrefix prefixes the data signed by both keys of a succession.
+const successionSigPrefix = "ipns-succession:"
+
+// SuccessionRecordEOL is the validity of the final record published under the
+// name of a rotated key.
+const SuccessionRecordEOL = 365 * 24 * time.Hour
+
+// ErrNameRotated is returned when publishing under the name of a rotated key.
+var ErrNameRotated = errors.New("the key of this name was rotated, publish under its successor instead")
+
+// Succession is the final value published under the name of a rotated key.
+// It points to the name of the key that replaced it, and is signed by both
+// keys so that it can be verified without the record carrying it.
+type Succession struct {
+	// Predecessor and Successor are the base58 encoded names of the rotated
+	// key and of the key that replaced it.
+	Predecessor string
+	Successor   string
+
+	// PubKey is the public key of the successor.
+	PubKey []byte
+
+	// Signature and SuccessorSignature sign the names of both keys with the
+	// predecessor and the successor key respectively.
+	Signature          []byte
+	SuccessorSignature []byte
+}
+
+// NewSuccession creates a succession from oldKey to newKey.
+func NewSuccession(oldKey, newKey ci.PrivKey) (*Succession, error) {
+	oldID, err := peer.IDFromPrivateKey(oldKey)
+	if err != nil {
+		return nil, err
+	}
+	newID, err := peer.IDFromPrivateKey(newKey)
+	if err != nil {
+		return nil, err
+	}
+	if oldID == newID {
+		return nil, errors.New("a key can't succeed itself")
+	}
+
+	pubk, err := newKey.GetPublic().Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Succession{
+		Predecessor: peer.IDB58Encode(oldID),
+		Successor:   peer.IDB58Encode(newID),
+		PubKey:      pubk,
+	}
+
+	if s.Signature, err = oldKey.Sign(s.signedData()); err != nil {
+		return nil, err
+	}
+	if s.SuccessorSignature, err = newKey.Sign(s.signedData()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// IsSuccession returns whether value is the value of the IPNS record of a
+// rotated key.
+func IsSuccession(value []byte) bool {
+	return strings.HasPrefix(string(value), successionPrefix)
+}
+
+// ParseSuccession decodes the value of the IPNS record of a rotated key. It
+// doesn't verify the succession.
+func ParseSuccession(value []byte) (*Succession, error) {
+	if !IsSuccession(value) {
+		return nil, errors.New("not a succession record")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(string(value[len(successionPrefix):]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid succession record: %s", err)
+	}
+
+	s := new(Succession)
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid succession record: %s", err)
+	}
+	return s, nil
+}
+
+// Value returns the succession encoded as the value of an IPNS record.
+func (s *Succession) Value() (path.Path, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return path.Path(successionPrefix + base64.RawURLEncoding.EncodeToString(data)), nil
+}
+
+// Path returns the path of the successor name.
+func (s *Succession) Path() path.Path {
+	return path.Path(ipnsPrefix + s.Successor)
+}
+
+// Verify checks that the succession was signed by both the predecessor,
+// whose public key is pubk, and the successor.
+func (s *Succession) Verify(pubk ci.PubKey) error {
+	oldID, err := peer.IDFromPublicKey(pubk)
+	if err != nil {
+		return err
+	}
+	if peer.IDB58Encode(oldID) != s.Predecessor {
+		return fmt.Errorf("succession record of %s found under the name %s", s.Predecessor, oldID.Pretty())
+	}
+	if ok, err := pubk.Verify(s.signedData(), s.Signature); err != nil || !ok {
+		return errors.New("invalid succession record: bad signature")
+	}
+
+	newPubk, err := ci.UnmarshalPublicKey(s.PubKey)
+	if err != nil {
+		return fmt.Errorf("invalid succession record: %s", err)
+	}
+	newID, err := peer.IDFromPublicKey(newPubk)
+	if err != nil {
+		return err
+	}
+	if peer.IDB58Encode(newID) != s.Successor {
+		return errors.New("invalid succession record: successor key doesn't match its name")
+	}
+	if newID == oldID {
+		return errors.New("invalid succession record: a key can't succeed itself")
+	}
+	if ok, err := newPubk.Verify(s.signedData(), s.SuccessorSignature); err != nil || !ok {
+		return errors.New("invalid succession record: bad successor signature")
+	}
+	return nil
+}
+
+func (s *Succession) signedData() []byte {
+	return []byte(successionSigPrefix + s.Predecessor + ":" + s.Successor)
+}
@@ -0,0 +1,150 @@
+package namesys
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	offroute "github.com/ipfs/go-ipfs-routing/offline"
+	ipns "github.com/ipfs/go-ipns"
+	path "github.com/ipfs/go-path"
+	ci "github.com/libp2p/go-libp2p-core/crypto"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	pstoremem "github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	record "github.com/libp2p/go-libp2p-record"
+)
+
+func TestSuccession(t *testing.T) {
+	oldKey, _, err := ci.GenerateKeyPair(ci.RSA, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, _, err := ci.GenerateKeyPair(ci.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, err := ci.GenerateKeyPair(ci.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSuccession(oldKey, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := s.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSuccession([]byte(v)) {
+		t.Fatalf("%s isn't recognized as a succession", v)
+	}
+
+	parsed, err := ParseSuccession([]byte(v))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.Verify(oldKey.GetPublic()); err != nil {
+		t.Fatal(err)
+	}
+	newID, err := peer.IDFromPrivateKey(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Path() != path.Path("/ipns/"+newID.Pretty()) {
+		t.Fatalf("unexpected successor path %s", parsed.Path())
+	}
+
+	if err := parsed.Verify(otherKey.GetPublic()); err == nil {
+		t.Fatal("expected a succession found under another name to fail")
+	}
+
+	// redirecting the succession to another key breaks the signatures
+	otherID, err := peer.IDFromPrivateKey(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := *parsed
+	tampered.Successor = peer.IDB58Encode(otherID)
+	tampered.PubKey, err = otherKey.GetPublic().Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tampered.Verify(oldKey.GetPublic()); err == nil {
+		t.Fatal("expected a tampered succession to fail")
+	}
+
+	if _, err := NewSuccession(oldKey, oldKey); err == nil {
+		t.Fatal("expected a key succeeding itself to fail")
+	}
+}
+
+func TestResolveSuccession(t *testing.T) {
+	ctx := context.Background()
+
+	dst := dssync.MutexWrap(ds.NewMapDatastore())
+	ps := pstoremem.NewPeerstore()
+	routing := offroute.NewOfflineRouter(dst, record.NamespacedValidator{
+		"ipns": ipns.Validator{KeyBook: ps},
+		"pk":   record.PublicKeyValidator{},
+	})
+	nsys := NewNameSystem(routing, dst, 0)
+
+	var keys []ci.PrivKey
+	for i := 0; i < 2; i++ {
+		k, _, err := ci.GenerateKeyPair(ci.RSA, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := peer.IDFromPrivateKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ps.AddPrivKey(id, k); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+	oldKey, newKey := keys[0], keys[1]
+	oldID, err := peer.IDFromPrivateKey(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := path.Path("/ipfs/Qmcqtw8FfrVSBaRmbWwHxt3AuySBhJLcvmFYi3Lbc4xnwj")
+	if err := nsys.Publish(ctx, newKey, p); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSuccession(oldKey, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := s.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nsys.PublishWithEOL(ctx, oldKey, v, time.Now().Add(SuccessionRecordEOL)); err != nil {
+		t.Fatal(err)
+	}
+
+	// resolve through the routing system rather than the publish cache
+	res := NewIpnsResolver(routing)
+	resolved, err := res.Resolve(ctx, "/ipns/"+oldID.Pretty())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved != p {
+		t.Fatalf("expected %s to resolve to %s, got %s", oldID.Pretty(), p, resolved)
+	}
+
+	// republishing the succession is allowed, publishing anything else isn't
+	if err := nsys.PublishWithEOL(ctx, oldKey, v, time.Now().Add(SuccessionRecordEOL)); err != nil {
+		t.Fatal(err)
+	}
+	if err := nsys.Publish(ctx, oldKey, p); err != ErrNameRotated {
+		t.Fatalf("expected %s, got %v", ErrNameRotated, err)
+	}
+}
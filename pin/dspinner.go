@@ -0,0 +1,296 @@
+package pin
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// Pins stored by the datastore backed pinner live under /pins/<mode>/<cid>
+// for unnamed pins and /pins/<mode>/<cid>/<base32 name> for named ones, one
+// key per pin.
+var (
+	pinsKeyPrefix  = ds.NewKey("/pins")
+	pinsVersionKey = pinsKeyPrefix.ChildString("version")
+)
+
+// pinsVersion is the version of the per-key pin layout.
+const pinsVersion = "1"
+
+// NewDatastorePinner creates an empty pinner which stores every pin under
+// its own key in the given datastore. Pins are written as soon as they
+// change, making Flush a no-op.
+func NewDatastorePinner(dstore ds.Datastore, serv ipld.DAGService) (Pinner, error) {
+	if err := dstore.Put(pinsVersionKey, []byte(pinsVersion)); err != nil {
+		return nil, err
+	}
+
+	return newDatastorePinner(dstore, serv), nil
+}
+
+func newDatastorePinner(dstore ds.Datastore, serv ipld.DAGService) *pinner {
+	return &pinner{
+		recurseNames: make(map[cid.Cid]map[string]*pinRecord),
+		directNames:  make(map[cid.Cid]map[string]*pinRecord),
+		recursePin:   cid.NewSet(),
+		directPin:    cid.NewSet(),
+		internalPin:  cid.NewSet(),
+		dserv:        serv,
+		dstore:       dstore,
+		perKey:       true,
+	}
+}
+
+// LoadDatastorePinner loads a pinner which stores every pin under its own
+// datastore key. If the datastore still holds pin sets flushed as a DAG by
+// the pinner returned from NewPinner, they are migrated first. The internal
+// DAGService is only used to read these old pin sets.
+func LoadDatastorePinner(d ds.Datastore, dserv, internal ipld.DAGService) (Pinner, error) {
+	v, err := d.Get(pinsVersionKey)
+	switch err {
+	case nil:
+		if string(v) != pinsVersion {
+			return nil, fmt.Errorf("unsupported pin datastore version %q", v)
+		}
+	case ds.ErrNotFound:
+		if err := migratePinSetDAG(d, dserv, internal); err != nil {
+			return nil, fmt.Errorf("cannot migrate pins to datastore: %v", err)
+		}
+	default:
+		return nil, err
+	}
+
+	p := newDatastorePinner(d, dserv)
+	for _, mode := range []Mode{Recursive, Direct} {
+		if err := p.loadPins(mode); err != nil {
+			return nil, fmt.Errorf("cannot load pins: %v", err)
+		}
+	}
+
+	return p, nil
+}
+
+// migratePinSetDAG moves the pins of a pin set DAG referenced by
+// pinDatastoreKey to per-key storage. The version key is written in the same
+// batch as the pins, so an interrupted migration is simply run again.
+func migratePinSetDAG(d ds.Datastore, dserv, internal ipld.DAGService) error {
+	has, err := d.Has(pinDatastoreKey)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return d.Put(pinsVersionKey, []byte(pinsVersion))
+	}
+
+	old, err := LoadPinner(d, dserv, internal)
+	if err != nil {
+		return err
+	}
+
+	b, err := batching(d)
+	if err != nil {
+		return err
+	}
+	for _, c := range old.RecursiveKeys() {
+		if err := b.Put(pinKey(Recursive, c, ""), nil); err != nil {
+			return err
+		}
+	}
+	for _, c := range old.DirectKeys() {
+		if err := b.Put(pinKey(Direct, c, ""), nil); err != nil {
+			return err
+		}
+	}
+	if err := b.Put(pinsVersionKey, []byte(pinsVersion)); err != nil {
+		return err
+	}
+	if err := b.Commit(); err != nil {
+		return err
+	}
+
+	log.Infof("migrated %d recursive and %d direct pins to the datastore",
+		len(old.RecursiveKeys()), len(old.DirectKeys()))
+
+	// the pin set DAG is no longer referenced and will be garbage collected
+	return d.Delete(pinDatastoreKey)
+}
+
+func pinKey(mode Mode, c cid.Cid, name string) ds.Key {
+	modeStr, _ := ModeToString(mode)
+	k := pinsKeyPrefix.ChildString(modeStr).Child(dshelp.CidToDsKey(c))
+	if name != "" {
+		k = k.ChildString(base32.RawStdEncoding.EncodeToString([]byte(name)))
+	}
+	return k
+}
+
+// loadPins reads all pins of the given mode from the datastore.
+func (p *pinner) loadPins(mode Mode) error {
+	modeStr, _ := ModeToString(mode)
+	prefix := pinsKeyPrefix.ChildString(modeStr)
+	res, err := p.dstore.Query(query.Query{Prefix: prefix.String()})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	var changes []pinChange
+	for r := range res.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+
+		// <cid> or <cid>/<name>
+		parts := ds.RawKey(r.Key).Namespaces()[len(prefix.Namespaces()):]
+		if len(parts) == 0 || len(parts) > 2 {
+			return fmt.Errorf("invalid pin key %s", r.Key)
+		}
+
+		c, err := dshelp.DsKeyToCid(ds.NewKey(parts[0]))
+		if err != nil {
+			return fmt.Errorf("invalid pin key %s: %v", r.Key, err)
+		}
+
+		var name string
+		if len(parts) == 2 {
+			b, err := base32.RawStdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return fmt.Errorf("invalid pin key %s: %v", r.Key, err)
+			}
+			name = string(b)
+		}
+
+		rec := new(pinRecord)
+		if len(r.Value) > 0 {
+			if err := json.Unmarshal(r.Value, rec); err != nil {
+				return fmt.Errorf("invalid pin record %s: %v", r.Key, err)
+			}
+		}
+
+		changes = append(changes, pinChange{c: c, mode: mode, name: name, rec: rec})
+	}
+
+	p.setPinRecords(changes)
+	return nil
+}
+
+// batching returns a batch for d, falling back to writing every operation
+// directly if d doesn't support batching.
+func batching(d ds.Datastore) (ds.Batch, error) {
+	if bd, ok := d.(ds.Batching); ok {
+		return bd.Batch()
+	}
+	return &unbatched{d}, nil
+}
+
+type unbatched struct {
+	ds.Datastore
+}
+
+func (b *unbatched) Commit() error {
+	return nil
+}
+
+// pinRecord is the value stored for every pin.
+type pinRecord struct {
+	Meta    map[string]string `json:",omitempty"`
+	Expires *time.Time        `json:",omitempty"`
+}
+
+// expires returns when the pin expires, or the zero time if it doesn't.
+func (r *pinRecord) expires() time.Time {
+	if r.Expires == nil {
+		return time.Time{}
+	}
+	return *r.Expires
+}
+
+// pinChange is a single addition or removal of a pin, see applyPinChanges.
+type pinChange struct {
+	c    cid.Cid
+	mode Mode
+	name string
+	rec  *pinRecord // nil removes the pin
+}
+
+// applyPinChanges stores the given changes in a single batch, if pins are
+// stored per key, and then applies them to the in-memory state. Must be
+// called with the lock held.
+func (p *pinner) applyPinChanges(changes ...pinChange) error {
+	if p.perKey {
+		b, err := batching(p.dstore)
+		if err != nil {
+			return err
+		}
+		for _, ch := range changes {
+			k := pinKey(ch.mode, ch.c, ch.name)
+			if ch.rec == nil {
+				if err := b.Delete(k); err != nil && err != ds.ErrNotFound {
+					return err
+				}
+				continue
+			}
+
+			v, err := json.Marshal(ch.rec)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(k, v); err != nil {
+				return err
+			}
+		}
+		if err := b.Commit(); err != nil {
+			return err
+		}
+	}
+
+	p.setPinRecords(changes)
+	return nil
+}
+
+// setPinRecords applies the given changes to the in-memory state only.
+func (p *pinner) setPinRecords(changes []pinChange) {
+	for _, ch := range changes {
+		names := p.recurseNames
+		if ch.mode == Direct {
+			names = p.directNames
+		}
+
+		if ch.rec == nil {
+			delete(names[ch.c], ch.name)
+			if len(names[ch.c]) == 0 {
+				delete(names, ch.c)
+			}
+		} else {
+			if names[ch.c] == nil {
+				names[ch.c] = make(map[string]*pinRecord)
+			}
+			names[ch.c][ch.name] = ch.rec
+		}
+		p.updateSets(ch.c)
+	}
+}
+
+// updateSets brings the recursive and direct sets in line with the pin
+// records of c. A cid pinned both recursively and directly, under different
+// names, is only reported as recursive.
+func (p *pinner) updateSets(c cid.Cid) {
+	if len(p.recurseNames[c]) > 0 {
+		p.recursePin.Add(c)
+	} else {
+		p.recursePin.Remove(c)
+	}
+
+	if len(p.directNames[c]) > 0 && !p.recursePin.Has(c) {
+		p.directPin.Add(c)
+	} else {
+		p.directPin.Remove(c)
+	}
+}
@@ -0,0 +1,301 @@
+package pin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bs "github.com/ipfs/go-blockservice"
+	mdag "github.com/ipfs/go-merkledag"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+)
+
+func TestDatastorePinnerPersistence(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := mdag.NewDAGService(bserv)
+
+	p, err := LoadDatastorePinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, ak := randNode()
+	b, bk := randNode()
+	c, ck := randNode()
+	for _, nd := range []*mdag.ProtoNode{a, b, c} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := p.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pin(ctx, b, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pin(ctx, c, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Unpin(ctx, ck, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// no Flush, every change must already be stored
+	p, err = LoadDatastorePinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertPinned(t, p, ak, "recursive pin not persisted")
+	assertPinned(t, p, bk, "direct pin not persisted")
+	assertUnpinned(t, p, ck, "removed pin still present")
+
+	// upgrading a direct pin must remove it
+	if err := p.Pin(ctx, b, true); err != nil {
+		t.Fatal(err)
+	}
+	p, err = LoadDatastorePinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.DirectKeys()) != 0 || len(p.RecursiveKeys()) != 2 {
+		t.Fatalf("unexpected pins after upgrade: %v %v", p.DirectKeys(), p.RecursiveKeys())
+	}
+}
+
+func TestDatastorePinnerMigration(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := mdag.NewDAGService(bserv)
+
+	old := NewPinner(dstore, dserv, dserv)
+
+	a, ak := randNode()
+	b, bk := randNode()
+	for _, nd := range []*mdag.ProtoNode{a, b} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := old.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Pin(ctx, b, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := LoadDatastorePinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, pinned, _ := p.IsPinnedWithType(ak, Recursive); !pinned {
+		t.Fatal("recursive pin was not migrated")
+	}
+	if _, pinned, _ := p.IsPinnedWithType(bk, Direct); !pinned {
+		t.Fatal("direct pin was not migrated")
+	}
+	if len(p.InternalPins()) != 0 {
+		t.Fatal("expected no internal pins after migration")
+	}
+
+	if has, err := dstore.Has(pinDatastoreKey); err != nil || has {
+		t.Fatal("expected pin set root to be removed after migration")
+	}
+}
+
+func TestDatastorePinnerNamedPins(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := mdag.NewDAGService(bserv)
+
+	p, err := LoadDatastorePinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, ak := randNode()
+	if err := dserv.Add(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.PinNamed(ctx, a, true, "alice", map[string]string{"app": "photos"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PinNamed(ctx, a, true, "bob/backups", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Unpin(ctx, ak, true); err == nil {
+		t.Fatal("expected unnamed unpin of a named pin to fail")
+	}
+
+	p, err = LoadDatastorePinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	named := p.NamedPins()
+	if len(named) != 2 {
+		t.Fatalf("expected 2 named pins, got %v", named)
+	}
+	for _, np := range named {
+		if np.Name == "alice" && np.Meta["app"] != "photos" {
+			t.Fatalf("metadata not persisted: %v", np.Meta)
+		}
+	}
+
+	if err := p.UnpinNamed(ctx, ak, true, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	assertPinned(t, p, ak, "pin held under another name was removed")
+
+	if err := p.UnpinNamed(ctx, ak, true, "bob/backups"); err != nil {
+		t.Fatal(err)
+	}
+	assertUnpinned(t, p, ak, "pin still present after removing all names")
+
+	p, err = LoadDatastorePinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertUnpinned(t, p, ak, "removed named pins still stored")
+}
+
+func TestDatastorePinnerUpdateNamedPins(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := mdag.NewDAGService(bserv)
+
+	p, err := LoadDatastorePinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, ak := randNode()
+	b, bk := randNode()
+	for _, nd := range []*mdag.ProtoNode{a, b} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a is only pinned under names
+	if err := p.PinNamed(ctx, a, true, "alice", map[string]string{"app": "photos"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PinNamed(ctx, a, true, "bob", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Update(ctx, ak, bk, true); err != nil {
+		t.Fatal(err)
+	}
+	assertUnpinned(t, p, ak, "updated pin still present")
+	assertPinned(t, p, bk, "update target not pinned")
+
+	p, err = LoadDatastorePinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertUnpinned(t, p, ak, "updated pin still stored")
+	named := p.NamedPins()
+	if len(named) != 2 {
+		t.Fatalf("expected the 2 names to move, got %v", named)
+	}
+	for _, np := range named {
+		if !np.Key.Equals(bk) {
+			t.Fatalf("expected %s to be pinned under %q, got %s", bk, np.Name, np.Key)
+		}
+		if np.Name == "alice" && np.Meta["app"] != "photos" {
+			t.Fatalf("metadata not moved: %v", np.Meta)
+		}
+	}
+	if err := p.Unpin(ctx, bk, true); err == nil {
+		t.Fatal("expected no unnamed pin to be added")
+	}
+}
+
+func TestDatastorePinnerExpiringPins(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := mdag.NewDAGService(bserv)
+
+	p, err := LoadDatastorePinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, ak := randNode()
+	b, bk := randNode()
+	for _, nd := range []*mdag.ProtoNode{a, b} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	if err := p.PinExpiring(ctx, a, true, "", nil, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PinExpiring(ctx, b, false, "cache", nil, now.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = LoadDatastorePinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, np := range p.NamedPins() {
+		if np.Expires.IsZero() {
+			t.Fatalf("expiry of %s not persisted", np.Key)
+		}
+	}
+
+	expired, err := p.RemoveExpired(now.Add(90 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || !expired[0].Key.Equals(ak) {
+		t.Fatalf("expected the pin of %s to expire, got %v", ak, expired)
+	}
+	assertUnpinned(t, p, ak, "expired pin still present")
+	assertPinned(t, p, bk, "pin removed before its expiry")
+
+	// pinning again without an expiry makes the pin permanent
+	if err := p.PinNamed(ctx, b, false, "cache", nil); err != nil {
+		t.Fatal(err)
+	}
+	expired, err = p.RemoveExpired(now.Add(3 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 0 {
+		t.Fatalf("expected no pin to expire, got %v", expired)
+	}
+	assertPinned(t, p, bk, "permanent pin removed")
+}
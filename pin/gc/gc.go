@@ -27,6 +27,9 @@ var log = logging.Logger("gc")
 type Result struct {
 	KeyRemoved cid.Cid
 	Error      error
+
+	// Size is the size of the object, it is only set by DryRun.
+	Size int
 }
 
 // GC performs a mark and sweep garbage collection of the blocks in the blockstore
@@ -145,6 +148,66 @@ func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn
 	return output
 }
 
+// DryRun computes the marked set like GC and reports every block which GC
+// would remove, together with its size, without removing anything. It
+// doesn't take the GC lock, so the result may differ from an actual run if
+// the blockstore or the pins change in the meantime.
+func DryRun(ctx context.Context, bs bstore.Blockstore, pn pin.Pinner, bestEffortRoots []cid.Cid) <-chan Result {
+	ctx, cancel := context.WithCancel(ctx)
+
+	bsrv := bserv.New(bs, offline.Exchange(bs))
+	ds := dag.NewDAGService(bsrv)
+
+	output := make(chan Result, 128)
+
+	go func() {
+		defer cancel()
+		defer close(output)
+
+		gcs, err := ColoredSet(ctx, pn, ds, bestEffortRoots, output)
+		if err != nil {
+			select {
+			case output <- Result{Error: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		keychan, err := bs.AllKeysChan(ctx)
+		if err != nil {
+			select {
+			case output <- Result{Error: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		for k := range keychan {
+			if gcs.Has(k) {
+				continue
+			}
+
+			res := Result{KeyRemoved: k}
+			res.Size, err = bs.GetSize(k)
+			if err == bstore.ErrNotFound {
+				// removed in the meantime
+				continue
+			}
+			if err != nil {
+				res = Result{Error: err}
+			}
+
+			select {
+			case output <- res:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return output
+}
+
 // Descendants recursively finds all the descendants of the given roots and
 // adds them to the given cid.Set, using the provided dag.GetLinks function
 // to walk the tree.
@@ -187,8 +250,17 @@ func Descendants(ctx context.Context, getLinks dag.GetLinks, set *cid.Set, roots
 func ColoredSet(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffortRoots []cid.Cid, output chan<- Result) (*cid.Set, error) {
 	// KeySet currently implemented in memory, in the future, may be bloom filter or
 	// disk backed to conserve memory.
-	errors := false
 	gcs := cid.NewSet()
+	if err := markPinned(ctx, pn, ng, bestEffortRoots, gcs, output); err != nil {
+		return nil, err
+	}
+	return gcs, nil
+}
+
+// markPinned adds the nodes pinned by the pins in the given pinner to gcs.
+// Subgraphs whose root is already in gcs are not walked again.
+func markPinned(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffortRoots []cid.Cid, gcs *cid.Set, output chan<- Result) error {
+	errors := false
 	getLinks := func(ctx context.Context, cid cid.Cid) ([]*ipld.Link, error) {
 		links, err := ipld.GetLinks(ctx, ng, cid)
 		if err != nil {
@@ -207,7 +279,7 @@ func ColoredSet(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffo
 		select {
 		case output <- Result{Error: err}:
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return ctx.Err()
 		}
 	}
 
@@ -229,7 +301,7 @@ func ColoredSet(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffo
 		select {
 		case output <- Result{Error: err}:
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return ctx.Err()
 		}
 	}
 
@@ -243,15 +315,15 @@ func ColoredSet(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffo
 		select {
 		case output <- Result{Error: err}:
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return ctx.Err()
 		}
 	}
 
 	if errors {
-		return nil, ErrCannotFetchAllLinks
+		return ErrCannotFetchAllLinks
 	}
 
-	return gcs, nil
+	return nil
 }
 
 // ErrCannotFetchAllLinks is returned as the last Result in the GC output
@@ -259,6 +331,10 @@ func ColoredSet(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffo
 // problem when finding descendants.
 var ErrCannotFetchAllLinks = errors.New("garbage collection aborted: could not retrieve some links")
 
+// ErrGCRunning is returned by IncrementalGC if another incremental
+// collection is already running on the same blockstore.
+var ErrGCRunning = errors.New("garbage collection already running")
+
 // ErrCannotDeleteSomeBlocks is returned when removing blocks marked for
 // deletion fails as the last Result in GC output channel.
 var ErrCannotDeleteSomeBlocks = errors.New("garbage collection incomplete: could not delete some blocks")
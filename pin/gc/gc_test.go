@@ -0,0 +1,58 @@
+package gc
+
+import (
+	"context"
+	"testing"
+
+	bserv "github.com/ipfs/go-blockservice"
+	pin "github.com/ipfs/go-ipfs/pin"
+	dag "github.com/ipfs/go-merkledag"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+)
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := bstore.NewGCBlockstore(bstore.NewBlockstore(dstore), bstore.NewGCLocker())
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+
+	pinned := dag.NodeWithData([]byte("pinned"))
+	garbage := dag.NodeWithData([]byte("garbage"))
+	for _, nd := range []*dag.ProtoNode{pinned, garbage} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pn, err := pin.NewDatastorePinner(dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pn.Pin(ctx, pinned, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var results []Result
+	for res := range DryRun(ctx, bs, pn, nil) {
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		results = append(results, res)
+	}
+
+	if len(results) != 1 || !results[0].KeyRemoved.Equals(garbage.Cid()) {
+		t.Fatalf("expected only %s to be reported, got %v", garbage.Cid(), results)
+	}
+	if results[0].Size != len(garbage.RawData()) {
+		t.Fatalf("expected size %d, got %d", len(garbage.RawData()), results[0].Size)
+	}
+
+	if has, err := bs.Has(garbage.Cid()); err != nil || !has {
+		t.Fatal("dry run removed a block")
+	}
+}
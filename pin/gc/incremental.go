@@ -0,0 +1,342 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	bserv "github.com/ipfs/go-blockservice"
+	pin "github.com/ipfs/go-ipfs/pin"
+	dag "github.com/ipfs/go-merkledag"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	dstore "github.com/ipfs/go-datastore"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log"
+)
+
+// DefaultBatchSize is the number of unmarked blocks removed per sweep batch
+// by IncrementalGC if no batch size is given.
+const DefaultBatchSize = 1000
+
+// TrackingBlockstore wraps a GCBlockstore and records the blocks written to
+// it while an incremental garbage collection is running, so that they are
+// not removed by it.
+type TrackingBlockstore struct {
+	bstore.GCBlockstore
+
+	lk      sync.Mutex
+	written *cid.Set // nil while not tracking
+}
+
+// NewTrackingBlockstore wraps bs for use with IncrementalGC.
+func NewTrackingBlockstore(bs bstore.GCBlockstore) *TrackingBlockstore {
+	return &TrackingBlockstore{GCBlockstore: bs}
+}
+
+func (bs *TrackingBlockstore) Put(b blocks.Block) error {
+	bs.track(b.Cid())
+	return bs.GCBlockstore.Put(b)
+}
+
+func (bs *TrackingBlockstore) PutMany(blks []blocks.Block) error {
+	for _, b := range blks {
+		bs.track(b.Cid())
+	}
+	return bs.GCBlockstore.PutMany(blks)
+}
+
+// track records c before it is written, see deleteUnlessWritten.
+func (bs *TrackingBlockstore) track(c cid.Cid) {
+	bs.lk.Lock()
+	if bs.written != nil {
+		bs.written.Add(c)
+	}
+	bs.lk.Unlock()
+}
+
+// startTracking starts recording written blocks. It returns false if another
+// collection is already tracking writes.
+func (bs *TrackingBlockstore) startTracking() bool {
+	bs.lk.Lock()
+	defer bs.lk.Unlock()
+	if bs.written != nil {
+		return false
+	}
+	bs.written = cid.NewSet()
+	return true
+}
+
+func (bs *TrackingBlockstore) stopTracking() {
+	bs.lk.Lock()
+	bs.written = nil
+	bs.lk.Unlock()
+}
+
+// deleteUnlessWritten removes c unless it was written since tracking
+// started. As writes are recorded before they happen, a concurrent write of
+// c either is seen here or happens after the removal.
+func (bs *TrackingBlockstore) deleteUnlessWritten(c cid.Cid) (bool, error) {
+	bs.lk.Lock()
+	defer bs.lk.Unlock()
+	if bs.written != nil && bs.written.Has(c) {
+		return false, nil
+	}
+	return true, bs.GCBlockstore.DeleteBlock(c)
+}
+
+// Control pauses and resumes the sweep of a running IncrementalGC. A paused
+// collection stops between two batches, without holding the GC lock. The
+// zero value is ready to use.
+type Control struct {
+	lk     sync.Mutex
+	resume chan struct{} // non-nil while paused
+}
+
+// Pause stops the sweep before its next batch.
+func (c *Control) Pause() {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.resume == nil {
+		c.resume = make(chan struct{})
+	}
+}
+
+// Resume continues a paused sweep.
+func (c *Control) Resume() {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.resume != nil {
+		close(c.resume)
+		c.resume = nil
+	}
+}
+
+// Paused returns whether the sweep is paused.
+func (c *Control) Paused() bool {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.resume != nil
+}
+
+func (c *Control) wait(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+
+	c.lk.Lock()
+	resume := c.resume
+	c.lk.Unlock()
+	if resume == nil {
+		return nil
+	}
+
+	select {
+	case <-resume:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// IncrementalOptions configure an IncrementalGC run.
+type IncrementalOptions struct {
+	// BatchSize is the number of unmarked blocks removed while holding the
+	// GC lock. Defaults to DefaultBatchSize.
+	BatchSize int
+
+	// Rate limits the number of blocks removed per second. Zero means no
+	// limit.
+	Rate int
+
+	// Control allows pausing the sweep. It may be nil.
+	Control *Control
+}
+
+// IncrementalGC performs a mark and sweep garbage collection like GC, but
+// without blocking writers for the whole run.
+//
+// The marked set is computed without holding the GC lock. Every block
+// written to bs from the start of the run is treated as live instead. The
+// sweep then removes unmarked blocks in batches, taking the GC lock for one
+// batch at a time. Before each batch, pins added since the mark phase are
+// marked as well.
+func IncrementalGC(ctx context.Context, bs *TrackingBlockstore, dstor dstore.Datastore, pn pin.Pinner, bestEffortRoots []cid.Cid, opts IncrementalOptions) <-chan Result {
+	ctx, cancel := context.WithCancel(ctx)
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	bsrv := bserv.New(bs, offline.Exchange(bs))
+	ds := dag.NewDAGService(bsrv)
+
+	output := make(chan Result, 128)
+
+	// writes must be tracked before the pins are read
+	if !bs.startTracking() {
+		output <- Result{Error: ErrGCRunning}
+		close(output)
+		cancel()
+		return output
+	}
+
+	go func() {
+		defer cancel()
+		defer close(output)
+		defer bs.stopTracking()
+
+		emark := log.EventBegin(ctx, "GC.mark")
+		gcs, err := ColoredSet(ctx, pn, ds, bestEffortRoots, output)
+		if err != nil {
+			select {
+			case output <- Result{Error: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+		emark.Append(logging.LoggableMap{
+			"blackSetSize": fmt.Sprintf("%d", gcs.Len()),
+		})
+		emark.Done()
+		esweep := log.EventBegin(ctx, "GC.sweep")
+
+		keychan, err := bs.AllKeysChan(ctx)
+		if err != nil {
+			select {
+			case output <- Result{Error: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		var removed uint64
+		errors := false
+		batch := make([]cid.Cid, 0, opts.BatchSize)
+		for done := false; !done && ctx.Err() == nil; {
+			batch = batch[:0]
+			for len(batch) < opts.BatchSize {
+				var k cid.Cid
+				var ok bool
+				select {
+				case k, ok = <-keychan:
+				case <-ctx.Done():
+				}
+				if !ok {
+					done = true
+					break
+				}
+				if !gcs.Has(k) {
+					batch = append(batch, k)
+				}
+			}
+			if len(batch) == 0 {
+				break
+			}
+
+			if err := opts.Control.wait(ctx); err != nil {
+				break
+			}
+
+			start := time.Now()
+			n, batchErrors, err := sweepBatch(ctx, bs, ds, pn, bestEffortRoots, gcs, batch, output)
+			removed += n
+			errors = errors || batchErrors
+			if err != nil {
+				select {
+				case output <- Result{Error: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+
+			if opts.Rate > 0 {
+				wait := time.Duration(len(batch))*time.Second/time.Duration(opts.Rate) - time.Since(start)
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+				}
+			}
+		}
+		esweep.Append(logging.LoggableMap{
+			"whiteSetSize": fmt.Sprintf("%d", removed),
+		})
+		esweep.Done()
+		if ctx.Err() != nil {
+			return
+		}
+		if errors {
+			select {
+			case output <- Result{Error: ErrCannotDeleteSomeBlocks}:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		defer log.EventBegin(ctx, "GC.datastore").Done()
+		gds, ok := dstor.(dstore.GCDatastore)
+		if !ok {
+			return
+		}
+
+		err = gds.CollectGarbage()
+		if err != nil {
+			select {
+			case output <- Result{Error: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+	}()
+
+	return output
+}
+
+// sweepBatch removes the blocks of batch which are still unmarked while
+// holding the GC lock. It returns the number of removed blocks and whether
+// some of them could not be removed.
+func sweepBatch(ctx context.Context, bs *TrackingBlockstore, ds ipld.NodeGetter, pn pin.Pinner, bestEffortRoots []cid.Cid, gcs *cid.Set, batch []cid.Cid, output chan<- Result) (uint64, bool, error) {
+	unlocker := bs.GCLock()
+	defer unlocker.Unlock()
+
+	// pins added while marking or sweeping may refer to blocks which were
+	// already stored and are therefore not tracked
+	if err := markPinned(ctx, pn, ds, bestEffortRoots, gcs, output); err != nil {
+		return 0, false, err
+	}
+
+	var removed uint64
+	errors := false
+	for _, k := range batch {
+		if gcs.Has(k) {
+			continue
+		}
+
+		deleted, err := bs.deleteUnlessWritten(k)
+		if err != nil {
+			errors = true
+			select {
+			case output <- Result{Error: &CannotDeleteBlockError{k, err}}:
+			case <-ctx.Done():
+				return removed, errors, ctx.Err()
+			}
+			// continue as error is non-fatal
+			continue
+		}
+		if !deleted {
+			continue
+		}
+
+		removed++
+		select {
+		case output <- Result{KeyRemoved: k}:
+		case <-ctx.Done():
+			return removed, errors, ctx.Err()
+		}
+	}
+	return removed, errors, nil
+}
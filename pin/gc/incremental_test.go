@@ -0,0 +1,97 @@
+package gc
+
+import (
+	"context"
+	"testing"
+
+	bserv "github.com/ipfs/go-blockservice"
+	pin "github.com/ipfs/go-ipfs/pin"
+	dag "github.com/ipfs/go-merkledag"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+)
+
+func TestIncrementalGC(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := NewTrackingBlockstore(bstore.NewGCBlockstore(bstore.NewBlockstore(dstore), bstore.NewGCLocker()))
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+
+	pinned := dag.NodeWithData([]byte("pinned"))
+	garbage := dag.NodeWithData([]byte("garbage"))
+	for _, nd := range []*dag.ProtoNode{pinned, garbage} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pn, err := pin.NewDatastorePinner(dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pn.Pin(ctx, pinned, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// a block written while the collection is running must be kept
+	if !bs.startTracking() {
+		t.Fatal("expected tracking to start")
+	}
+	written := dag.NodeWithData([]byte("written"))
+	if err := dserv.Add(ctx, written); err != nil {
+		t.Fatal(err)
+	}
+
+	ctrl := new(Control)
+	gcs := cid.NewSet()
+	removed, _, err := sweepBatch(ctx, bs, dserv, pn, nil, gcs, []cid.Cid{pinned.Cid(), garbage.Cid(), written.Cid()}, make(chan Result, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs.stopTracking()
+
+	if removed != 1 {
+		t.Fatalf("expected 1 removed block, got %d", removed)
+	}
+	for c, keep := range map[cid.Cid]bool{pinned.Cid(): true, garbage.Cid(): false, written.Cid(): true} {
+		has, err := bs.Has(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if has != keep {
+			t.Fatalf("block %s: expected present=%v", c, keep)
+		}
+	}
+
+	ctrl.Pause()
+	if !ctrl.Paused() {
+		t.Fatal("expected control to be paused")
+	}
+	ctrl.Resume()
+	if err := ctrl.wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// a complete run keeps the pinned block only
+	for c := range IncrementalGC(ctx, bs, dstore, pn, nil, IncrementalOptions{BatchSize: 1, Control: ctrl}) {
+		if c.Error != nil {
+			t.Fatal(c.Error)
+		}
+	}
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []cid.Cid
+	for k := range keys {
+		left = append(left, k)
+	}
+	if len(left) != 1 || !left[0].Equals(pinned.Cid()) {
+		t.Fatalf("expected only the pinned block to be left, got %v", left)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -114,13 +116,32 @@ type Pinner interface {
 	// Pin the given node, optionally recursively.
 	Pin(ctx context.Context, node ipld.Node, recursive bool) error
 
+	// PinNamed pins the given node like Pin, recording the pin under the
+	// given name together with meta. Pins held under different names are
+	// tracked separately and a cid stays pinned as long as any of them
+	// exists. Pin is equivalent to PinNamed with an empty name.
+	PinNamed(ctx context.Context, node ipld.Node, recursive bool, name string, meta map[string]string) error
+
+	// PinExpiring pins the given node under name like PinNamed, the pin
+	// being removed by RemoveExpired once expires is past. A zero expires
+	// makes the pin permanent, and pinning again replaces the expiry.
+	PinExpiring(ctx context.Context, node ipld.Node, recursive bool, name string, meta map[string]string, expires time.Time) error
+
 	// Unpin the given cid. If recursive is true, removes either a recursive or
 	// a direct pin. If recursive is false, only removes a direct pin.
 	Unpin(ctx context.Context, cid cid.Cid, recursive bool) error
 
+	// UnpinNamed removes the pin held on the given cid under name, like
+	// Unpin. Pins held under other names are left untouched.
+	UnpinNamed(ctx context.Context, cid cid.Cid, recursive bool, name string) error
+
+	// RemoveExpired removes the pins which expired at now and returns them.
+	RemoveExpired(now time.Time) ([]PinInfo, error)
+
 	// Update updates a recursive pin from one cid to another
 	// this is more efficient than simply pinning the new one and unpinning the
-	// old one
+	// old one. With unpin, every name the old cid is pinned under moves to
+	// the new one.
 	Update(ctx context.Context, from, to cid.Cid, unpin bool) error
 
 	// Check if a set of keys are pinned, more efficient than
@@ -146,6 +167,10 @@ type Pinner interface {
 	// DirectKeys returns all recursively pinned cids
 	RecursiveKeys() []cid.Cid
 
+	// NamedPins returns all direct and recursive pins, one for each name a
+	// cid is pinned under.
+	NamedPins() []PinInfo
+
 	// InternalPins returns all cids kept pinned for the internal state of the
 	// pinner
 	InternalPins() []cid.Cid
@@ -161,6 +186,24 @@ type Pinned struct {
 	Via  cid.Cid
 }
 
+// PinInfo describes a direct or recursive pin held under a name. Unnamed
+// pins have an empty Name, permanent ones a zero Expires.
+type PinInfo struct {
+	Key     cid.Cid
+	Mode    Mode
+	Name    string
+	Meta    map[string]string
+	Expires time.Time
+}
+
+// ErrNamedPinsUnsupported is returned when adding a named pin to a pinner
+// that stores its pin sets as a DAG.
+var ErrNamedPinsUnsupported = fmt.Errorf("named pins are only supported by the datastore pinner")
+
+// ErrExpiringPinsUnsupported is returned when adding an expiring pin to a
+// pinner that stores its pin sets as a DAG.
+var ErrExpiringPinsUnsupported = fmt.Errorf("expiring pins are only supported by the datastore pinner")
+
 // Pinned returns whether or not the given cid is pinned
 func (p Pinned) Pinned() bool {
 	return p.Mode != NotPinned
@@ -181,9 +224,15 @@ func (p Pinned) String() string {
 
 // pinner implements the Pinner interface
 type pinner struct {
-	lock       sync.RWMutex
-	recursePin *cid.Set
-	directPin  *cid.Set
+	lock sync.RWMutex
+
+	// recurseNames and directNames hold the records of every pin by cid
+	// and name, an unnamed pin being stored under "". recursePin and
+	// directPin are derived from them, see updateSets.
+	recurseNames map[cid.Cid]map[string]*pinRecord
+	directNames  map[cid.Cid]map[string]*pinRecord
+	recursePin   *cid.Set
+	directPin    *cid.Set
 
 	// Track the keys used for storing the pinning state, so gc does
 	// not delete them.
@@ -191,6 +240,11 @@ type pinner struct {
 	dserv       ipld.DAGService
 	internal    ipld.DAGService // dagservice used to store internal objects
 	dstore      ds.Datastore
+
+	// perKey is set when every pin is stored under its own datastore key
+	// as soon as it changes, instead of the pin sets being written as a
+	// DAG by Flush.
+	perKey bool
 }
 
 // NewPinner creates a new pinner using the given datastore as a backend
@@ -200,17 +254,37 @@ func NewPinner(dstore ds.Datastore, serv, internal ipld.DAGService) Pinner {
 	dirset := cid.NewSet()
 
 	return &pinner{
-		recursePin:  rcset,
-		directPin:   dirset,
-		dserv:       serv,
-		dstore:      dstore,
-		internal:    internal,
-		internalPin: cid.NewSet(),
+		recurseNames: make(map[cid.Cid]map[string]*pinRecord),
+		directNames:  make(map[cid.Cid]map[string]*pinRecord),
+		recursePin:   rcset,
+		directPin:    dirset,
+		dserv:        serv,
+		dstore:       dstore,
+		internal:     internal,
+		internalPin:  cid.NewSet(),
 	}
 }
 
 // Pin the given node, optionally recursive
 func (p *pinner) Pin(ctx context.Context, node ipld.Node, recurse bool) error {
+	return p.PinNamed(ctx, node, recurse, "", nil)
+}
+
+// PinNamed pins the given node under name, optionally recursive
+func (p *pinner) PinNamed(ctx context.Context, node ipld.Node, recurse bool, name string, meta map[string]string) error {
+	return p.PinExpiring(ctx, node, recurse, name, meta, time.Time{})
+}
+
+// PinExpiring pins the given node under name until expires, optionally
+// recursive
+func (p *pinner) PinExpiring(ctx context.Context, node ipld.Node, recurse bool, name string, meta map[string]string, expires time.Time) error {
+	if name != "" && !p.perKey {
+		return ErrNamedPinsUnsupported
+	}
+	if !expires.IsZero() && !p.perKey {
+		return ErrExpiringPinsUnsupported
+	}
+
 	p.lock.Lock()
 	defer p.lock.Unlock()
 	err := p.dserv.Add(ctx, node)
@@ -219,32 +293,35 @@ func (p *pinner) Pin(ctx context.Context, node ipld.Node, recurse bool) error {
 	}
 
 	c := node.Cid()
+	rec := &pinRecord{Meta: meta}
+	if !expires.IsZero() {
+		expires = expires.UTC()
+		rec.Expires = &expires
+	}
 
 	if recurse {
-		if p.recursePin.Has(c) {
-			return nil
-		}
-
-		if p.directPin.Has(c) {
-			p.directPin.Remove(c)
-		}
-		p.lock.Unlock()
-		// fetch entire graph
-		err := mdag.FetchGraph(ctx, c, p.dserv)
-		p.lock.Lock()
-		if err != nil {
-			return err
+		if !p.recursePin.Has(c) {
+			p.lock.Unlock()
+			// fetch entire graph
+			err := mdag.FetchGraph(ctx, c, p.dserv)
+			p.lock.Lock()
+			if err != nil {
+				return err
+			}
 		}
 
-		if p.recursePin.Has(c) {
-			return nil
+		if old, ok := p.recurseNames[c][name]; ok && meta == nil {
+			if old.expires().Equal(expires) {
+				return nil
+			}
+			rec.Meta = old.Meta
 		}
 
-		if p.directPin.Has(c) {
-			p.directPin.Remove(c)
+		changes := []pinChange{{c: c, mode: Recursive, name: name, rec: rec}}
+		if _, ok := p.directNames[c][name]; ok {
+			changes = append(changes, pinChange{c: c, mode: Direct, name: name})
 		}
-
-		p.recursePin.Add(c)
+		return p.applyPinChanges(changes...)
 	} else {
 		p.lock.Unlock()
 		_, err := p.dserv.Get(ctx, c)
@@ -253,13 +330,12 @@ func (p *pinner) Pin(ctx context.Context, node ipld.Node, recurse bool) error {
 			return err
 		}
 
-		if p.recursePin.Has(c) {
+		if _, ok := p.recurseNames[c][name]; ok {
 			return fmt.Errorf("%s already pinned recursively", c.String())
 		}
 
-		p.directPin.Add(c)
+		return p.applyPinChanges(pinChange{c: c, mode: Direct, name: name, rec: rec})
 	}
-	return nil
 }
 
 // ErrNotPinned is returned when trying to unpin items which are not pinned.
@@ -267,22 +343,41 @@ var ErrNotPinned = fmt.Errorf("not pinned or pinned indirectly")
 
 // Unpin a given key
 func (p *pinner) Unpin(ctx context.Context, c cid.Cid, recursive bool) error {
+	return p.UnpinNamed(ctx, c, recursive, "")
+}
+
+// UnpinNamed removes the pin held on a given key under name
+func (p *pinner) UnpinNamed(ctx context.Context, c cid.Cid, recursive bool, name string) error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
-	if p.recursePin.Has(c) {
+	if _, ok := p.recurseNames[c][name]; ok {
 		if !recursive {
 			return fmt.Errorf("%s is pinned recursively", c)
 		}
-		p.recursePin.Remove(c)
-		return nil
+		return p.applyPinChanges(pinChange{c: c, mode: Recursive, name: name})
 	}
-	if p.directPin.Has(c) {
-		p.directPin.Remove(c)
-		return nil
+	if _, ok := p.directNames[c][name]; ok {
+		return p.applyPinChanges(pinChange{c: c, mode: Direct, name: name})
+	}
+	if names := p.pinNames(c); name == "" && len(names) > 0 {
+		return fmt.Errorf("%s is only pinned under the names %s", c, strings.Join(names, ", "))
 	}
 	return ErrNotPinned
 }
 
+// pinNames returns the sorted names of all pins held on c. Must be called
+// with the lock held.
+func (p *pinner) pinNames(c cid.Cid) []string {
+	var names []string
+	for _, m := range []map[cid.Cid]map[string]*pinRecord{p.recurseNames, p.directNames} {
+		for name := range m[c] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (p *pinner) isInternalPin(c cid.Cid) bool {
 	return p.internalPin.Has(c)
 }
@@ -422,27 +517,19 @@ func (p *pinner) RemovePinWithMode(c cid.Cid, mode Mode) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 	switch mode {
-	case Direct:
-		p.directPin.Remove(c)
-	case Recursive:
-		p.recursePin.Remove(c)
+	case Direct, Recursive:
+		if err := p.applyPinChanges(pinChange{c: c, mode: mode}); err != nil {
+			log.Errorf("failed to remove pin %s: %s", c, err)
+		}
 	default:
 		// programmer error, panic OK
 		panic("unrecognized pin type")
 	}
 }
 
-func cidSetWithValues(cids []cid.Cid) *cid.Set {
-	out := cid.NewSet()
-	for _, c := range cids {
-		out.Add(c)
-	}
-	return out
-}
-
 // LoadPinner loads a pinner and its keysets from the given datastore
 func LoadPinner(d ds.Datastore, dserv, internal ipld.DAGService) (Pinner, error) {
-	p := new(pinner)
+	p := NewPinner(d, dserv, internal).(*pinner)
 
 	rootKey, err := d.Get(pinDatastoreKey)
 	if err != nil {
@@ -475,7 +562,10 @@ func LoadPinner(d ds.Datastore, dserv, internal ipld.DAGService) (Pinner, error)
 		if err != nil {
 			return nil, fmt.Errorf("cannot load recursive pins: %v", err)
 		}
-		p.recursePin = cidSetWithValues(recurseKeys)
+		for _, c := range recurseKeys {
+			p.recurseNames[c] = map[string]*pinRecord{"": {}}
+			p.updateSets(c)
+		}
 	}
 
 	{ // load direct set
@@ -483,16 +573,14 @@ func LoadPinner(d ds.Datastore, dserv, internal ipld.DAGService) (Pinner, error)
 		if err != nil {
 			return nil, fmt.Errorf("cannot load direct pins: %v", err)
 		}
-		p.directPin = cidSetWithValues(directKeys)
+		for _, c := range directKeys {
+			p.directNames[c] = map[string]*pinRecord{"": {}}
+			p.updateSets(c)
+		}
 	}
 
 	p.internalPin = internalset
 
-	// assign services
-	p.dserv = dserv
-	p.dstore = d
-	p.internal = internal
-
 	return p, nil
 }
 
@@ -512,6 +600,49 @@ func (p *pinner) RecursiveKeys() []cid.Cid {
 	return p.recursePin.Keys()
 }
 
+// NamedPins returns all direct and recursive pins with their names
+func (p *pinner) NamedPins() []PinInfo {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	var out []PinInfo
+	for mode, m := range map[Mode]map[cid.Cid]map[string]*pinRecord{Recursive: p.recurseNames, Direct: p.directNames} {
+		for c, names := range m {
+			for name, rec := range names {
+				out = append(out, PinInfo{Key: c, Mode: mode, Name: name, Meta: rec.Meta, Expires: rec.expires()})
+			}
+		}
+	}
+	return out
+}
+
+// RemoveExpired removes the pins which expired at now
+func (p *pinner) RemoveExpired(now time.Time) ([]PinInfo, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var expired []PinInfo
+	var changes []pinChange
+	for mode, m := range map[Mode]map[cid.Cid]map[string]*pinRecord{Recursive: p.recurseNames, Direct: p.directNames} {
+		for c, names := range m {
+			for name, rec := range names {
+				if rec.Expires == nil || rec.Expires.After(now) {
+					continue
+				}
+				expired = append(expired, PinInfo{Key: c, Mode: mode, Name: name, Meta: rec.Meta, Expires: *rec.Expires})
+				changes = append(changes, pinChange{c: c, mode: mode, name: name})
+			}
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if err := p.applyPinChanges(changes...); err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
 // Update updates a recursive pin from one cid to another
 // this is more efficient than simply pinning the new one and unpinning the
 // old one
@@ -528,11 +659,27 @@ func (p *pinner) Update(ctx context.Context, from, to cid.Cid, unpin bool) error
 		return err
 	}
 
-	p.recursePin.Add(to)
-	if unpin {
-		p.recursePin.Remove(from)
+	if from.Equals(to) {
+		return nil
 	}
-	return nil
+
+	if !unpin {
+		// the new pin expires like the one it comes from
+		rec := &pinRecord{}
+		if old, ok := p.recurseNames[from][""]; ok {
+			rec.Expires = old.Expires
+		}
+		return p.applyPinChanges(pinChange{c: to, mode: Recursive, rec: rec})
+	}
+
+	// the new pins keep the meta and expiry of the ones they replace
+	var changes []pinChange
+	for name, rec := range p.recurseNames[from] {
+		changes = append(changes,
+			pinChange{c: to, mode: Recursive, name: name, rec: rec},
+			pinChange{c: from, mode: Recursive, name: name})
+	}
+	return p.applyPinChanges(changes...)
 }
 
 // Flush encodes and writes pinner keysets to the datastore
@@ -540,6 +687,11 @@ func (p *pinner) Flush() error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
+	if p.perKey {
+		// every change has already been written
+		return nil
+	}
+
 	ctx := context.TODO()
 
 	internalset := cid.NewSet()
@@ -603,10 +755,10 @@ func (p *pinner) PinWithMode(c cid.Cid, mode Mode) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 	switch mode {
-	case Recursive:
-		p.recursePin.Add(c)
-	case Direct:
-		p.directPin.Add(c)
+	case Recursive, Direct:
+		if err := p.applyPinChanges(pinChange{c: c, mode: mode, rec: &pinRecord{}}); err != nil {
+			log.Errorf("failed to add pin %s: %s", c, err)
+		}
 	}
 }
 
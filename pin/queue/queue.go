@@ -0,0 +1,350 @@
+// Package queue implements the queue of the pins added in the background.
+//
+// Requests are stored in the datastore as soon as they change state, so that
+// the requests queued or being pinned when the daemon stops are resumed when
+// it starts again. Finished requests are kept for Retention.
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("pin/queue")
+
+// DefaultWorkers is the number of requests pinned at the same time.
+const DefaultWorkers = 4
+
+// Requests are stored under /pinqueue/<id>.
+var queueKeyPrefix = ds.NewKey("/pinqueue")
+
+// Retention is the time pinned and failed requests are kept for, unless they
+// are removed before.
+var Retention = 7 * 24 * time.Hour
+
+// ErrNotFound is returned for unknown request ids.
+var ErrNotFound = errors.New("no such pin request")
+
+// Status is the state of a request.
+type Status string
+
+const (
+	Queued  Status = "queued"
+	Pinning Status = "pinning"
+	Pinned  Status = "pinned"
+	Failed  Status = "failed"
+)
+
+// Request is a pin added in the background.
+type Request struct {
+	ID        string
+	Path      string
+	Recursive bool
+	Name      string            `json:",omitempty"`
+	Meta      map[string]string `json:",omitempty"`
+	Expires   *time.Time        `json:",omitempty"`
+
+	Status   Status
+	Bytes    uint64 // fetched so far
+	Error    string `json:",omitempty"`
+	Created  time.Time
+	Finished *time.Time `json:",omitempty"`
+}
+
+// PinFunc pins the content of a request, reporting the number of bytes
+// fetched so far to progress.
+type PinFunc func(ctx context.Context, r *Request, progress func(bytes uint64)) error
+
+// Queue holds the pin requests and pins them once started.
+type Queue struct {
+	dstore ds.Datastore
+
+	lk       sync.Mutex
+	reqs     map[string]*Request
+	queued   []*Request                    // oldest first, may hold removed requests
+	finished []*Request                    // in the order they finished, may hold removed requests
+	cancels  map[string]context.CancelFunc // of the requests being pinned
+	running  bool
+	wake     chan struct{}
+}
+
+// New loads the queue stored in d. The requests which were being pinned are
+// queued again.
+func New(d ds.Datastore) (*Queue, error) {
+	q := &Queue{
+		dstore:  d,
+		reqs:    make(map[string]*Request),
+		cancels: make(map[string]context.CancelFunc),
+		wake:    make(chan struct{}, 1),
+	}
+
+	res, err := d.Query(query.Query{Prefix: queueKeyPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		r := new(Request)
+		if err := json.Unmarshal(e.Value, r); err != nil {
+			return nil, fmt.Errorf("invalid pin request %s: %s", e.Key, err)
+		}
+		switch r.Status {
+		case Pinning:
+			r.Status = Queued
+			r.Bytes = 0
+			fallthrough
+		case Queued:
+			q.queued = append(q.queued, r)
+		default:
+			if r.Finished == nil {
+				// stored before requests recorded it
+				now := time.Now().UTC()
+				r.Finished = &now
+			}
+			q.finished = append(q.finished, r)
+		}
+		q.reqs[r.ID] = r
+	}
+	sort.Slice(q.queued, func(i, j int) bool { return q.queued[i].Created.Before(q.queued[j].Created) })
+	sort.Slice(q.finished, func(i, j int) bool { return q.finished[i].Finished.Before(*q.finished[j].Finished) })
+	q.prune()
+	return q, nil
+}
+
+// Add queues a request, filling its id, status and creation time, and returns
+// a copy of it.
+func (q *Queue) Add(r Request) (Request, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Request{}, err
+	}
+	r.ID = hex.EncodeToString(id)
+	r.Status = Queued
+	r.Bytes = 0
+	r.Error = ""
+	r.Created = time.Now().UTC()
+	r.Finished = nil
+
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	if err := q.store(&r); err != nil {
+		return Request{}, err
+	}
+	q.reqs[r.ID] = &r
+	q.queued = append(q.queued, &r)
+	q.signal()
+	return r, nil
+}
+
+// Get returns a copy of the request with the given id.
+func (q *Queue) Get(id string) (Request, error) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	r, ok := q.reqs[id]
+	if !ok {
+		return Request{}, ErrNotFound
+	}
+	return *r, nil
+}
+
+// List returns copies of all requests, oldest first.
+func (q *Queue) List() []Request {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	out := make([]Request, 0, len(q.reqs))
+	for _, r := range q.reqs {
+		out = append(out, *r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out
+}
+
+// Remove forgets the request with the given id, cancelling it if it is being
+// pinned. The content already pinned stays pinned.
+func (q *Queue) Remove(id string) error {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	if _, ok := q.reqs[id]; !ok {
+		return ErrNotFound
+	}
+	if cancel, ok := q.cancels[id]; ok {
+		cancel()
+	}
+	if err := q.dstore.Delete(queueKeyPrefix.ChildString(id)); err != nil && err != ds.ErrNotFound {
+		return err
+	}
+	delete(q.reqs, id)
+	return nil
+}
+
+// Running returns whether the queue was started.
+func (q *Queue) Running() bool {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	return q.running
+}
+
+// Start pins the queued requests with pin, workers at a time, until ctx is
+// done. The requests interrupted by ctx are queued again.
+func (q *Queue) Start(ctx context.Context, workers int, pin PinFunc) {
+	q.lk.Lock()
+	q.running = true
+	q.lk.Unlock()
+
+	for i := 0; i < workers; i++ {
+		go q.work(ctx, pin)
+	}
+	go func() {
+		<-ctx.Done()
+		q.lk.Lock()
+		q.running = false
+		q.lk.Unlock()
+	}()
+}
+
+func (q *Queue) work(ctx context.Context, pin PinFunc) {
+	for ctx.Err() == nil {
+		r, rctx, cancel := q.next(ctx)
+		if r == nil {
+			select {
+			case <-q.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		// more requests may be waiting for an idle worker
+		q.signal()
+
+		progress := func(bytes uint64) {
+			q.lk.Lock()
+			if cur, ok := q.reqs[r.ID]; ok {
+				cur.Bytes = bytes
+			}
+			q.lk.Unlock()
+		}
+		err := pin(rctx, r, progress)
+		cancel()
+		q.finish(ctx, r.ID, err)
+	}
+}
+
+// next marks the oldest queued request as being pinned, and returns a copy of
+// it with the context to pin it with. It returns a nil request if there is
+// none.
+func (q *Queue) next(ctx context.Context) (*Request, context.Context, context.CancelFunc) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	var next *Request
+	for next == nil && len(q.queued) > 0 {
+		r := q.queued[0]
+		q.queued[0] = nil
+		q.queued = q.queued[1:]
+		if q.reqs[r.ID] == r && r.Status == Queued {
+			next = r
+		}
+	}
+	if next == nil {
+		return nil, nil, nil
+	}
+
+	next.Status = Pinning
+	next.Bytes = 0
+	if err := q.store(next); err != nil {
+		log.Errorf("could not store pin request %s: %s", next.ID, err)
+	}
+	rctx, cancel := context.WithCancel(ctx)
+	q.cancels[next.ID] = cancel
+	r := *next
+	return &r, rctx, cancel
+}
+
+// finish records the outcome of pinning the request with the given id.
+func (q *Queue) finish(ctx context.Context, id string, err error) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	delete(q.cancels, id)
+
+	r, ok := q.reqs[id]
+	if !ok {
+		// removed while pinning
+		return
+	}
+	switch {
+	case err == nil:
+		r.Status = Pinned
+		r.Error = ""
+	case ctx.Err() != nil:
+		// the daemon is stopping, resume the request next time
+		r.Status = Queued
+		r.Bytes = 0
+	default:
+		r.Status = Failed
+		r.Error = err.Error()
+		log.Warningf("background pin of %s failed: %s", r.Path, err)
+	}
+	if r.Status == Queued {
+		i := sort.Search(len(q.queued), func(i int) bool { return !q.queued[i].Created.Before(r.Created) })
+		q.queued = append(q.queued, nil)
+		copy(q.queued[i+1:], q.queued[i:])
+		q.queued[i] = r
+	} else {
+		now := time.Now().UTC()
+		r.Finished = &now
+		q.finished = append(q.finished, r)
+	}
+	if err := q.store(r); err != nil {
+		log.Errorf("could not store pin request %s: %s", id, err)
+	}
+	q.prune()
+}
+
+// prune forgets the requests finished more than Retention ago. q.lk is held.
+func (q *Queue) prune() {
+	cutoff := time.Now().Add(-Retention)
+	for len(q.finished) > 0 && q.finished[0].Finished.Before(cutoff) {
+		r := q.finished[0]
+		q.finished[0] = nil
+		q.finished = q.finished[1:]
+		if q.reqs[r.ID] != r {
+			// already removed
+			continue
+		}
+		if err := q.dstore.Delete(queueKeyPrefix.ChildString(r.ID)); err != nil && err != ds.ErrNotFound {
+			log.Errorf("could not remove pin request %s: %s", r.ID, err)
+			continue
+		}
+		delete(q.reqs, r.ID)
+	}
+}
+
+// store writes r to the datastore. q.lk is held.
+func (q *Queue) store(r *Request) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return q.dstore.Put(queueKeyPrefix.ChildString(r.ID), b)
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,145 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+)
+
+func waitStatus(t *testing.T, q *Queue, id string, status Status) Request {
+	for i := 0; i < 100; i++ {
+		r, err := q.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Status == status {
+			return r
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("request %s never became %s", id, status)
+	return Request{}
+}
+
+func TestQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q, err := New(dssync.MutexWrap(ds.NewMapDatastore()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := q.Add(Request{Path: "/ipfs/ok", Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := q.Add(Request{Path: "/ipfs/bad"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := q.Get(ok.ID); r.Status != Queued {
+		t.Fatalf("expected the request to be queued, got %s", r.Status)
+	}
+
+	q.Start(ctx, 2, func(ctx context.Context, r *Request, progress func(uint64)) error {
+		progress(42)
+		if r.Path == "/ipfs/bad" {
+			return errors.New("not found")
+		}
+		return nil
+	})
+
+	r := waitStatus(t, q, ok.ID, Pinned)
+	if r.Bytes != 42 {
+		t.Fatalf("expected 42 bytes fetched, got %d", r.Bytes)
+	}
+	r = waitStatus(t, q, bad.ID, Failed)
+	if r.Error != "not found" {
+		t.Fatalf("unexpected error %q", r.Error)
+	}
+
+	if l := q.List(); len(l) != 2 || l[0].ID != ok.ID {
+		t.Fatalf("unexpected listing %v", l)
+	}
+	if err := q.Remove(bad.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Get(bad.ID); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestQueueResume(t *testing.T) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	q, err := New(dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := q.Add(Request{Path: "/ipfs/slow"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stop the daemon while the request is being pinned
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	q.Start(ctx, 1, func(ctx context.Context, r *Request, progress func(uint64)) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	<-started
+	cancel()
+	waitStatus(t, q, req.ID, Queued)
+
+	q, err = New(dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	q.Start(ctx, 1, func(ctx context.Context, r *Request, progress func(uint64)) error {
+		return nil
+	})
+	waitStatus(t, q, req.ID, Pinned)
+}
+
+func TestQueueRetention(t *testing.T) {
+	defer func(d time.Duration) { Retention = d }(Retention)
+	Retention = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	q, err := New(dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := q.Add(Request{Path: "/ipfs/ok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Start(ctx, 1, func(ctx context.Context, r *Request, progress func(uint64)) error {
+		return nil
+	})
+
+	// the request is forgotten once pinned
+	for i := 0; ; i++ {
+		if _, err := q.Get(req.ID); err == ErrNotFound {
+			break
+		}
+		if i == 100 {
+			t.Fatal("expected the finished request to be pruned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if has, _ := dstore.Has(queueKeyPrefix.ChildString(req.ID)); has {
+		t.Fatal("expected the finished request to be removed from the datastore")
+	}
+}
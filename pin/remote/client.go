@@ -0,0 +1,225 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client is a client of a pinning service.
+type Client struct {
+	endpoint string
+	key      string
+	http     *http.Client
+}
+
+// NewClient returns a client of the pinning service at endpoint, the URL the
+// /pins path of the API is relative to, authenticated with key.
+func NewClient(endpoint, key string) *Client {
+	return &Client{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		key:      key,
+		http:     http.DefaultClient,
+	}
+}
+
+// LsOptions filters the pin requests listed by Ls. Empty fields don't filter.
+type LsOptions struct {
+	Cids   []string
+	Name   string
+	Status []Status // pinned only if empty
+	Before time.Time
+	After  time.Time
+	Meta   map[string]string
+
+	// Limit is the maximum number of requests returned, 10 by default.
+	Limit int
+}
+
+func (o *LsOptions) query() (url.Values, error) {
+	q := make(url.Values)
+	if len(o.Cids) > 0 {
+		q.Set("cid", strings.Join(o.Cids, ","))
+	}
+	if o.Name != "" {
+		q.Set("name", o.Name)
+	}
+	if len(o.Status) > 0 {
+		strs := make([]string, len(o.Status))
+		for i, s := range o.Status {
+			strs[i] = string(s)
+		}
+		q.Set("status", strings.Join(strs, ","))
+	}
+	if !o.Before.IsZero() {
+		q.Set("before", o.Before.UTC().Format(time.RFC3339Nano))
+	}
+	if !o.After.IsZero() {
+		q.Set("after", o.After.UTC().Format(time.RFC3339Nano))
+	}
+	if len(o.Meta) > 0 {
+		b, err := json.Marshal(o.Meta)
+		if err != nil {
+			return nil, err
+		}
+		q.Set("meta", string(b))
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	return q, nil
+}
+
+// Ls lists a page of the pin requests matching opts, newest first.
+func (c *Client) Ls(ctx context.Context, opts LsOptions) (*PinResults, error) {
+	q, err := opts.query()
+	if err != nil {
+		return nil, err
+	}
+	res := new(PinResults)
+	if err := c.do(ctx, "GET", "/pins", q, nil, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// LsAll lists all the pin requests matching opts, newest first, fetching them
+// page by page. opts.Limit is the size of the pages.
+func (c *Client) LsAll(ctx context.Context, opts LsOptions) ([]PinStatus, error) {
+	limit := opts.Limit
+	if limit == 0 {
+		limit = defaultLsLimit
+	}
+
+	var all []PinStatus
+	seen := make(map[string]bool)
+	for {
+		res, err := c.Ls(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		added := 0
+		for _, st := range res.Results {
+			if !seen[st.RequestID] {
+				seen[st.RequestID] = true
+				all = append(all, st)
+				added++
+			}
+		}
+		// Count is the number of requests left from the cursor, so only a
+		// short page tells there are no more
+		if len(res.Results) < limit {
+			return all, nil
+		}
+
+		// Services may create several requests at the same time, so the
+		// next page starts with the time of the last request, the requests
+		// already listed being skipped. Once a whole page was listed, the
+		// next one starts right before that time: the requests sharing the
+		// time of a page full of them can't all be listed.
+		last := res.Results[len(res.Results)-1].Created
+		next := last.Add(time.Nanosecond)
+		if added == 0 {
+			next = last
+			if !opts.Before.IsZero() && !next.Before(opts.Before) {
+				// the service doesn't page with before
+				return all, nil
+			}
+		}
+		opts.Before = next
+	}
+}
+
+// Add asks the service to pin p.
+func (c *Client) Add(ctx context.Context, p Pin) (*PinStatus, error) {
+	st := new(PinStatus)
+	if err := c.do(ctx, "POST", "/pins", nil, &p, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Get returns the pin request with the given id.
+func (c *Client) Get(ctx context.Context, id string) (*PinStatus, error) {
+	st := new(PinStatus)
+	if err := c.do(ctx, "GET", "/pins/"+url.PathEscape(id), nil, nil, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Replace replaces the pin request with the given id with a request to pin
+// p, which gets a new id.
+func (c *Client) Replace(ctx context.Context, id string, p Pin) (*PinStatus, error) {
+	st := new(PinStatus)
+	if err := c.do(ctx, "POST", "/pins/"+url.PathEscape(id), nil, &p, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Remove removes the pin request with the given id.
+func (c *Client) Remove(ctx context.Context, id string) error {
+	return c.do(ctx, "DELETE", "/pins/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// do sends a request with in as JSON body, decoding the JSON response into
+// out. Error responses are returned as *Error.
+func (c *Client) do(ctx context.Context, method, path string, q url.Values, in, out interface{}) error {
+	u := c.endpoint + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+c.key)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var er errorResponse
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		if err := json.Unmarshal(b, &er); err != nil || er.Error == nil {
+			er.Error = &Error{Reason: http.StatusText(resp.StatusCode), Details: strings.TrimSpace(string(b))}
+		}
+		er.Error.Code = resp.StatusCode
+		return er.Error
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from %s: %s", c.endpoint, err)
+	}
+	return nil
+}
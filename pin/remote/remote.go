@@ -0,0 +1,109 @@
+// Package remote implements the Pinning Services API, which lets a node ask a
+// pinning service to pin content for it, both as a client and as a server
+// backed by a pin.Pinner.
+//
+// See https://ipfs.github.io/pinning-services-api-spec/ for the API.
+package remote
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-ipfs/repo"
+)
+
+// Status is the status of a pin request.
+type Status string
+
+const (
+	Queued  Status = "queued"
+	Pinning Status = "pinning"
+	Pinned  Status = "pinned"
+	Failed  Status = "failed"
+)
+
+// ParseStatus parses a status.
+func ParseStatus(s string) (Status, error) {
+	switch st := Status(s); st {
+	case Queued, Pinning, Pinned, Failed:
+		return st, nil
+	}
+	return "", fmt.Errorf("invalid status %q, must be one of {queued, pinning, pinned, failed}", s)
+}
+
+// Pin is the object pinned by a request.
+type Pin struct {
+	Cid     string            `json:"cid"`
+	Name    string            `json:"name,omitempty"`
+	Origins []string          `json:"origins,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+// PinStatus is a pin request.
+type PinStatus struct {
+	RequestID string            `json:"requestid"`
+	Status    Status            `json:"status"`
+	Created   time.Time         `json:"created"`
+	Pin       Pin               `json:"pin"`
+	Delegates []string          `json:"delegates"`
+	Info      map[string]string `json:"info,omitempty"`
+}
+
+// PinResults is a page of pin requests. Count is the total number of requests
+// matching the query.
+type PinResults struct {
+	Count   int         `json:"count"`
+	Results []PinStatus `json:"results"`
+}
+
+// Error is an error returned by a pinning service.
+type Error struct {
+	Code    int    `json:"-"` // HTTP status code
+	Reason  string `json:"reason"`
+	Details string `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Details != "" {
+		return fmt.Sprintf("%s: %s", e.Reason, e.Details)
+	}
+	return e.Reason
+}
+
+type errorResponse struct {
+	Error *Error `json:"error"`
+}
+
+// ConfigKey is the config key holding the remote pinning services the node
+// uses, by name.
+const ConfigKey = "Pinning.RemoteServices"
+
+// Service is a remote pinning service in the config.
+type Service struct {
+	API ServiceAPI
+}
+
+// ServiceAPI locates a pinning service and holds the key to access it.
+type ServiceAPI struct {
+	Endpoint string
+	Key      string
+}
+
+// LoadServices reads the remote pinning services configured in r, by name.
+func LoadServices(r repo.Repo) (map[string]*Service, error) {
+	services := make(map[string]*Service)
+	if _, err := repo.ReadExtendedConfig(r, ConfigKey, &services); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", ConfigKey, err)
+	}
+	for name, s := range services {
+		if s == nil || s.API.Endpoint == "" {
+			return nil, fmt.Errorf("invalid %s: service %s has no endpoint", ConfigKey, name)
+		}
+	}
+	return services, nil
+}
+
+// SaveServices replaces the remote pinning services configured in r.
+func SaveServices(r repo.Repo, services map[string]*Service) error {
+	return r.SetConfigKey(ConfigKey, services)
+}
@@ -0,0 +1,261 @@
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/pin"
+
+	bs "github.com/ipfs/go-blockservice"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	mdag "github.com/ipfs/go-merkledag"
+)
+
+func waitPinned(t *testing.T, ctx context.Context, c *Client, id string) *PinStatus {
+	for i := 0; i < 100; i++ {
+		st, err := c.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Status == Pinned || st.Status == Failed {
+			return st
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("request %s never finished", id)
+	return nil
+}
+
+// ownedBy serves s on behalf of the key of each request.
+func ownedBy(s *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.ServeHTTP(w, WithOwner(r, r.Header.Get("Authorization")))
+	})
+}
+
+func TestRemotePinning(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	dserv := mdag.NewDAGService(bs.New(bstore, offline.Exchange(bstore)))
+	a, b := mdag.NodeWithData([]byte("a")), mdag.NodeWithData([]byte("b"))
+	for _, nd := range []*mdag.ProtoNode{a, b} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pinner, err := pin.LoadDatastorePinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(ctx, pinner, dserv, blockstore.NewGCLocker())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Delegates = []string{"/ip4/127.0.0.1/tcp/4001"}
+	ts := httptest.NewServer(ownedBy(server))
+	defer ts.Close()
+	c := NewClient(ts.URL, "secret")
+
+	st, err := c.Add(ctx, Pin{Cid: a.Cid().String(), Name: "a", Meta: map[string]string{"team": "x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Delegates) != 1 {
+		t.Fatalf("expected the delegates to be returned, got %v", st.Delegates)
+	}
+	if st = waitPinned(t, ctx, c, st.RequestID); st.Status != Pinned {
+		t.Fatalf("expected the request to be pinned, got %s %v", st.Status, st.Info)
+	}
+	if _, pinned, _ := pinner.IsPinned(a.Cid()); !pinned {
+		t.Fatal("content not pinned")
+	}
+	aID := st.RequestID
+
+	st, err = c.Add(ctx, Pin{Cid: b.Cid().String(), Name: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitPinned(t, ctx, c, st.RequestID)
+	bID := st.RequestID
+
+	res, err := c.Ls(ctx, LsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Count != 2 || res.Results[0].Pin.Name != "b" {
+		t.Fatalf("unexpected listing %+v", res)
+	}
+	res, err = c.Ls(ctx, LsOptions{Meta: map[string]string{"team": "x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Count != 1 || res.Results[0].RequestID != aID {
+		t.Fatalf("unexpected listing by meta %+v", res)
+	}
+
+	// list over several pages
+	for _, data := range []string{"c", "d", "e"} {
+		nd := mdag.NodeWithData([]byte(data))
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		st, err := c.Add(ctx, Pin{Cid: nd.Cid().String(), Name: data})
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitPinned(t, ctx, c, st.RequestID)
+	}
+	for _, limit := range []int{1, 2, 5, 10} {
+		all, err := c.LsAll(ctx, LsOptions{Limit: limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 5 {
+			t.Fatalf("expected 5 requests over all pages of %d, got %d", limit, len(all))
+		}
+	}
+
+	// replacing a request unpins its content once the new one is pinned
+	shared := mdag.NodeWithData([]byte("shared"))
+	if err := shared.AddNodeLink("b", b); err != nil {
+		t.Fatal(err)
+	}
+	if err := dserv.Add(ctx, shared); err != nil {
+		t.Fatal(err)
+	}
+	st, err = c.Replace(ctx, bID, Pin{Cid: shared.Cid().String(), Name: "shared"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st = waitPinned(t, ctx, c, st.RequestID); st.Status != Pinned {
+		t.Fatalf("expected the replacing request to be pinned, got %s %v", st.Status, st.Info)
+	}
+	if _, err := c.Get(ctx, bID); err == nil {
+		t.Fatal("expected the replaced request to be gone")
+	}
+	if _, pinned, _ := pinner.IsPinned(shared.Cid()); !pinned {
+		t.Fatal("replacing content not pinned")
+	}
+	if reason, pinned, _ := pinner.IsPinned(b.Cid()); !pinned || reason == "recursive" {
+		t.Fatalf("expected the replaced content to only be pinned indirectly, got %q", reason)
+	}
+	if _, err := c.Ls(ctx, LsOptions{Status: []Status{"bogus"}}); err == nil {
+		t.Fatal("expected an invalid status to be rejected")
+	} else if e, ok := err.(*Error); !ok || e.Code != http.StatusBadRequest {
+		t.Fatalf("expected a 400 error, got %v", err)
+	}
+
+	// the pinned requests are loaded again on restart
+	pinner, err = pin.LoadDatastorePinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted, err := NewServer(ctx, pinner, dserv, blockstore.NewGCLocker())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts2 := httptest.NewServer(ownedBy(restarted))
+	defer ts2.Close()
+	c = NewClient(ts2.URL, "secret")
+	st, err = c.Get(ctx, aID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Status != Pinned || st.Pin.Name != "a" || st.Pin.Meta["team"] != "x" {
+		t.Fatalf("request not restored: %+v", st)
+	}
+	if st.Delegates == nil {
+		t.Fatal("expected the delegates of a restored request to be an array")
+	}
+
+	// the requests of a key are hidden from the others
+	other := NewClient(ts2.URL, "other")
+	if res, err := other.Ls(ctx, LsOptions{}); err != nil || res.Count != 0 {
+		t.Fatalf("expected no request to be listed, got %+v %v", res, err)
+	}
+	if _, err := other.Get(ctx, aID); err == nil {
+		t.Fatal("expected the request of another key to be hidden")
+	}
+	if _, err := other.Replace(ctx, aID, Pin{Cid: b.Cid().String()}); err == nil {
+		t.Fatal("expected the request of another key not to be replaced")
+	}
+	if err := other.Remove(ctx, aID); err == nil {
+		t.Fatal("expected the request of another key not to be removed")
+	}
+
+	if err := c.Remove(ctx, aID); err != nil {
+		t.Fatal(err)
+	}
+	if _, pinned, _ := pinner.IsPinned(a.Cid()); pinned {
+		t.Fatal("content still pinned after removing the request")
+	}
+	if _, err := c.Get(ctx, aID); err == nil {
+		t.Fatal("expected the removed request to be gone")
+	} else if e, ok := err.(*Error); !ok || e.Code != http.StatusNotFound {
+		t.Fatalf("expected a 404 error, got %v", err)
+	}
+}
+
+func TestLsAllPages(t *testing.T) {
+	ctx := context.Background()
+
+	// requests created at the same second, newest first
+	now := time.Now().UTC().Truncate(time.Second)
+	var reqs []PinStatus
+	for i, created := range []time.Time{now, now.Add(-time.Second), now.Add(-2 * time.Second), now.Add(-2 * time.Second), now.Add(-3 * time.Second)} {
+		reqs = append(reqs, PinStatus{RequestID: strconv.Itoa(i), Status: Pinned, Created: created})
+	}
+
+	ignoreBefore := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var before time.Time
+		if v := r.URL.Query().Get("before"); v != "" && !ignoreBefore {
+			before, _ = time.Parse(time.RFC3339Nano, v)
+		}
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		res := PinResults{Results: []PinStatus{}}
+		for _, st := range reqs {
+			if before.IsZero() || st.Created.Before(before) {
+				res.Results = append(res.Results, st)
+			}
+		}
+		res.Count = len(res.Results)
+		if len(res.Results) > limit {
+			res.Results = res.Results[:limit]
+		}
+		writeJSON(w, http.StatusOK, &res)
+	}))
+	defer ts.Close()
+	c := NewClient(ts.URL, "secret")
+
+	for _, limit := range []int{2, 3, 10} {
+		all, err := c.LsAll(ctx, LsOptions{Limit: limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != len(reqs) {
+			t.Fatalf("expected %d requests over all pages of %d, got %d", len(reqs), limit, len(all))
+		}
+	}
+
+	// a service ignoring before ends the listing
+	ignoreBefore = true
+	all, err := c.LsAll(ctx, LsOptions{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("expected the first page, got %d requests", len(all))
+	}
+}
@@ -0,0 +1,542 @@
+package remote
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-ipfs/pin"
+
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log"
+	mdag "github.com/ipfs/go-merkledag"
+)
+
+var log = logging.Logger("pin/remote")
+
+// DefaultConcurrency is the number of requests a server pins at the same time.
+const DefaultConcurrency = 4
+
+// The server pins the content of request <id> under the name
+// "pinning-service/<id>", recording the request in the meta of the pin.
+const (
+	pinNamePrefix   = "pinning-service/"
+	nameMetaKey     = "name"
+	createdMetaKey  = "created"
+	ownerMetaKey    = "owner"
+	metaMetaPrefix  = "meta."
+	defaultLsLimit  = 10
+	maxLsLimit      = 1000
+	maxRequestBytes = 1 << 20
+)
+
+// Server serves the Pinning Services API, pinning the requested content with
+// a pin.Pinner. It is meant to be mounted under the endpoint given to
+// clients, with authentication done in front of it. The handler doing it
+// tells the server who makes each request with WithOwner: the clients only
+// see and change the pin requests of their owner.
+//
+// Pinned requests are kept across restarts as the named pins they are held
+// by, requests still queued or being pinned when the server stops are lost.
+type Server struct {
+	// Delegates are the multiaddrs returned to the clients, for them to
+	// connect to the server node and send it the content.
+	Delegates []string
+
+	// Connect, if set, connects to the origins of a request before its
+	// content is fetched.
+	Connect func(ctx context.Context, origins []string)
+
+	ctx    context.Context
+	pinner pin.Pinner
+	dag    ipld.DAGService
+	locker bstore.GCLocker
+	sem    chan struct{}
+
+	lk   sync.Mutex
+	reqs map[string]*request
+}
+
+type request struct {
+	PinStatus
+	cid    cid.Cid
+	owner  string
+	cancel context.CancelFunc // of the pinning, nil once it finished
+
+	// replaced is the pinned request replaced by this one, unpinned once
+	// this one is pinned so that the blocks they share stay pinned.
+	replaced *request
+}
+
+// NewServer returns a server pinning the content fetched through dag with
+// pinner, taking the pin lock of locker to pin it. The requests pinned by
+// a previous server are loaded from pinner, and the requests are pinned
+// until ctx is done.
+func NewServer(ctx context.Context, pinner pin.Pinner, dag ipld.DAGService, locker bstore.GCLocker) (*Server, error) {
+	s := &Server{
+		ctx:    ctx,
+		pinner: pinner,
+		dag:    dag,
+		locker: locker,
+		sem:    make(chan struct{}, DefaultConcurrency),
+		reqs:   make(map[string]*request),
+	}
+
+	for _, pi := range pinner.NamedPins() {
+		if pi.Mode != pin.Recursive || !strings.HasPrefix(pi.Name, pinNamePrefix) {
+			continue
+		}
+		id := strings.TrimPrefix(pi.Name, pinNamePrefix)
+		created, err := time.Parse(time.RFC3339Nano, pi.Meta[createdMetaKey])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pin %s of request %s: %s", pi.Key, id, err)
+		}
+		var meta map[string]string
+		for k, v := range pi.Meta {
+			if strings.HasPrefix(k, metaMetaPrefix) {
+				if meta == nil {
+					meta = make(map[string]string)
+				}
+				meta[strings.TrimPrefix(k, metaMetaPrefix)] = v
+			}
+		}
+		s.reqs[id] = &request{
+			PinStatus: PinStatus{
+				RequestID: id,
+				Status:    Pinned,
+				Created:   created,
+				Pin:       Pin{Cid: pi.Key.String(), Name: pi.Meta[nameMetaKey], Meta: meta},
+			},
+			cid:   pi.Key,
+			owner: pi.Meta[ownerMetaKey],
+		}
+	}
+	return s, nil
+}
+
+type ownerKey struct{}
+
+// WithOwner returns a copy of r made on behalf of owner, such as the name of
+// the API token r was authenticated with.
+func WithOwner(r *http.Request, owner string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), ownerKey{}, owner))
+}
+
+// ownerOf returns the owner r was made on behalf of, "" if none was set.
+func ownerOf(r *http.Request) string {
+	owner, _ := r.Context().Value(ownerKey{}).(string)
+	return owner
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case path == "pins":
+		switch r.Method {
+		case http.MethodGet:
+			s.ls(w, r)
+		case http.MethodPost:
+			s.add(w, r, "")
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed", r.Method)
+		}
+	case strings.HasPrefix(path, "pins/"):
+		id := strings.TrimPrefix(path, "pins/")
+		switch r.Method {
+		case http.MethodGet:
+			s.get(w, r, id)
+		case http.MethodPost:
+			s.add(w, r, id)
+		case http.MethodDelete:
+			s.rm(w, r, id)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed", r.Method)
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found", r.URL.Path)
+	}
+}
+
+func (s *Server) ls(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var cids map[string]bool
+	if v := q.Get("cid"); v != "" {
+		cids = make(map[string]bool)
+		for _, str := range strings.Split(v, ",") {
+			c, err := cid.Decode(str)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid cid", err.Error())
+				return
+			}
+			cids[c.String()] = true
+		}
+	}
+
+	matchName, err := nameMatcher(q.Get("name"), q.Get("match"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid match", err.Error())
+		return
+	}
+
+	statuses := map[Status]bool{Pinned: true}
+	if v := q.Get("status"); v != "" {
+		statuses = make(map[Status]bool)
+		for _, str := range strings.Split(v, ",") {
+			st, err := ParseStatus(str)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid status", err.Error())
+				return
+			}
+			statuses[st] = true
+		}
+	}
+
+	var before, after time.Time
+	for _, t := range []struct {
+		key string
+		dst *time.Time
+	}{{"before", &before}, {"after", &after}} {
+		if v := q.Get(t.key); v != "" {
+			if *t.dst, err = time.Parse(time.RFC3339Nano, v); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid "+t.key, err.Error())
+				return
+			}
+		}
+	}
+
+	limit := defaultLsLimit
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxLsLimit {
+			writeError(w, http.StatusBadRequest, "invalid limit", fmt.Sprintf("must be between 1 and %d", maxLsLimit))
+			return
+		}
+	}
+
+	var meta map[string]string
+	if v := q.Get("meta"); v != "" {
+		if err := json.Unmarshal([]byte(v), &meta); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid meta", err.Error())
+			return
+		}
+	}
+
+	owner := ownerOf(r)
+	res := PinResults{Results: []PinStatus{}}
+	s.lk.Lock()
+	for _, req := range s.reqs {
+		if req.owner != owner {
+			continue
+		}
+		st := s.status(req)
+		if (cids != nil && !cids[st.Pin.Cid]) || !matchName(st.Pin.Name) || !statuses[st.Status] ||
+			(!before.IsZero() && !st.Created.Before(before)) || (!after.IsZero() && !st.Created.After(after)) ||
+			!metaMatches(st.Pin.Meta, meta) {
+			continue
+		}
+		res.Results = append(res.Results, st)
+	}
+	s.lk.Unlock()
+
+	sort.Slice(res.Results, func(i, j int) bool { return res.Results[i].Created.After(res.Results[j].Created) })
+	res.Count = len(res.Results)
+	if len(res.Results) > limit {
+		res.Results = res.Results[:limit]
+	}
+	writeJSON(w, http.StatusOK, &res)
+}
+
+// nameMatcher returns a function matching the pin names against name, with
+// the strategy of the API: exact (the default), iexact, partial or ipartial.
+func nameMatcher(name, match string) (func(string) bool, error) {
+	if name == "" {
+		return func(string) bool { return true }, nil
+	}
+	switch match {
+	case "", "exact":
+		return func(n string) bool { return n == name }, nil
+	case "iexact":
+		return func(n string) bool { return strings.EqualFold(n, name) }, nil
+	case "partial":
+		return func(n string) bool { return strings.Contains(n, name) }, nil
+	case "ipartial":
+		name = strings.ToLower(name)
+		return func(n string) bool { return strings.Contains(strings.ToLower(n), name) }, nil
+	}
+	return nil, fmt.Errorf("%q must be one of {exact, iexact, partial, ipartial}", match)
+}
+
+func metaMatches(meta, filter map[string]string) bool {
+	for k, v := range filter {
+		if meta[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, id string) {
+	s.lk.Lock()
+	req, ok := s.reqs[id]
+	ok = ok && req.owner == ownerOf(r)
+	var st PinStatus
+	if ok {
+		st = s.status(req)
+	}
+	s.lk.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found", "no pin request "+id)
+		return
+	}
+	writeJSON(w, http.StatusOK, &st)
+}
+
+// status returns the status of req, with the delegates of the server. s.lk
+// must be held.
+func (s *Server) status(req *request) PinStatus {
+	st := req.PinStatus
+	st.Delegates = s.Delegates
+	if st.Delegates == nil {
+		st.Delegates = []string{}
+	}
+	return st
+}
+
+// add queues a new request, replacing the request with the given id if not
+// empty. The content of the replaced request stays pinned until the new
+// request is pinned.
+func (s *Server) add(w http.ResponseWriter, r *http.Request, replace string) {
+	var p Pin
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&p); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid pin", err.Error())
+		return
+	}
+	c, err := cid.Decode(p.Cid)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid cid", err.Error())
+		return
+	}
+	p.Cid = c.String()
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal error", err.Error())
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	req := &request{
+		PinStatus: PinStatus{
+			RequestID: hex.EncodeToString(b),
+			Status:    Queued,
+			Created:   time.Now().UTC(),
+			Pin:       p,
+		},
+		cid:    c,
+		owner:  ownerOf(r),
+		cancel: cancel,
+	}
+
+	if replace != "" {
+		replaced, err := s.forget(replace, req.owner)
+		if err != nil {
+			cancel()
+			writeError(w, http.StatusNotFound, "not found", err.Error())
+			return
+		}
+		if replaced.Status == Pinned {
+			req.replaced = replaced
+		} else if replaced.replaced != nil {
+			// still pinning, the content it replaces is now replaced by req
+			req.replaced = replaced.replaced
+		}
+	}
+
+	s.lk.Lock()
+	s.reqs[req.RequestID] = req
+	st := s.status(req)
+	s.lk.Unlock()
+
+	go s.pin(ctx, st, req.owner, c)
+	writeJSON(w, http.StatusAccepted, &st)
+}
+
+func (s *Server) rm(w http.ResponseWriter, r *http.Request, id string) {
+	if err := s.remove(id, ownerOf(r)); err != nil {
+		writeError(w, http.StatusNotFound, "not found", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// remove forgets the request of owner with the given id, cancelling it if it
+// is being pinned and unpinning its content if it was pinned.
+func (s *Server) remove(id, owner string) error {
+	req, err := s.forget(id, owner)
+	if err != nil {
+		return err
+	}
+	if req.replaced != nil {
+		if err := s.unpin(req.replaced.RequestID, req.replaced.cid); err != nil {
+			return err
+		}
+	}
+	if req.Status == Pinned {
+		return s.unpin(id, req.cid)
+	}
+	return nil
+}
+
+// forget removes the request of owner with the given id from the server,
+// cancelling it if it is being pinned, and returns it.
+func (s *Server) forget(id, owner string) (*request, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	req, ok := s.reqs[id]
+	if !ok || req.owner != owner {
+		return nil, fmt.Errorf("no pin request %s", id)
+	}
+	delete(s.reqs, id)
+	if req.cancel != nil {
+		req.cancel()
+	}
+	return req, nil
+}
+
+// pin fetches and pins the content of a request, once there are less than
+// DefaultConcurrency requests being pinned.
+func (s *Server) pin(ctx context.Context, st PinStatus, owner string, c cid.Cid) {
+	select {
+	case s.sem <- struct{}{}:
+		defer func() { <-s.sem }()
+	case <-ctx.Done():
+		s.finish(st.RequestID, c, ctx.Err())
+		return
+	}
+
+	s.lk.Lock()
+	req, ok := s.reqs[st.RequestID]
+	if ok {
+		req.Status = Pinning
+	}
+	s.lk.Unlock()
+	if !ok {
+		// removed while queued
+		return
+	}
+
+	if s.Connect != nil && len(st.Pin.Origins) > 0 {
+		s.Connect(ctx, st.Pin.Origins)
+	}
+
+	err := s.fetchAndPin(ctx, st, owner, c)
+	s.finish(st.RequestID, c, err)
+}
+
+func (s *Server) fetchAndPin(ctx context.Context, st PinStatus, owner string, c cid.Cid) error {
+	// fetch the content before taking the pin lock, which blocks the GC
+	if err := mdag.FetchGraph(ctx, c, s.dag); err != nil {
+		return err
+	}
+	nd, err := s.dag.Get(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	meta := map[string]string{
+		nameMetaKey:    st.Pin.Name,
+		createdMetaKey: st.Created.Format(time.RFC3339Nano),
+	}
+	if owner != "" {
+		meta[ownerMetaKey] = owner
+	}
+	for k, v := range st.Pin.Meta {
+		meta[metaMetaPrefix+k] = v
+	}
+
+	defer s.locker.PinLock().Unlock()
+	if err := s.pinner.PinNamed(ctx, nd, true, pinNamePrefix+st.RequestID, meta); err != nil {
+		return err
+	}
+	return s.pinner.Flush()
+}
+
+// finish records the outcome of pinning the request with the given id,
+// unpinning the request it replaces.
+func (s *Server) finish(id string, c cid.Cid, err error) {
+	// when the server stops, the replaced request is kept to be loaded
+	// again on restart
+	if s.ctx.Err() == nil {
+		var replaced *request
+		s.lk.Lock()
+		if req, ok := s.reqs[id]; ok {
+			replaced, req.replaced = req.replaced, nil
+		}
+		s.lk.Unlock()
+
+		// unpinned before the request is marked pinned, for the clients
+		// to see the outcome of the replacement at once
+		if replaced != nil {
+			if err := s.unpin(replaced.RequestID, replaced.cid); err != nil {
+				log.Errorf("could not unpin replaced request %s: %s", replaced.RequestID, err)
+			}
+		}
+	}
+
+	s.lk.Lock()
+	req, ok := s.reqs[id]
+	if ok {
+		if req.cancel != nil {
+			req.cancel()
+			req.cancel = nil
+		}
+		if err == nil {
+			req.Status = Pinned
+		} else {
+			req.Status = Failed
+			req.Info = map[string]string{"error": err.Error()}
+		}
+	}
+	s.lk.Unlock()
+
+	if !ok && err == nil {
+		// removed while being pinned
+		if err := s.unpin(id, c); err != nil {
+			log.Errorf("could not unpin removed request %s: %s", id, err)
+		}
+	}
+	if ok && err != nil {
+		log.Warningf("could not pin %s for request %s: %s", c, id, err)
+	}
+}
+
+func (s *Server) unpin(id string, c cid.Cid) error {
+	defer s.locker.PinLock().Unlock()
+	if err := s.pinner.UnpinNamed(s.ctx, c, true, pinNamePrefix+id); err != nil && err != pin.ErrNotPinned {
+		return err
+	}
+	return s.pinner.Flush()
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debugf("could not write response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, reason, details string) {
+	writeJSON(w, code, &errorResponse{Error: &Error{Reason: reason, Details: details}})
+}
@@ -0,0 +1,77 @@
+package quota
+
+import (
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// Blockstore refuses the writes of new blocks which would take the repo over
+// the maximum of a Quota, and accounts for the blocks written and deleted. The
+// Quota also learns from it when the pin lock is held, see Quota.reserve.
+type Blockstore struct {
+	bstore.GCBlockstore
+	Quota *Quota
+}
+
+func (bs *Blockstore) PinLock() bstore.Unlocker {
+	u := bs.GCBlockstore.PinLock()
+	bs.Quota.pinLocked(1)
+	return &pinUnlocker{u, bs.Quota}
+}
+
+type pinUnlocker struct {
+	bstore.Unlocker
+	quota *Quota
+}
+
+func (u *pinUnlocker) Unlock() {
+	u.Unlocker.Unlock()
+	u.quota.pinLocked(-1)
+}
+
+func (bs *Blockstore) Put(b blocks.Block) error {
+	return bs.PutMany([]blocks.Block{b})
+}
+
+func (bs *Blockstore) PutMany(blks []blocks.Block) error {
+	// blocks already stored take no more room
+	var size uint64
+	for _, b := range blks {
+		has, err := bs.GCBlockstore.Has(b.Cid())
+		if err != nil {
+			return err
+		}
+		if !has {
+			size += uint64(len(b.RawData()))
+		}
+	}
+
+	if size > 0 {
+		if err := bs.Quota.reserve(size); err != nil {
+			return err
+		}
+	}
+	var err error
+	if len(blks) == 1 {
+		err = bs.GCBlockstore.Put(blks[0])
+	} else {
+		err = bs.GCBlockstore.PutMany(blks)
+	}
+	if err != nil {
+		bs.Quota.release(size)
+	}
+	return err
+}
+
+func (bs *Blockstore) DeleteBlock(c cid.Cid) error {
+	size, err := bs.GCBlockstore.GetSize(c)
+	if err != nil {
+		return bs.GCBlockstore.DeleteBlock(c)
+	}
+	if err := bs.GCBlockstore.DeleteBlock(c); err != nil {
+		return err
+	}
+	bs.Quota.release(uint64(size))
+	return nil
+}
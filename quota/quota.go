@@ -0,0 +1,247 @@
+// Package quota enforces the StorageMax of a repo.
+//
+// A Quota tracks an estimate of the repo usage, refreshed by measuring the
+// repo after each garbage collection, and refuses the writes which would take
+// it over StorageMax with an *ErrExceeded. Before refusing a write, it runs an
+// emergency garbage collection, at most once every MinGCInterval. Garbage
+// collection waits for the pin lock though, which the writer may hold: writes
+// made under the pin lock are refused at once, the garbage collection only
+// running once the lock is released. Operations taking the pin lock should
+// call MakeRoom before.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("quota")
+
+var (
+	// MinGCInterval is the minimum time between two emergency garbage
+	// collections.
+	MinGCInterval = time.Minute
+
+	// GCWait bounds the time a blockstore write made without the pin lock
+	// waits for an emergency garbage collection.
+	GCWait = 10 * time.Second
+)
+
+// ErrExceeded is returned by the writes which would take the repo over its
+// StorageMax.
+type ErrExceeded struct {
+	Size       uint64 // size of the refused write
+	Usage      uint64 // repo usage when the write was refused
+	StorageMax uint64
+}
+
+func (e *ErrExceeded) Error() string {
+	return fmt.Sprintf("cannot store %s, the repo uses %s of its %s Datastore.StorageMax: unpin content and run 'ipfs repo gc', or raise the limit",
+		humanize.Bytes(e.Size), humanize.Bytes(e.Usage), humanize.Bytes(e.StorageMax))
+}
+
+// IsExceeded returns whether err is an *ErrExceeded.
+func IsExceeded(err error) bool {
+	_, ok := err.(*ErrExceeded)
+	return ok
+}
+
+// Quota keeps the usage of a repo under a maximum.
+type Quota struct {
+	max   uint64
+	usage func() (uint64, error)
+
+	lk       sync.Mutex
+	used     uint64
+	gc       func(ctx context.Context) error
+	gcDone   chan struct{} // non-nil while collecting
+	lastGC   time.Time
+	full     bool
+	rejected uint64
+	pinLocks int // pin locks taken through a Blockstore and still held
+}
+
+// New returns a Quota keeping the usage reported by usage under max.
+func New(max uint64, usage func() (uint64, error)) (*Quota, error) {
+	used, err := usage()
+	if err != nil {
+		return nil, err
+	}
+	return &Quota{max: max, usage: usage, used: used}, nil
+}
+
+// SetGC sets the garbage collection run when the repo is full. Without one,
+// the repo is only measured again.
+func (q *Quota) SetGC(gc func(ctx context.Context) error) {
+	q.lk.Lock()
+	q.gc = gc
+	q.lk.Unlock()
+}
+
+// Max returns the maximum usage of the repo.
+func (q *Quota) Max() uint64 {
+	return q.max
+}
+
+// Usage returns the estimated usage of the repo.
+func (q *Quota) Usage() uint64 {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	return q.used
+}
+
+// Rejected returns the number of writes refused so far.
+func (q *Quota) Rejected() uint64 {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	return q.rejected
+}
+
+// MakeRoom makes sure the repo can store size more bytes, collecting garbage
+// if needed. Unlike the writes, it waits for the garbage collection until ctx
+// is done, so it must be called before taking the pin lock.
+func (q *Quota) MakeRoom(ctx context.Context, size uint64) error {
+	if q.fits(size) {
+		return nil
+	}
+	if q.waitGC(ctx) && q.fits(size) {
+		return nil
+	}
+	return q.reject(size)
+}
+
+// reserve accounts for a write of size bytes, or refuses it. While the pin
+// lock is held, the garbage collection is started but not waited for, as it
+// can't run before the lock is released.
+func (q *Quota) reserve(size uint64) error {
+	if q.tryReserve(size) {
+		return nil
+	}
+
+	q.lk.Lock()
+	pinLocked := q.pinLocks > 0
+	q.lk.Unlock()
+	if pinLocked {
+		q.startGC()
+		return q.reject(size)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), GCWait)
+	defer cancel()
+	if q.waitGC(ctx) && q.tryReserve(size) {
+		return nil
+	}
+	return q.reject(size)
+}
+
+// pinLocked accounts for a pin lock taken, or released if delta is negative.
+func (q *Quota) pinLocked(delta int) {
+	q.lk.Lock()
+	q.pinLocks += delta
+	q.lk.Unlock()
+}
+
+// release gives back the room reserved for a write which failed, or freed by
+// a deletion.
+func (q *Quota) release(size uint64) {
+	q.lk.Lock()
+	if size > q.used {
+		q.used = 0
+	} else {
+		q.used -= size
+	}
+	q.lk.Unlock()
+}
+
+func (q *Quota) fits(size uint64) bool {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	return q.used+size <= q.max
+}
+
+func (q *Quota) tryReserve(size uint64) bool {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	if q.used+size > q.max {
+		return false
+	}
+	q.used += size
+	if q.full {
+		q.full = false
+		log.Infof("repo usage back under Datastore.StorageMax, accepting writes again")
+	}
+	return true
+}
+
+func (q *Quota) reject(size uint64) error {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	q.rejected++
+	if !q.full {
+		q.full = true
+		log.Warningf("repo full, refusing writes: %s of %s used", humanize.Bytes(q.used), humanize.Bytes(q.max))
+	}
+	return &ErrExceeded{Size: size, Usage: q.used, StorageMax: q.max}
+}
+
+// startGC starts a garbage collection unless one is running or the last one
+// is too recent. It returns the channel closed once the running garbage
+// collection finishes, or nil if there is none.
+func (q *Quota) startGC() chan struct{} {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	if q.gcDone == nil {
+		if time.Since(q.lastGC) < MinGCInterval {
+			return nil
+		}
+		q.gcDone = make(chan struct{})
+		go q.collect(q.gc, q.gcDone)
+	}
+	return q.gcDone
+}
+
+// waitGC waits until ctx is done for the running garbage collection, or for
+// a new one unless the last one is too recent. It returns false if there was
+// none to wait for, or if it didn't finish.
+func (q *Quota) waitGC(ctx context.Context) bool {
+	done := q.startGC()
+	if done == nil {
+		return false
+	}
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// collect runs gc, measures the repo again and closes done.
+func (q *Quota) collect(gc func(ctx context.Context) error, done chan struct{}) {
+	if gc != nil {
+		log.Warning("repo full, starting an emergency garbage collection")
+		if err := gc(context.Background()); err != nil {
+			log.Errorf("emergency garbage collection: %s", err)
+		}
+	}
+
+	used, err := q.usage()
+	if err != nil {
+		log.Errorf("could not measure the repo usage: %s", err)
+	}
+
+	q.lk.Lock()
+	if err == nil {
+		q.used = used
+	}
+	q.lastGC = time.Now()
+	q.gcDone = nil
+	q.lk.Unlock()
+	close(done)
+}
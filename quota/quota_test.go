@@ -0,0 +1,142 @@
+package quota
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+func newBlockstore(t *testing.T, max uint64) (*Blockstore, *uint64) {
+	base := bstore.NewGCBlockstore(bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())), bstore.NewGCLocker())
+	var used uint64
+	q, err := New(max, func() (uint64, error) { return used, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Blockstore{GCBlockstore: base, Quota: q}, &used
+}
+
+func TestBlockstoreQuota(t *testing.T) {
+	MinGCInterval = 0
+	bs, _ := newBlockstore(t, 10)
+
+	a := blocks.NewBlock([]byte("abcdef"))
+	if err := bs.Put(a); err != nil {
+		t.Fatal(err)
+	}
+	// storing a block again takes no room
+	if err := bs.Put(a); err != nil {
+		t.Fatal(err)
+	}
+	if u := bs.Quota.Usage(); u != 6 {
+		t.Fatalf("expected a usage of 6, got %d", u)
+	}
+
+	b := blocks.NewBlock([]byte("ghijkl"))
+	err := bs.Put(b)
+	if !IsExceeded(err) {
+		t.Fatalf("expected the quota to be exceeded, got %v", err)
+	}
+	if has, _ := bs.Has(b.Cid()); has {
+		t.Fatal("refused block was stored")
+	}
+	if bs.Quota.Rejected() != 1 {
+		t.Fatalf("expected 1 rejected write, got %d", bs.Quota.Rejected())
+	}
+
+	if err := bs.DeleteBlock(a.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmergencyGC(t *testing.T) {
+	MinGCInterval = 0
+	bs, used := newBlockstore(t, 10)
+
+	a := blocks.NewBlock([]byte("abcdef"))
+	if err := bs.Put(a); err != nil {
+		t.Fatal(err)
+	}
+	*used = 6
+
+	collected := 0
+	bs.Quota.SetGC(func(ctx context.Context) error {
+		collected++
+		if err := bs.DeleteBlock(a.Cid()); err != nil {
+			return err
+		}
+		*used = 0
+		return nil
+	})
+
+	b := blocks.NewBlock([]byte("ghijkl"))
+	if err := bs.Put(b); err != nil {
+		t.Fatal(err)
+	}
+	if collected != 1 {
+		t.Fatalf("expected 1 garbage collection, got %d", collected)
+	}
+
+	if err := bs.Quota.MakeRoom(context.Background(), 100); !IsExceeded(err) {
+		t.Fatalf("expected the quota to be exceeded, got %v", err)
+	}
+}
+
+func TestPinLockedWrite(t *testing.T) {
+	MinGCInterval = 0
+	// a write made under the pin lock would wait for the whole GCWait
+	defer func(d time.Duration) { GCWait = d }(GCWait)
+	GCWait = time.Hour
+
+	bs, used := newBlockstore(t, 10)
+	a := blocks.NewBlock([]byte("abcdef"))
+	if err := bs.Put(a); err != nil {
+		t.Fatal(err)
+	}
+	*used = 6
+
+	collected := make(chan struct{})
+	bs.Quota.SetGC(func(ctx context.Context) error {
+		defer bs.GCLock().Unlock()
+		if err := bs.DeleteBlock(a.Cid()); err != nil {
+			return err
+		}
+		*used = 0
+		close(collected)
+		return nil
+	})
+
+	unlocker := bs.PinLock()
+	b := blocks.NewBlock([]byte("ghijkl"))
+	if err := bs.Put(b); !IsExceeded(err) {
+		t.Fatalf("expected the quota to be exceeded, got %v", err)
+	}
+
+	// the garbage collection started, and runs once the lock is released
+	select {
+	case <-collected:
+		t.Fatal("garbage collected under the pin lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlocker.Unlock()
+	select {
+	case <-collected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the garbage collection to run")
+	}
+
+	if err := bs.Quota.MakeRoom(context.Background(), 6); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(b); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,6 +5,14 @@ import (
 	"strings"
 )
 
+// KeyNotFoundError is returned by MapGetKV when the requested key, or one of
+// its parents, is not set.
+type KeyNotFoundError string
+
+func (e KeyNotFoundError) Error() string {
+	return string(e)
+}
+
 func MapGetKV(v map[string]interface{}, key string) (interface{}, error) {
 	var ok bool
 	var mcursor map[string]interface{}
@@ -21,7 +29,7 @@ func MapGetKV(v map[string]interface{}, key string) (interface{}, error) {
 
 		cursor, ok = mcursor[part]
 		if !ok {
-			return nil, fmt.Errorf("%s key has no attributes", sofar)
+			return nil, KeyNotFoundError(fmt.Sprintf("%s key has no attributes", sofar))
 		}
 	}
 	return cursor, nil
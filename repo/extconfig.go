@@ -0,0 +1,73 @@
+package repo
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ipfs/go-ipfs/repo/common"
+)
+
+// ExtendedConfigKeys lists the configuration keys used by go-ipfs which are
+// not (yet) part of config.Config. They can only be read with GetConfigKey and
+// SetConfig implementations must preserve them.
+var ExtendedConfigKeys = []string{
+	"Gateway.PublicGateways",
+	"Gateway.Timeouts",
+	"API.Tokens",
+	"Datastore.EnforceStorageMax",
+	"Pinning.RemoteServices",
+	"Pinning.Service",
+}
+
+// ConfigReplacer is implemented by the repos which can replace their whole
+// config document. Unlike SetConfig, ReplaceConfig takes the extended keys
+// from the document.
+type ConfigReplacer interface {
+	ReplaceConfig(doc map[string]interface{}) error
+}
+
+// ConfigStamp identifies a version of the config of a repo.
+type ConfigStamp struct {
+	// Writes counts the writes of the config by the repo.
+	Writes uint64
+
+	// ModTime and Size are those of the config file, which also tell
+	// about edits made outside of the repo.
+	ModTime time.Time
+	Size    int64
+}
+
+// Equal returns whether s and o identify the same config.
+func (s ConfigStamp) Equal(o ConfigStamp) bool {
+	return s.Writes == o.Writes && s.ModTime.Equal(o.ModTime) && s.Size == o.Size
+}
+
+// ConfigStamper is implemented by the repos which can tell cheaply whether
+// their config changed, without reading it.
+type ConfigStamper interface {
+	ConfigStamp() (ConfigStamp, error)
+}
+
+// ReadExtendedConfig decodes the configuration value stored under key into
+// out. It returns false, leaving out untouched, if the key is not set.
+func ReadExtendedConfig(r Repo, key string, out interface{}) (bool, error) {
+	v, err := r.GetConfigKey(key)
+	if err != nil {
+		if _, ok := err.(common.KeyNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	if v == nil {
+		return false, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	filestore "github.com/ipfs/go-filestore"
 	keystore "github.com/ipfs/go-ipfs/keystore"
@@ -92,6 +93,9 @@ var (
 // FSRepo represents an IPFS FileSystem Repo. It is safe for use by multiple
 // callers.
 type FSRepo struct {
+	// configWrites counts the writes of the config file, see ConfigStamp.
+	// First for the alignment of atomic operations on 32-bit platforms.
+	configWrites uint64
 	// has Close been called already
 	closed bool
 	// path is the file-system path
@@ -383,18 +387,6 @@ func (r *FSRepo) openConfig() error {
 	return nil
 }
 
-func (r *FSRepo) openKeystore() error {
-	ksp := filepath.Join(r.path, "keystore")
-	ks, err := keystore.NewFSKeystore(ksp)
-	if err != nil {
-		return err
-	}
-
-	r.keystore = ks
-
-	return nil
-}
-
 // openDatastore returns an error if the config file is not present.
 func (r *FSRepo) openDatastore() error {
 	if r.config.Datastore.Type != "" || r.config.Datastore.Path != "" {
@@ -525,8 +517,9 @@ func (r *FSRepo) BackupConfig(prefix string) (string, error) {
 	return orig.Name(), nil
 }
 
-// setConfigUnsynced is for private use.
-func (r *FSRepo) setConfigUnsynced(updated *config.Config) error {
+// setConfigUnsynced is for private use. The extended config keys are taken
+// from extended, or kept from the config on disk when extended is nil.
+func (r *FSRepo) setConfigUnsynced(updated *config.Config, extended map[string]interface{}) error {
 	configFilename, err := config.Filename(r.path)
 	if err != nil {
 		return err
@@ -538,22 +531,52 @@ func (r *FSRepo) setConfigUnsynced(updated *config.Config) error {
 	if err := serialize.ReadConfigFile(configFilename, &mapconf); err != nil {
 		return err
 	}
+	if extended == nil {
+		extended = mapconf
+	}
 	m, err := config.ToMap(updated)
 	if err != nil {
 		return err
 	}
+	// the extended keys live inside sections that are overwritten below, so
+	// they have to be set explicitly.
+	for _, k := range repo.ExtendedConfigKeys {
+		v, err := common.MapGetKV(extended, k)
+		if err != nil {
+			deleteConfigKey(m, k)
+			deleteConfigKey(mapconf, k)
+			continue
+		}
+		if err := common.MapSetKV(m, k, v); err != nil {
+			return err
+		}
+	}
 	for k, v := range m {
 		mapconf[k] = v
 	}
 	if err := serialize.WriteConfigFile(configFilename, mapconf); err != nil {
 		return err
 	}
+	atomic.AddUint64(&r.configWrites, 1)
 	// Do not use `*r.config = ...`. This will modify the *shared* config
 	// returned by `r.Config`.
 	r.config = updated
 	return nil
 }
 
+// deleteConfigKey removes the dotted key from the config map, if set.
+func deleteConfigKey(mapconf map[string]interface{}, key string) {
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		sub, ok := mapconf[part].(map[string]interface{})
+		if !ok {
+			return
+		}
+		mapconf = sub
+	}
+	delete(mapconf, parts[len(parts)-1])
+}
+
 // SetConfig updates the FSRepo's config. The user must not modify the config
 // object after calling this method.
 func (r *FSRepo) SetConfig(updated *config.Config) error {
@@ -562,7 +585,43 @@ func (r *FSRepo) SetConfig(updated *config.Config) error {
 	packageLock.Lock()
 	defer packageLock.Unlock()
 
-	return r.setConfigUnsynced(updated)
+	return r.setConfigUnsynced(updated, nil)
+}
+
+// ReplaceConfig replaces the FSRepo's config with the given document. Unlike
+// SetConfig, the extended config keys are taken from the document, and
+// removed when it doesn't set them.
+func (r *FSRepo) ReplaceConfig(doc map[string]interface{}) error {
+	packageLock.Lock()
+	defer packageLock.Unlock()
+
+	if r.closed {
+		return errors.New("repo is closed")
+	}
+
+	updated, err := config.FromMap(doc)
+	if err != nil {
+		return err
+	}
+	return r.setConfigUnsynced(updated, doc)
+}
+
+// ConfigStamp returns the stamp of the current config, which changes
+// whenever the config file is written, by the repo or not.
+func (r *FSRepo) ConfigStamp() (repo.ConfigStamp, error) {
+	filename, err := config.Filename(r.path)
+	if err != nil {
+		return repo.ConfigStamp{}, err
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return repo.ConfigStamp{}, err
+	}
+	return repo.ConfigStamp{
+		Writes:  atomic.LoadUint64(&r.configWrites),
+		ModTime: fi.ModTime(),
+		Size:    fi.Size(),
+	}, nil
 }
 
 // GetConfigKey retrieves only the value of a particular key.
@@ -665,7 +724,7 @@ func (r *FSRepo) SetConfigKey(key string, value interface{}) error {
 	if err := serialize.WriteConfigFile(filename, mapconf); err != nil {
 		return err
 	}
-	return r.setConfigUnsynced(conf) // TODO roll this into this method
+	return r.setConfigUnsynced(conf, nil) // TODO roll this into this method
 }
 
 // Datastore returns a repo-owned datastore. If FSRepo is Closed, return value
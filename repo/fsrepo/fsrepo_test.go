@@ -116,3 +116,32 @@ func TestOpenMoreThanOnceInSameProcess(t *testing.T) {
 	assert.Nil(r1.Close(), t)
 	assert.Nil(r2.Close(), t)
 }
+
+func TestExtendedConfigKeys(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("", t)
+	assert.Nil(Init(path, &config.Config{Datastore: config.DefaultDatastoreConfig()}), t)
+	r, err := Open(path)
+	assert.Nil(err, t)
+	defer r.Close()
+
+	stamp, err := r.(*FSRepo).ConfigStamp()
+	assert.Nil(err, t)
+	tokens := map[string]interface{}{"a": map[string]interface{}{"Secret": "s"}}
+	assert.Nil(r.SetConfigKey("API.Tokens", tokens), t)
+	changed, err := r.(*FSRepo).ConfigStamp()
+	assert.Nil(err, t)
+	assert.True(!changed.Equal(stamp), t, "the config stamp should change with the config")
+
+	cfg, err := r.Config()
+	assert.Nil(err, t)
+	assert.Nil(r.SetConfig(cfg), t, "SetConfig should be successful")
+	_, err = r.GetConfigKey("API.Tokens")
+	assert.Nil(err, t, "SetConfig should keep the extended keys")
+
+	doc, err := config.ToMap(cfg)
+	assert.Nil(err, t)
+	assert.Nil(r.(*FSRepo).ReplaceConfig(doc), t, "ReplaceConfig should be successful")
+	_, err = r.GetConfigKey("API.Tokens")
+	assert.Err(err, t, "ReplaceConfig should remove the extended keys missing from the document")
+}
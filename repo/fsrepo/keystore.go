@@ -0,0 +1,164 @@
+package fsrepo
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	keystore "github.com/ipfs/go-ipfs/keystore"
+	"github.com/ipfs/go-ipfs/repo/common"
+
+	lockfile "github.com/ipfs/go-fs-lock"
+	config "github.com/ipfs/go-ipfs-config"
+	serialize "github.com/ipfs/go-ipfs-config/serialize"
+)
+
+const (
+	// EnvKeystorePassphrase is the environment variable holding the
+	// passphrase of an encrypted keystore.
+	EnvKeystorePassphrase = "IPFS_KEYSTORE_PASSPHRASE"
+
+	// EnvKeystorePassphraseFile is the environment variable holding the path
+	// of a file containing the passphrase of an encrypted keystore.
+	EnvKeystorePassphraseFile = "IPFS_KEYSTORE_PASSPHRASE_FILE"
+)
+
+const keystoreDir = "keystore"
+
+// ErrNoKeystorePassphrase is returned when opening a repo with an encrypted
+// keystore without providing its passphrase.
+var ErrNoKeystorePassphrase = errors.New("the keystore is encrypted, set " + EnvKeystorePassphrase + " or " + EnvKeystorePassphraseFile + " to unlock it")
+
+// KeystorePassphrase returns the keystore passphrase set in the environment,
+// or nil if there is none.
+func KeystorePassphrase() ([]byte, error) {
+	if p := os.Getenv(EnvKeystorePassphrase); p != "" {
+		return []byte(p), nil
+	}
+
+	if fn := os.Getenv(EnvKeystorePassphraseFile); fn != "" {
+		return ReadPassphraseFile(fn)
+	}
+
+	return nil, nil
+}
+
+// ReadPassphraseFile reads a passphrase from the given file, ignoring a
+// trailing newline.
+func ReadPassphraseFile(fn string) ([]byte, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	p := strings.TrimRight(string(b), "\r\n")
+	if p == "" {
+		return nil, fmt.Errorf("passphrase file %s is empty", fn)
+	}
+	return []byte(p), nil
+}
+
+// EncryptKeystore encrypts all keys in the keystore of the repo at repoPath
+// with passphrase. The private key of the node identity is moved from the
+// config into the encrypted keystore. The repo must not be in use.
+//
+// Running it again on an encrypted keystore encrypts the keys left
+// unencrypted by an interrupted run.
+func EncryptKeystore(repoPath string, passphrase []byte) error {
+	packageLock.Lock()
+	defer packageLock.Unlock()
+
+	r, err := newFSRepo(repoPath)
+	if err != nil {
+		return err
+	}
+
+	if err := checkInitialized(r.path); err != nil {
+		return err
+	}
+
+	lk, err := lockfile.Lock(r.path, LockFile)
+	if err != nil {
+		return err
+	}
+	defer lk.Close()
+
+	ks, err := keystore.EncryptFSKeystore(filepath.Join(r.path, keystoreDir), passphrase)
+	if err != nil {
+		return err
+	}
+
+	filename, err := config.Filename(r.path)
+	if err != nil {
+		return err
+	}
+	var mapconf map[string]interface{}
+	if err := serialize.ReadConfigFile(filename, &mapconf); err != nil {
+		return err
+	}
+
+	pkval, err := common.MapGetKV(mapconf, config.PrivKeySelector)
+	if err != nil {
+		return err
+	}
+	pkstr, ok := pkval.(string)
+	if !ok {
+		return errors.New("private key in config was not a string")
+	}
+	if pkstr == "" {
+		// already moved
+		return nil
+	}
+
+	sk, err := (&config.Identity{PrivKey: pkstr}).DecodePrivateKey("")
+	if err != nil {
+		return err
+	}
+	if err := ks.PutIdentity(sk); err != nil {
+		return err
+	}
+
+	// only remove the key from the config once it is safely stored
+	if err := common.MapSetKV(mapconf, config.PrivKeySelector, ""); err != nil {
+		return err
+	}
+	return serialize.WriteConfigFile(filename, mapconf)
+}
+
+// openKeystore opens the keystore of the repo, unlocking it with the
+// passphrase from the environment if it is encrypted.
+func (r *FSRepo) openKeystore() error {
+	ksp := filepath.Join(r.path, keystoreDir)
+
+	encrypted, err := keystore.IsEncrypted(ksp)
+	if err != nil {
+		return err
+	}
+
+	if !encrypted {
+		ks, err := keystore.NewFSKeystore(ksp)
+		if err != nil {
+			return err
+		}
+		r.keystore = ks
+		return nil
+	}
+
+	passphrase, err := KeystorePassphrase()
+	if err != nil {
+		return err
+	}
+	if passphrase == nil {
+		return ErrNoKeystorePassphrase
+	}
+
+	ks, err := keystore.OpenEncryptedKeystore(ksp, passphrase)
+	if err != nil {
+		return err
+	}
+	r.keystore = ks
+	return nil
+}